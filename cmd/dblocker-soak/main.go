@@ -0,0 +1,316 @@
+// Command dblocker-soak runs a configurable mixed workload against a dblocker Store for a long period,
+// and reports invariant violations, leaked resources, and wait time distributions.
+//
+// For example, to run 64 workers against 16 sqlite ids for 2 hours with 20% writes and 5% cancelled requests:
+//
+//	go run ./cmd/dblocker-soak -driver sqlite3 -dsn ":memory:" -ids 16 -workers 64 -writes 0.2 -cancel 0.05 -duration 2h
+//
+// dblocker-soak exits with status 1 if any violations or leaks are found.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/calmdocs/dblocker"
+	_ "github.com/calmdocs/dblocker/drivers/mock"
+	_ "github.com/calmdocs/dblocker/drivers/mysql"
+	_ "github.com/calmdocs/dblocker/drivers/postgres"
+	_ "github.com/calmdocs/dblocker/drivers/sqlite"
+)
+
+type config struct {
+	driverName     string
+	dataSourceName string
+	ids            int
+	workers        int
+	writeRatio     float64
+	cancelRate     float64
+	holdTime       time.Duration
+	unlockTimeout  time.Duration
+	duration       time.Duration
+	reportInterval time.Duration
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.driverName, "driver", "sqlite3", "database driver (e.g. sqlite3, postgres, mysql, or mock)")
+	flag.StringVar(&cfg.dataSourceName, "dsn", ":memory:", "data source name")
+	flag.IntVar(&cfg.ids, "ids", 16, "number of ids")
+	flag.IntVar(&cfg.workers, "workers", 64, "number of concurrent workers")
+	flag.Float64Var(&cfg.writeRatio, "writes", 0.2, "fraction of requests which are RW requests")
+	flag.Float64Var(&cfg.cancelRate, "cancel", 0.05, "fraction of requests which are cancelled while waiting or holding")
+	flag.DurationVar(&cfg.holdTime, "hold", time.Millisecond, "maximum time that each hold is held")
+	flag.DurationVar(&cfg.unlockTimeout, "unlock-timeout", 0, "Store unlock timeout (zero means no timeout)")
+	flag.DurationVar(&cfg.duration, "duration", time.Minute, "soak test duration")
+	flag.DurationVar(&cfg.reportInterval, "interval", 10*time.Second, "progress report interval")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	failed, err := run(ctx, cfg)
+	if err != nil {
+		fmt.Println("dblocker-soak error:", err.Error())
+		os.Exit(2)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// soak is the state of a soak test run
+type soak struct {
+	cfg config
+	s   *dblocker.Store
+
+	granted    atomic.Int64
+	cancelled  atomic.Int64
+	errs       atomic.Int64
+	violations atomic.Int64
+
+	// active are the granted holds for each id, and whether each hold is a RW hold
+	activeMu sync.Mutex
+	active   []map[*dblocker.Hold]bool
+
+	// waits are samples of the wait times of granted requests
+	waits waitSamples
+}
+
+func run(ctx context.Context, cfg config) (failed bool, err error) {
+	if cfg.ids <= 0 || cfg.workers <= 0 {
+		return false, fmt.Errorf("ids and workers must be positive")
+	}
+
+	s, err := dblocker.New(context.Background(), cfg.driverName, cfg.dataSourceName, false)
+	if err != nil {
+		return false, err
+	}
+	if cfg.unlockTimeout > 0 {
+		s.SetUnlockTimeout(&cfg.unlockTimeout)
+	} else {
+		s.SetUnlockTimeout(nil)
+	}
+	so := &soak{
+		cfg:    cfg,
+		s:      s,
+		active: make([]map[*dblocker.Hold]bool, cfg.ids),
+	}
+	for i := range so.active {
+		so.active[i] = make(map[*dblocker.Hold]bool)
+	}
+	s.Strict = true
+	s.Hooks.OnInvariantViolation = func(err error) {
+		so.violations.Add(1)
+		fmt.Println("violation:", err.Error())
+	}
+
+	// Run the workers until the duration has elapsed or the soak test is interrupted
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.workers; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			so.worker(ctx, rand.New(rand.NewSource(seed)))
+		}(time.Now().UnixNano() + int64(i))
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(cfg.reportInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+			so.report(time.Since(start))
+		}
+	}
+	wg.Wait()
+	so.report(time.Since(start))
+
+	// Check that the Store cleans up after Stop
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer stopCancel()
+	err = s.Stop(stopCtx)
+	if err != nil {
+		return false, fmt.Errorf("stop error: %w", err)
+	}
+	leaks := so.waitForCleanup(stopCtx)
+	if leaks != "" {
+		fmt.Println("leaks:", leaks)
+	}
+
+	fmt.Println("wait times:", so.waits.summary())
+	return so.violations.Load() > 0 || leaks != "", nil
+}
+
+// worker makes requests for random ids until ctx is done
+func (so *soak) worker(ctx context.Context, rnd *rand.Rand) {
+	for ctx.Err() == nil {
+		id := rnd.Intn(so.cfg.ids)
+		isRW := rnd.Float64() < so.cfg.writeRatio
+		holdTime := time.Duration(rnd.Int63n(int64(so.cfg.holdTime) + 1))
+
+		// Cancelled requests are cancelled at a random time while waiting or holding
+		var reqCtx context.Context
+		var reqCancel context.CancelFunc
+		if rnd.Float64() < so.cfg.cancelRate {
+			reqCtx, reqCancel = context.WithTimeout(ctx, time.Duration(rnd.Int63n(2*int64(so.cfg.holdTime)+1)))
+		} else {
+			reqCtx, reqCancel = context.WithCancel(ctx)
+		}
+		so.request(reqCtx, id, isRW, holdTime)
+		reqCancel()
+	}
+}
+
+// request makes a single request, and checks mutual exclusion while it is held
+func (so *soak) request(ctx context.Context, id int, isRW bool, holdTime time.Duration) {
+	hold, tag := so.s.ReadHold, "soak-read"
+	if isRW {
+		hold, tag = so.s.RWHold, "soak-rw"
+	}
+
+	requestedAt := time.Now()
+	h, err := hold(id, ctx, tag)
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, dblocker.ErrStoreClosed) {
+			so.cancelled.Add(1)
+			return
+		}
+		so.errs.Add(1)
+		fmt.Println("request error:", err.Error())
+		return
+	}
+	so.granted.Add(1)
+	so.waits.add(time.Since(requestedAt))
+
+	so.enter(id, h, isRW)
+	if so.cfg.driverName != "mock" {
+		_, err = h.DB().ExecContext(h.Context(), "SELECT 1;")
+		if err != nil && h.Err() == nil && ctx.Err() == nil {
+			so.errs.Add(1)
+			fmt.Println("query error:", err.Error())
+		}
+	}
+	select {
+	case <-time.After(holdTime):
+	case <-h.Done():
+	}
+	so.leave(id, h)
+	h.Release()
+}
+
+// enter and leave track the granted holds for an id, and enter records a violation if a hold is granted while a conflicting hold is active.
+// Holds which have already been released (e.g. because the request context was cancelled) are ignored.
+func (so *soak) enter(id int, h *dblocker.Hold, isRW bool) {
+	so.activeMu.Lock()
+	defer so.activeMu.Unlock()
+
+	if h.Err() != nil {
+		return
+	}
+	for other, otherIsRW := range so.active[id] {
+		if other.Err() == nil && (isRW || otherIsRW) {
+			so.violations.Add(1)
+			fmt.Printf("violation: id %d: %s granted while %s active\n", id, h.Tag(), other.Tag())
+		}
+	}
+	so.active[id][h] = isRW
+}
+
+func (so *soak) leave(id int, h *dblocker.Hold) {
+	so.activeMu.Lock()
+	defer so.activeMu.Unlock()
+
+	delete(so.active[id], h)
+}
+
+// waitForCleanup waits until the Store has released all of its resources, and otherwise returns a description of the leaked resources
+func (so *soak) waitForCleanup(ctx context.Context) (leaks string) {
+	for {
+		r := so.s.Resources()
+		if r.Goroutines == 0 && r.OpenDBs == 0 && r.Groups == 0 && r.Holds == 0 && r.Waiters == 0 {
+			return ""
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Sprintf("%+v", r)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (so *soak) report(elapsed time.Duration) {
+	r := so.s.Resources()
+	fmt.Printf("%v: granted %d, cancelled %d, errors %d, violations %d, goroutines %d, open dbs %d, wait %s\n",
+		elapsed.Round(time.Second),
+		so.granted.Load(),
+		so.cancelled.Load(),
+		so.errs.Load(),
+		so.violations.Load(),
+		r.Goroutines,
+		r.OpenDBs,
+		so.waits.summary(),
+	)
+}
+
+// maxWaitSamples is the maximum number of wait time samples kept using reservoir sampling
+const maxWaitSamples = 100000
+
+type waitSamples struct {
+	sync.Mutex
+
+	count   int64
+	max     time.Duration
+	samples []time.Duration
+	rnd     *rand.Rand
+}
+
+func (w *waitSamples) add(d time.Duration) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.rnd == nil {
+		w.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	w.count++
+	if d > w.max {
+		w.max = d
+	}
+	if len(w.samples) < maxWaitSamples {
+		w.samples = append(w.samples, d)
+		return
+	}
+	if i := w.rnd.Int63n(w.count); i < maxWaitSamples {
+		w.samples[i] = d
+	}
+}
+
+// summary returns the 50th, 90th, 99th, and 99.9th percentile and maximum wait times
+func (w *waitSamples) summary() string {
+	w.Lock()
+	sorted := append([]time.Duration(nil), w.samples...)
+	max := w.max
+	w.Unlock()
+
+	if len(sorted) == 0 {
+		return "no samples"
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return fmt.Sprintf("p50 %v, p90 %v, p99 %v, p99.9 %v, max %v", percentile(0.5), percentile(0.9), percentile(0.99), percentile(0.999), max)
+}
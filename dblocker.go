@@ -1,313 +1,651 @@
+// Package dblocker (github.com/calmdocs/dblocker) is the v1 compatibility package of dblocker, which forwards to the github.com/calmdocs/dblocker/v2 module.
+//
+// The types of this package are aliases of the v2 types, so a *Store created using this package is a v2 *Store:
+// the v1 API (e.g. RWGetDB(id, ctx, tag)) and the context-first v2 API (e.g. RW(ctx, id, dblocker.WithTag(tag))) can be used on the same Store,
+// and share the same locks and shared database sessions, so existing code can migrate to the v2 module incrementally.
+// New features are only added to the v2 module.
 package dblocker
 
 import (
 	"context"
-	"database/sql"
-	"fmt"
-	"sync"
+	"iter"
 	"time"
 
+	v2 "github.com/calmdocs/dblocker/v2"
 	"github.com/jmoiron/sqlx"
 )
 
-// Store is the dblocker store
-type Store struct {
-	sync.Mutex
+type (
+	// AccessMode is the type of database access request
+	AccessMode = v2.AccessMode
 
-	Ctx context.Context
+	// AcquireRequest describes a database access request passed to the OnAcquireRequested hook.
+	AcquireRequest = v2.AcquireRequest
 
-	m             map[interface{}]*Group
-	connectDBFunc func(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (db *sqlx.DB, err error)
+	// AdaptiveStatementTimeout adjusts the statement timeout for each id based on the p99 of the query durations observed for the id (see ObserveQuery),
+	// so that rare slow-but-legitimate ids are not cancelled while fast ids keep a tight timeout.
+	AdaptiveStatementTimeout = v2.AdaptiveStatementTimeout
 
-	DriverName       string
-	DataSourceName   string
-	UnlockTimeout    *time.Duration
-	StatementTimeout *time.Duration
-	debug            bool
+	// BlockedError is returned when a request waits for longer than the Store BlockerThreshold and then times out or is cancelled.
+	BlockedError = v2.BlockedError
+
+	// Blocker describes a Hold which was active when a request timed out (or was cancelled) while waiting for access to the same id (see BlockedError)
+	Blocker = v2.Blocker
+
+	// Budget is a process wide limit on the total number of open database connections, which can be shared by several Stores (e.g. one Store for each database cluster, see the Store ConnectionBudget).
+	Budget = v2.Budget
+
+	// Cache is a backend for caching the results of read queries for each id.
+	Cache = v2.Cache
+
+	// Capabilities are the features supported by a database driver
+	Capabilities = v2.Capabilities
+
+	// Config is a declarative Store configuration, which can be loaded from JSON (see LoadConfigFile), YAML (using a YAML library), or environment variables (see ConfigFromEnv).
+	Config = v2.Config
+
+	// ConnectRequest describes an attempt to connect a database session for an id
+	ConnectRequest = v2.ConnectRequest
+
+	// ConnectionStatus describes the attempts to connect the shared database session for an id
+	ConnectionStatus = v2.ConnectionStatus
+
+	// Connector connects to the database for a ConnectRequest.
+	Connector = v2.Connector
+
+	// ConsistencyToken is the write position of the primary database after a RW hold (e.g. a postgres LSN or a mysql GTID set, see ReleaseWithToken).
+	ConsistencyToken = v2.ConsistencyToken
+
+	// Contention describes the requests for an id within the Store ContentionWindow
+	Contention = v2.Contention
+
+	// DeadlinePolicy controls how the unlockTimeout and the request context deadline are combined to release each Hold (see the Store DeadlinePolicy setting).
+	DeadlinePolicy = v2.DeadlinePolicy
+
+	// Defaults are the default tag prefix, Metadata, and timeouts for the requests made using a View (see WithDefaults)
+	Defaults = v2.Defaults
+
+	// DriverSpec describes how the DefaultConnectDBFunc connects to and configures a database type
+	DriverSpec = v2.DriverSpec
+
+	// Duration is a time.Duration which is encoded as a string (e.g. "2m30s")
+	Duration = v2.Duration
+
+	// Event is a record of a completed database access request, or of another Store lifecycle event delivered to subscribers (see Subscribe and Kind)
+	Event = v2.Event
+
+	// EventFilter selects the Events delivered to a subscriber (see Subscribe).
+	EventFilter = v2.EventFilter
+
+	// EventKind is the lifecycle stage described by an Event
+	EventKind = v2.EventKind
+
+	// Group is a group storing the shared database for an id
+	Group = v2.Group
+
+	// GroupInfo describes an id with a group (i.e. with a shared database session or waiting to connect one)
+	GroupInfo = v2.GroupInfo
+
+	// Hold is a granted database access request for an id.
+	Hold = v2.Hold
+
+	// HoldSet is a set of Holds acquired as part of one logical operation (e.g. a workflow that writes to several ids), which are released together.
+	HoldSet = v2.HoldSet
+
+	// Hooks are optional functions called by the Store during the lifecycle of database access requests.
+	Hooks = v2.Hooks
+
+	// HotIDStore saves the ids that were recently active when a Store is stopped, and loads them when the next Store is started (see WarmHotIDs),
+	// so that the shared database sessions for the most active ids can be connected before requests arrive (e.g. after a deploy).
+	HotIDStore = v2.HotIDStore
+
+	// ID is a composite id made from a list of parts (e.g. IDOf("org", 12, "db", 3)), which can be used as the id for any Store request.
+	ID = v2.ID
+
+	// IDCodec serializes ids for backends shared between processes (e.g. the pgsignal NOTIFY payloads and advisory lock keys, see AdvisoryKey),
+	// so that every process refers to an id using the same string, and ids sent by other processes are decoded to the ids used by the Store.
+	IDCodec = v2.IDCodec
+
+	// JanitorReport describes the inconsistent groups found and repaired by a janitor pass (see CheckGroups)
+	JanitorReport = v2.JanitorReport
+
+	// Key is the id used for requests when the Store Keyer is set (see Keyer)
+	Key = v2.Key
+
+	// KeyProvider returns the encryption key for the encrypted sqlite (SQLCipher) database for an id
+	KeyProvider = v2.KeyProvider
+
+	// KeyProviderFunc is a function that implements KeyProvider
+	KeyProviderFunc = v2.KeyProviderFunc
+
+	// MapError is returned by MapIDs when any id fails, and lists the failed ids and their errors in the order of the ids
+	MapError = v2.MapError
+
+	// MapProgress describes the progress of a MapIDs call after an id has been processed
+	MapProgress = v2.MapProgress
+
+	// MemoryCache is an in-memory Cache
+	MemoryCache = v2.MemoryCache
+
+	// Metadata is structured information about a database access request.
+	Metadata = v2.Metadata
+
+	// MetricsSink receives metrics from the Store, for example to export to Prometheus.
+	MetricsSink = v2.MetricsSink
+
+	// Migration is the exclusive access to an id held by a migration function run using Migrate
+	Migration = v2.Migration
+
+	// MigrationOptions are the options for a long running schema change made using Migrate
+	MigrationOptions = v2.MigrationOptions
+
+	// MigrationProgress is the progress of a migration reported by Heartbeat
+	MigrationProgress = v2.MigrationProgress
+
+	// Notification is a postgres NOTIFY message received on the LISTEN channel for an id
+	Notification = v2.Notification
+
+	// Outcome is the result of a database access request
+	Outcome = v2.Outcome
+
+	// PingStrategy controls when the shared database session for an id is checked using Ping
+	PingStrategy = v2.PingStrategy
+
+	// Profile is a set of default settings for a database driver and workload
+	Profile = v2.Profile
+
+	// Progress describes a request that is waiting for access to the database for an id
+	Progress = v2.Progress
+
+	// RateLimit is a token bucket rate limit
+	RateLimit = v2.RateLimit
+
+	// ReadHandle is a read grant (see AcquireRead), which only has query accessors, so that the type system prevents writes through read grants.
+	ReadHandle = v2.ReadHandle
+
+	// ReloadReport lists the settings changed by Reload.
+	ReloadReport = v2.ReloadReport
+
+	// Replica is a read replica of the database, to which read holds are routed (see the Store Replicas setting)
+	Replica = v2.Replica
+
+	// ReplicaMetricsSink is optionally implemented by the Store MetricsSink to receive read replica metrics (see the Store Replicas setting),
+	// for example exported to Prometheus as a dblocker_replica_lag_seconds gauge and a dblocker_replica_evicted gauge with a replica label
+	ReplicaMetricsSink = v2.ReplicaMetricsSink
+
+	// ReplicaStatus describes the replication lag of a read replica, and whether read holds are routed to the replica
+	ReplicaStatus = v2.ReplicaStatus
+
+	// Report summarises the use of the Store during a single run (i.e. between Start and Stop, see Hooks.OnStop and Store.Report).
+	Report = v2.Report
+
+	// Request is a database access request
+	Request = v2.Request
+
+	// Resources reports the resources currently used by a Store.
+	Resources = v2.Resources
+
+	// RetryPolicy controls how RWGetDBWithRetry retries requests which fail for transient reasons (see IsRetryable)
+	RetryPolicy = v2.RetryPolicy
+
+	// Sampler selects the completed requests which are recorded in the recent events (see RecentEvents) and passed to the OnReleased hook (see the Store Sampler setting),
+	// so that heavy traffic does not overwhelm the observability pipeline while rare slow and failed requests are always recorded.
+	Sampler = v2.Sampler
+
+	// SchedulerPolicy selects how the requests for each id are granted access (see the Store Scheduler setting).
+	SchedulerPolicy = v2.SchedulerPolicy
+
+	// SessionResetPolicy controls how session state (e.g. SET variables, temporary tables, and advisory locks) is reset on the shared database session for an id between RW holds
+	SessionResetPolicy = v2.SessionResetPolicy
+
+	// Stats describes the current state of the Store
+	Stats = v2.Stats
+
+	// Store is the dblocker store
+	Store = v2.Store
+
+	// TagWait is the total wait time for requests with a tag
+	TagWait = v2.TagWait
+
+	// TaskGroup starts functions in new goroutines and collects their errors.
+	TaskGroup = v2.TaskGroup
+
+	// TeardownPolicy controls when the shared database session for an id is closed
+	TeardownPolicy = v2.TeardownPolicy
+
+	// Tiered composes Stores (e.g. an in-process Store and a distributed Store) so that each request must be granted by every Store.
+	Tiered = v2.Tiered
+
+	// TieredHold is a granted Tiered request, holding one Hold for each Store
+	TieredHold = v2.TieredHold
+
+	// View is a handle for making requests using a Store with Defaults, which can be injected into each component of a service.
+	View = v2.View
+
+	// WaitBucket is the number of requests that waited for at most the UpperBound
+	WaitBucket = v2.WaitBucket
+
+	// WaitDistribution is the distribution of the time spent waiting for access by the requests with a tag and access mode (including requests that timed out or were cancelled while waiting)
+	WaitDistribution = v2.WaitDistribution
+
+	// WaiterInfo describes a request waiting for access to the database for an id
+	WaiterInfo = v2.WaiterInfo
+
+	// WriteHandle is an exclusive RW grant (see AcquireWrite), which has the query accessors of a ReadHandle and also the accessors which can write
+	WriteHandle = v2.WriteHandle
+)
+
+const (
+	// AccessRW is a RWGetDB request for exclusive access to the shared database session
+	AccessRW = v2.AccessRW
+
+	// AccessRWSeparate is a RWGetDBWithTimeout request for exclusive access using a new database session
+	AccessRWSeparate = v2.AccessRWSeparate
+
+	// AccessRead is a ReadGetDB request for shared access to the shared database session
+	AccessRead = v2.AccessRead
+
+	// AccessStream is a StreamHold request for shared access to the shared database session for a long streaming read
+	AccessStream = v2.AccessStream
+
+	// DeadlineCaller releases each Hold when the request context deadline expires if the request context has a deadline
+	DeadlineCaller = v2.DeadlineCaller
+
+	// DeadlineEarliest releases each Hold when either the unlockTimeout or the request context deadline expires, whichever is earlier (default)
+	DeadlineEarliest = v2.DeadlineEarliest
+
+	// DeadlineUnlockTimeout releases each Hold when the unlockTimeout expires, and the request context deadline does not release the Hold
+	DeadlineUnlockTimeout = v2.DeadlineUnlockTimeout
+
+	// DefaultConnectionLabel is the label of the database sessions connected by the Store when LabelConnections is true and ConnectionLabel is nil
+	DefaultConnectionLabel = v2.DefaultConnectionLabel
+
+	// DefaultHotIDCount is the number of recently active ids saved to the Store HotIDs if the Store HotIDCount is zero
+	DefaultHotIDCount = v2.DefaultHotIDCount
+
+	// DefaultMigrationLease is the lease duration used by Migrate if the MigrationOptions Lease is zero
+	DefaultMigrationLease = v2.DefaultMigrationLease
+
+	// EventAcquired means that a hold was granted
+	EventAcquired = v2.EventAcquired
+
+	// EventConnect means that the Store attempted to connect the shared database session for an id (Err is set if the attempt failed)
+	EventConnect = v2.EventConnect
+
+	// EventEvict means that the shared database session for an id was closed and the group for the id deleted (see TeardownPolicy and Evict)
+	EventEvict = v2.EventEvict
+
+	// EventFailed means that a request failed before the hold was granted for another reason (see the Event Outcome and Err)
+	EventFailed = v2.EventFailed
+
+	// EventReleased means that a hold was released (see the Event Outcome), other than by the unlockTimeout
+	EventReleased = v2.EventReleased
+
+	// EventTimeout means that a request timed out before the hold was granted (OutcomeWaitTimeout), or that a hold was released when the unlockTimeout expired (OutcomeUnlockTimeout)
+	EventTimeout = v2.EventTimeout
+
+	// OutcomeCancelled means that the hold was granted and then released when the parent context was cancelled
+	OutcomeCancelled = v2.OutcomeCancelled
+
+	// OutcomeConnectionLost means that the hold was granted and then force released because the shared database session for the id lost its connection to the database (see ErrConnectionLost)
+	OutcomeConnectionLost = v2.OutcomeConnectionLost
+
+	// OutcomeDeadline means that the hold was granted and then released when the parent context deadline expired (see DeadlinePolicy)
+	OutcomeDeadline = v2.OutcomeDeadline
+
+	// OutcomeError means that the request failed with an error (e.g. a database connection error)
+	OutcomeError = v2.OutcomeError
+
+	// OutcomeGranted means that the hold was granted (used for MetricsSink wait times)
+	OutcomeGranted = v2.OutcomeGranted
+
+	// OutcomeMaxHoldDuration means that the hold was granted and then force released when the Store MaxHoldDuration expired (see ErrMaxHoldDuration)
+	OutcomeMaxHoldDuration = v2.OutcomeMaxHoldDuration
+
+	// OutcomeReadOnly means that the RW request failed because the Store is read-only (see ErrReadOnlyStore)
+	OutcomeReadOnly = v2.OutcomeReadOnly
+
+	// OutcomeRejected means that the request was rejected by the OnAcquireRequested hook (see ErrRejected)
+	OutcomeRejected = v2.OutcomeRejected
+
+	// OutcomeReleased means that the hold was granted and then released by the caller
+	OutcomeReleased = v2.OutcomeReleased
+
+	// OutcomeShed means that the request was shed because a wait time SLO or rate limit was being exceeded, or because it was not expected to be granted before its deadline
+	OutcomeShed = v2.OutcomeShed
+
+	// OutcomeStoreClosed means that the request was released or failed because the Store context was cancelled
+	OutcomeStoreClosed = v2.OutcomeStoreClosed
+
+	// OutcomeUnauthorized means that the request was rejected by the Store Authorizer
+	OutcomeUnauthorized = v2.OutcomeUnauthorized
+
+	// OutcomeUnlockTimeout means that the hold was granted and then released when the unlockTimeout expired (see ErrUnlockTimeout)
+	OutcomeUnlockTimeout = v2.OutcomeUnlockTimeout
+
+	// OutcomeWaitCancelled means that the request was cancelled before the hold was granted
+	OutcomeWaitCancelled = v2.OutcomeWaitCancelled
+
+	// OutcomeWaitTimeout means that the request timed out before the hold was granted
+	OutcomeWaitTimeout = v2.OutcomeWaitTimeout
+
+	// PingBeforeGrant also pings the shared database session before granting each request, and fails the request if the ping fails.
+	PingBeforeGrant = v2.PingBeforeGrant
+
+	// PingOnConnect only pings the database when the shared database session is connected (default)
+	PingOnConnect = v2.PingOnConnect
+
+	// SchedulerChannels grants requests using the group goroutine for each id, which receives requests from channels (default).
+	SchedulerChannels = v2.SchedulerChannels
+
+	// SchedulerCond grants requests using a mutex and condition variable for each id.
+	SchedulerCond = v2.SchedulerCond
+
+	// SchedulerPriority grants requests using a weighted semaphore for each id in the same way as SchedulerSemaphore,
+	// except that waiting requests are granted in order of priority (see WithPriority), and then in the order that they were made.
+	SchedulerPriority = v2.SchedulerPriority
+
+	// SchedulerSemaphore grants requests using a weighted semaphore for each id, where read requests have weight 1 and RW requests have the full weight.
+	SchedulerSemaphore = v2.SchedulerSemaphore
+
+	// SessionResetDiscard runs the driver ResetSessionSQL (e.g. DISCARD ALL for postgres) on each idle connection of the shared database session before each RW hold is granted.
+	SessionResetDiscard = v2.SessionResetDiscard
+
+	// SessionResetNone does not reset the shared database session (default)
+	SessionResetNone = v2.SessionResetNone
+
+	// SessionResetRecycle closes the idle connections of the shared database session before each RW hold is granted, so that the RW hold uses new connections.
+	SessionResetRecycle = v2.SessionResetRecycle
+
+	// TeardownImmediate closes the shared database session and deletes the group for an id as soon as there are no requests for the id (default)
+	TeardownImmediate = v2.TeardownImmediate
+
+	// TeardownLinger keeps the shared database session for an id open for the Store TeardownLinger duration after there are no requests for the id
+	TeardownLinger = v2.TeardownLinger
+
+	// TeardownNever keeps the shared database session for an id open until Evict is called for the id or the Store context is cancelled
+	TeardownNever = v2.TeardownNever
+)
+
+var (
+	// DefaultIDCodec encodes ids as the name of the id type and the id value (e.g. "string:abc", "int64:12", or "ID:org/12/db/3"), so that decoded ids have the same type as the encoded ids.
+	DefaultIDCodec = v2.DefaultIDCodec
+
+	// ErrConnectionLost is the cause of the Hold context (and of the error returned by Hold.Err) when a Hold is force released because the shared database session for the id lost its connection to the database (see ObserveError).
+	ErrConnectionLost = v2.ErrConnectionLost
+
+	// ErrFatalConnect is wrapped by connection errors which are not retried (e.g. bad credentials or an unknown database).
+	ErrFatalConnect = v2.ErrFatalConnect
+
+	// ErrInvariantViolation is returned (wrapped with details) when the Store is in Strict mode and a scheduler invariant is violated
+	ErrInvariantViolation = v2.ErrInvariantViolation
+
+	// ErrLeaseExpired is the cause of the Migration context when the migration lease expires because Heartbeat was not called within the lease duration.
+	ErrLeaseExpired = v2.ErrLeaseExpired
+
+	// ErrLockOnly is returned by statements and transactions on the database handles of lock-only Stores (see the "lockonly" database type)
+	ErrLockOnly = v2.ErrLockOnly
+
+	// ErrMaxHoldDuration is the cause of the Hold context (and of the error returned by Hold.Err) when a Hold is force released after the Store MaxHoldDuration.
+	ErrMaxHoldDuration = v2.ErrMaxHoldDuration
+
+	// ErrMisuse is returned (wrapped with details) when the Store is used incorrectly (e.g. a request with a nil context).
+	ErrMisuse = v2.ErrMisuse
+
+	// ErrNotHeld is returned (wrapped with details) by MustRWHeld and MustReadHeld when they are called outside an appropriate hold
+	ErrNotHeld = v2.ErrNotHeld
+
+	// ErrQueryBudget is the error of a Hold which was released because it exceeded the Store QueryBudget (see QueryBudgetRelease)
+	ErrQueryBudget = v2.ErrQueryBudget
+
+	// ErrRateLimited is returned for RW requests that exceed the Store WriteRateLimit when FailFast is set
+	ErrRateLimited = v2.ErrRateLimited
+
+	// ErrReadOnlyStore is returned for RW requests (including RWHoldWithTimeout, Migrate, and write requests made using OpenFile) made while the Store is read-only (see SetReadOnly)
+	ErrReadOnlyStore = v2.ErrReadOnlyStore
+
+	// ErrRejected is wrapped by the errors returned for requests rejected by the OnAcquireRequested hook
+	ErrRejected = v2.ErrRejected
+
+	// ErrShed is returned for requests that are shed because a wait time SLO is being exceeded (see WaitSLOs)
+	ErrShed = v2.ErrShed
+
+	// ErrShedDeadline is returned for requests that are shed because the request is not expected to be granted before its deadline (see ShedByDeadline)
+	ErrShedDeadline = v2.ErrShedDeadline
+
+	// ErrStoreClosed is returned for requests made (or still waiting) after the Store context is cancelled
+	ErrStoreClosed = v2.ErrStoreClosed
+
+	// ErrTagRequired is returned (wrapped with details) for requests with an empty tag when the Store RequireTags setting is true
+	ErrTagRequired = v2.ErrTagRequired
+
+	// ErrTransactionPooling is returned for features which require session state when the Store TransactionPooling setting is true
+	ErrTransactionPooling = v2.ErrTransactionPooling
+
+	// ErrUnauthorized is returned (wrapping the Authorizer error) for requests rejected by the Store Authorizer
+	ErrUnauthorized = v2.ErrUnauthorized
+
+	// ErrUnlockTimeout is the cause of the Hold context (and of the error returned by Hold.Err and by waiting requests) when the unlockTimeout expires.
+	ErrUnlockTimeout = v2.ErrUnlockTimeout
+
+	// ErrUnreachable is wrapped by the errors returned for requests which fail because the database host for an id without a shared database session could not be reached (see the Store ProbeTimeout)
+	ErrUnreachable = v2.ErrUnreachable
+)
+
+// ConfigFromEnv loads a Config from environment variables named using the prefix and the Config env tags (e.g. DBLOCKER_DRIVER_NAME for the prefix "DBLOCKER_").
+func ConfigFromEnv(prefix string) (Config, error) {
+	return v2.ConfigFromEnv(prefix)
+}
+
+// ConnectDBFuncConnector returns a Connector which connects using a connectDBFunc (such as DefaultConnectDBFunc)
+func ConnectDBFuncConnector(connectDBFunc func(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (db *sqlx.DB, err error)) Connector {
+	return v2.ConnectDBFuncConnector(connectDBFunc)
+}
+
+// ConsistencyTokenFromContext returns the ConsistencyToken added to ctx using WithConsistencyToken, and false if ctx does not have a ConsistencyToken
+func ConsistencyTokenFromContext(ctx context.Context) (ConsistencyToken, bool) {
+	return v2.ConsistencyTokenFromContext(ctx)
+}
+
+// DefaultConnectDBFunc is the default function used to connecct to the database
+func DefaultConnectDBFunc(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (*sqlx.DB, error) {
+	return v2.DefaultConnectDBFunc(ctx, id, driverName, dataSourceName, statementTimeout)
+}
+
+// DriverCapabilities returns the Capabilities of a database driver, and false if the driver is unknown.
+func DriverCapabilities(driverName string) (Capabilities, bool) {
+	return v2.DriverCapabilities(driverName)
 }
 
-// Request is a database access request
-type Request struct {
-	ctx context.Context
+// FatalConnectError marks a connection error returned by a Connector or connectDBFunc as fatal (see ErrFatalConnect)
+func FatalConnectError(err error) error {
+	return v2.FatalConnectError(err)
+}
+
+// Filter returns an iterator over the values of seq for which keep returns true (e.g. to filter the Store Waiters by tag or by wait time)
+func Filter[T any](seq iter.Seq[T], keep func(T) bool) iter.Seq[T] {
+	return v2.Filter[T](seq, keep)
+}
+
+// GrafanaDashboard returns a Grafana dashboard definition (JSON) for the Prometheus metrics described in MetricsSink
+func GrafanaDashboard() []byte {
+	return v2.GrafanaDashboard()
+}
+
+// HotIDFile returns a HotIDStore which saves the ids to the named file, with one encoded id on each line.
+func HotIDFile(name string) HotIDStore {
+	return v2.HotIDFile(name)
+}
+
+// IDOf returns the composite ID for the parts.
+func IDOf(parts ...interface{}) ID {
+	return v2.IDOf(parts...)
+}
+
+// IDVariable returns a SessionVariables function (see the Store SessionVariables setting) which sets the session variable name (e.g. "app.tenant_id") to the id formatted using fmt "%v",
+// so that row-level security policies (e.g. USING (tenant_id = current_setting('app.tenant_id')::bigint)) scope queries to the id locked by the Hold
+func IDVariable(name string) func(ctx context.Context, id interface{}, metadata Metadata) (map[string]string, error) {
+	return v2.IDVariable(name)
+}
+
+// IsRetryable returns true for errors from requests which may succeed if retried:
+func IsRetryable(err error) bool {
+	return v2.IsRetryable(err)
+}
+
+// LoadConfigFile loads a JSON Config file
+func LoadConfigFile(path string) (Config, error) {
+	return v2.LoadConfigFile(path)
+}
+
+// LookupDriver returns the DriverSpec for a database type, and false if the database type has not been registered
+func LookupDriver(driverName string) (DriverSpec, bool) {
+	return v2.LookupDriver(driverName)
+}
+
+// MetadataFromContext returns the Metadata carried by ctx
+func MetadataFromContext(ctx context.Context) (Metadata, bool) {
+	return v2.MetadataFromContext(ctx)
+}
+
+// MustRWHeld returns nil if ctx carries a RW hold of any Store for the specified id which has not been released, and otherwise returns an error wrapping ErrNotHeld.
+// MustRWHeld only panics if ctx carries a hold of a Store in Strict mode, so use (*Store).MustRWHeld to also panic when ctx does not carry any hold.
+func MustRWHeld(ctx context.Context, id interface{}) error {
+	return v2.MustRWHeld(ctx, id)
+}
+
+// MustReadHeld returns nil if ctx carries a hold of any Store and mode for the specified id which has not been released,
+// and otherwise returns an error wrapping ErrNotHeld (or panics with the error, see MustRWHeld)
+func MustReadHeld(ctx context.Context, id interface{}) error {
+	return v2.MustReadHeld(ctx, id)
 }
 
 // New creates a new dblocker Store
-// using the default connectDBFunc;
-// with a default unlockTimeout for waiting for access to the database of 2 minutes, and
-// with a default statemenTimeout for database sessions of 4 minutes (where the database supports statement timeouts)
-func New(
-	ctx context.Context,
+func New(ctx context.Context,
 	driverName string,
 	dataSourceName string,
-	debug bool,
-) (s *Store, err error) {
+	debug bool) (*Store, error) {
+	return v2.New(ctx, driverName, dataSourceName, debug)
+}
 
-	// Use default connectDBFunc
-	connectDBFunc := DefaultConnectDBFunc
+// NewBudget returns a Budget which limits the total number of open database connections of the Stores using the Budget to limit.
+func NewBudget(limit int) *Budget {
+	return v2.NewBudget(limit)
+}
 
-	// Default timeout for waiting for access to the database
-	unlockTimeout := 2 * time.Minute
-	defaultStatementTimeout := 4 * time.Minute
+// NewFromConfig creates a new dblocker Store using the default connectDBFunc and the settings in the Config
+func NewFromConfig(ctx context.Context, cfg Config) (*Store, error) {
+	return v2.NewFromConfig(ctx, cfg)
+}
 
-	// Default statement timeout for database sessions
-	var statementTimeout *time.Duration
-	switch driverName {
-	case "postgres":
-		statementTimeout = &defaultStatementTimeout
-	case "mysql":
-		statementTimeout = &defaultStatementTimeout
-	default:
-	}
+// NewMemoryCache creates a new in-memory Cache
+func NewMemoryCache() *MemoryCache {
+	return v2.NewMemoryCache()
+}
 
-	return NewWithConnectDBFuncAndTimeouts(ctx, connectDBFunc, driverName, dataSourceName, &unlockTimeout, statementTimeout, debug)
+// NewTiered composes the Stores in the order that holds are acquired
+func NewTiered(stores ...*Store) *Tiered {
+	return v2.NewTiered(stores...)
 }
 
-// NewWithUnlockAndStatementTimeouts creates a new dblocker Store
-// using the default connectDBFunc;
-// with an unlockTimeout for waiting for access to the database; and
-// with a statemenTimeout for database sessions (returns an error if not nil and the database does not support statement timeouts).
-func NewWithUnlockAndStatementTimeouts(
-	ctx context.Context,
+// NewWithConnectDBFuncAndTimeouts creates a new dblocker Store
+func NewWithConnectDBFuncAndTimeouts(ctx context.Context,
+	connectDBFunc func(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (db *sqlx.DB, err error),
 	driverName string,
 	dataSourceName string,
 	unlockTimeout *time.Duration,
 	statementTimeout *time.Duration,
-	debug bool,
-) (s *Store, err error) {
+	debug bool) (*Store, error) {
+	return v2.NewWithConnectDBFuncAndTimeouts(ctx, connectDBFunc, driverName, dataSourceName, unlockTimeout, statementTimeout, debug)
+}
 
-	// Use default connectDBFunc
-	connectDBFunc := DefaultConnectDBFunc
+// NewWithConnector creates a new dblocker Store
+func NewWithConnector(ctx context.Context,
+	connector Connector,
+	driverName string,
+	dataSourceName string,
+	unlockTimeout *time.Duration,
+	statementTimeout *time.Duration,
+	debug bool) (*Store, error) {
+	return v2.NewWithConnector(ctx, connector, driverName, dataSourceName, unlockTimeout, statementTimeout, debug)
+}
 
-	return NewWithConnectDBFuncAndTimeouts(ctx, connectDBFunc, driverName, dataSourceName, unlockTimeout, statementTimeout, debug)
+// NewWithProfile creates a new dblocker Store
+func NewWithProfile(ctx context.Context,
+	driverName string,
+	dataSourceName string,
+	profile Profile,
+	debug bool) (*Store, error) {
+	return v2.NewWithProfile(ctx, driverName, dataSourceName, profile, debug)
 }
 
-// NewWithConnectDBFuncAndTimeouts creates a new dblocker Store
-// with a custom connectDBFunc (which can be used for database types not in the DefaultConnectDBFunc (i.e. sqlite, postgres, and mysql) and/or to shard requests by id for example);
-// with an unlockTimeout for waiting for access to the database; and
-// with a statemenTimeout for database sessions (returns an error if not nil and the database does not support statement timeouts).
-func NewWithConnectDBFuncAndTimeouts(
-	ctx context.Context,
-	connectDBFunc func(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (db *sqlx.DB, err error),
+// NewWithUnlockAndStatementTimeouts creates a new dblocker Store
+func NewWithUnlockAndStatementTimeouts(ctx context.Context,
 	driverName string,
 	dataSourceName string,
 	unlockTimeout *time.Duration,
 	statementTimeout *time.Duration,
-	debug bool,
-) (s *Store, err error) {
-
-	// Return an error if statementTimeout is not nil and the database does not support statement timeouts
-	if statementTimeout != nil {
-		switch driverName {
-		case "mock":
-			return nil, fmt.Errorf("connectDB error: statementTimeout for database type not implemented: %s", driverName)
-		case "sqlite3":
-			return nil, fmt.Errorf("connectDB error: statementTimeout for database type not implemented: %s", driverName)
-		case "postgres":
-		case "mysql":
-		default:
-			return nil, fmt.Errorf("connectDB error: database type not implemented: %s", driverName)
-		}
-	}
-
-	return &Store{
-		Ctx:              ctx,
-		m:                make(map[interface{}]*Group),
-		connectDBFunc:    connectDBFunc,
-		DriverName:       driverName,
-		DataSourceName:   dataSourceName,
-		UnlockTimeout:    unlockTimeout,
-		StatementTimeout: statementTimeout,
-		debug:            debug,
-	}, nil
-}
-
-// RWGetDB returns a shared copy of a database session (*sql.DB) for the specified id.
-// RWGetDB acts like Lock() for a RWMutex for the specified id.
-// All other RWGetDB, RWGetDBWithTimeout, and ReadDB function calls will wait for access to the database for the specified id until the returned cancel() function is called.
-func (s *Store) RWGetDB(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sql.DB, err error) {
-	cancel, sqlxDB, err := s.waitGetDB(id, "rw", ctx, tag, nil)
-	return cancel, sqlxDB.DB, err
-}
-
-// RWGetDB returns a shared copy of a database session (*sqlx.DB) for the specified id.
-// github.com/jmoiron/sqlx is a library which provides a set of extensions on go's standard database/sql library.
-// RWGetDB acts like Lock() for a RWMutex for the specified id.
-// All other RWGetDB, RWGetDBWithTimeout, and ReadDB function calls will wait for access to the database for the specified id until the returned cancel() function is called.
-func (s *Store) RWGetDBx(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sqlx.DB, err error) {
-	return s.waitGetDB(id, "rw", ctx, tag, nil)
-}
-
-// RWGetDBWithTimeout returns a new database session (*sql.DB) for the specified id with a custom session timeout.
-// RWGetDBWithTimeout acts like Lock() for a RWMutex for the specified id.
-// All other RWGetDB, RWGetDBWithTimeout, and ReadDB function calls will wait for access to the database for the specified id until the returned cancel() function is called.
-func (s *Store) RWGetDBWithTimeout(id interface{}, ctx context.Context, tag string, statementTimeout *time.Duration) (cancel context.CancelFunc, db *sql.DB, err error) {
-	cancel, sqlxDB, err := s.waitGetDB(id, "rwseparate", ctx, tag, statementTimeout)
-	return cancel, sqlxDB.DB, err
-}
-
-// RWGetDBWithTimeout returns a new database session (*sqlx.DB) for the specified id with a custom session timeout.
-// github.com/jmoiron/sqlx is a library which provides a set of extensions on go's standard database/sql library.
-// RWGetDBWithTimeout acts like Lock() for a RWMutex for the specified id.
-// All other RWGetDB, RWGetDBWithTimeout, and ReadDB function calls will wait for access to the database for the specified id until the returned cancel() function is called.
-func (s *Store) RWGetDBxWithTimeout(id interface{}, ctx context.Context, tag string, statementTimeout *time.Duration) (cancel context.CancelFunc, db *sqlx.DB, err error) {
-	return s.waitGetDB(id, "rwseparate", ctx, tag, statementTimeout)
-}
-
-// ReadDB returns a shared copy of a database session (*sql.DB) for the specified id.
-// ReadDB acts like RLock() for a RWMutex for the specified id.
-// Multiple ReadDB function calls can access the shared database at the same time.
-// All RWGetDB and RWGetDBWithTimeout function calls will wait for access to the database for the specified id until the returned cancel() function is called.
-func (s *Store) ReadGetDB(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sql.DB, err error) {
-	cancel, sqlxDB, err := s.waitGetDB(id, "read", ctx, tag, nil)
-	return cancel, sqlxDB.DB, err
-}
-
-// ReadDB returns a shared copy of a database session (*sqlx.DB) for the specified id.
-// github.com/jmoiron/sqlx is a library which provides a set of extensions on go's standard database/sql library.
-// ReadDB acts like RLock() for a RWMutex for the specified id.
-// Multiple ReadDB function calls can access the shared database at the same time.
-// All RWGetDB and RWGetDBWithTimeout function calls will wait for access to the database for the specified id until the returned cancel() function is called.
-func (s *Store) ReadGetDBx(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sqlx.DB, err error) {
-	return s.waitGetDB(id, "read", ctx, tag, nil)
-}
-
-func (s *Store) waitGetDB(id interface{}, accessType string, parentCtx context.Context, tag string, statementTimeout *time.Duration) (cancel context.CancelFunc, db *sqlx.DB, err error) {
-
-	// Create context
-	var ctx context.Context
-	if s.UnlockTimeout == nil {
-		ctx, cancel = context.WithCancel(parentCtx)
-	} else {
-		ctx, cancel = context.WithTimeout(parentCtx, *s.UnlockTimeout)
-	}
-
-	// Check accessType
-	switch accessType {
-	case "rw":
-	case "rwseparate":
-	case "read":
-	default:
-		if cancel != nil {
-			cancel()
-		}
-		return nil, nil, fmt.Errorf("unknown access type error: %s", accessType)
-	}
-
-	// Cancel context when done
-	go func() {
-		if s.debug {
-			fmt.Println(fmt.Sprintf("dblocker: %s", accessType), tag)
-			tickerCancel := s.ticker(ctx, tag)
-			defer tickerCancel()
-		}
-
-		select {
-		case <-s.Ctx.Done():
-			if cancel != nil {
-				cancel()
-			}
-		case <-ctx.Done():
-			if cancel != nil {
-				cancel()
-			}
-		}
-	}()
-
-	// Add new Group to the Store map if required
-	s.Lock()
-	g, ok := s.m[id]
-	if !ok {
-		s.m[id] = &Group{
-			requestCount: 0,
-			//DB:		nil,
-			rwRequestCh:   make(chan Request),
-			readRequestCh: make(chan Request),
-			dbCh:          make(chan *sqlx.DB),
-		}
-		g = s.m[id]
-		go s.startGroup(id, g)
-	}
-
-	// Increment request count
-	s.m[id].requestCount++
-	s.Unlock()
-
-	// Decrement request count when this function returns
-	defer func() {
-		s.Lock()
-		s.m[id].requestCount--
-		s.Unlock()
-	}()
-
-	// Send request and wait
-	switch accessType {
-	case "rw", "rwseparate":
-		select {
-		case g.rwRequestCh <- Request{ctx: ctx}:
-		case <-s.Ctx.Done():
-			if cancel != nil {
-				cancel()
-			}
-			return nil, nil, s.Ctx.Err()
-		case <-ctx.Done():
-			if cancel != nil {
-				cancel()
-			}
-			return nil, nil, ctx.Err()
-		}
-	case "read":
-		select {
-		case g.readRequestCh <- Request{ctx: ctx}:
-		case <-s.Ctx.Done():
-			if cancel != nil {
-				cancel()
-			}
-			return nil, nil, s.Ctx.Err()
-		case <-ctx.Done():
-			if cancel != nil {
-				cancel()
-			}
-			return nil, nil, ctx.Err()
-		}
-	default:
-		if cancel != nil {
-			cancel()
-		}
-		return nil, nil, fmt.Errorf("unknown access type error: %s", accessType)
-	}
-
-	// Get database
-	switch accessType {
-	case "rwseparate":
-
-		// Get new database connection (immediately)
-		db, err = s.connectDBFunc(ctx, id, s.DriverName, s.DataSourceName, statementTimeout)
-		if err != nil {
-			if cancel != nil {
-				cancel()
-			}
-			return nil, nil, err
-		}
-	case "rw", "read":
-
-		// Get shared database connection (wait)
-		select {
-		case db = <-g.dbCh:
-		case <-s.Ctx.Done():
-			if cancel != nil {
-				cancel()
-			}
-			return nil, nil, s.Ctx.Err()
-		case <-ctx.Done():
-			if cancel != nil {
-				cancel()
-			}
-			return nil, nil, ctx.Err()
-		}
-	default:
-		return nil, nil, fmt.Errorf("unknown access type error: %s", accessType)
-	}
-
-	// Return cancelFunc and database
-	return cancel, db, nil
+	debug bool) (*Store, error) {
+	return v2.NewWithUnlockAndStatementTimeouts(ctx, driverName, dataSourceName, unlockTimeout, statementTimeout, debug)
+}
+
+// ProfileBatch returns a Profile for long running batch and analytics requests using the specified driver.
+func ProfileBatch(driverName string) Profile {
+	return v2.ProfileBatch(driverName)
+}
+
+// ProfileOLTP returns a Profile for short interactive requests using the specified driver.
+func ProfileOLTP(driverName string) Profile {
+	return v2.ProfileOLTP(driverName)
+}
+
+// RegisterDriver adds (or replaces) a database type used by the DefaultConnectDBFunc, constructor validation, and DriverCapabilities.
+func RegisterDriver(driverName string, spec DriverSpec) {
+	v2.RegisterDriver(driverName, spec)
+}
+
+// RegisterDriverCapabilities sets the Capabilities of a database driver, for example for a driver connected using a custom connectDBFunc.
+func RegisterDriverCapabilities(driverName string, caps Capabilities) error {
+	return v2.RegisterDriverCapabilities(driverName, caps)
+}
+
+// RegisterIDType adds (or replaces) an id type encoded by the DefaultIDCodec, using the type of sample (e.g. RegisterIDType("tenant", TenantID{}, encode, decode)).
+func RegisterIDType(name string, sample interface{}, encode func(id interface{}) (string, error), decode func(s string) (id interface{}, err error)) {
+	v2.RegisterIDType(name, sample, encode, decode)
+}
+
+// ShardLabel returns a LabelMapper (see the Store LabelMapper setting) which buckets ids into n shards using a hash of the id formatted using fmt "%v",
+// returning labels from "shard-0" to "shard-<n-1>"
+func ShardLabel(n int) func(id interface{}) string {
+	return v2.ShardLabel(n)
+}
+
+// WithConsistencyToken returns a copy of ctx with a ConsistencyToken, which read holds requested using ctx present to read the writes made before the token was returned
+func WithConsistencyToken(ctx context.Context, token ConsistencyToken) context.Context {
+	return v2.WithConsistencyToken(ctx, token)
+}
+
+// WithMapProgress returns a context which reports the progress of MapIDs calls made using the context to onProgress after each id is processed.
+func WithMapProgress(ctx context.Context, onProgress func(p MapProgress)) context.Context {
+	return v2.WithMapProgress(ctx, onProgress)
+}
+
+// WithMetadata returns a copy of ctx carrying the Metadata for database access requests made using the returned context
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	return v2.WithMetadata(ctx, md)
+}
+
+// WithPriority returns a context which sets the priority of requests made using the context (default 0, and higher priorities are granted first).
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return v2.WithPriority(ctx, priority)
+}
+
+// WithProgress returns a context which reports the Progress of requests made using the context to onProgress while they wait for access to the database.
+func WithProgress(ctx context.Context, onProgress func(p Progress)) context.Context {
+	return v2.WithProgress(ctx, onProgress)
+}
+
+// WithWaitBudget returns a context which splits the time remaining until the ctx deadline (e.g. the budget for an HTTP request)
+func WithWaitBudget(ctx context.Context, waitRatio float64) context.Context {
+	return v2.WithWaitBudget(ctx, waitRatio)
 }
@@ -0,0 +1,47 @@
+// Package mock adds the dblocker "mock" database type, which connects to a github.com/DATA-DOG/go-sqlmock database for each shared database session.
+// The mock database type is useful for testing code which uses a dblocker Store without a database.
+// The core dblocker package does not import sqlmock, so that production builds do not include the testing library.
+// Use Register to add mock database types whose sessions have sqlmock expectations.
+//
+// Import the package for its side effects:
+//
+//	import _ "github.com/calmdocs/dblocker/drivers/mock"
+package mock
+
+import (
+	"context"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/calmdocs/dblocker"
+	"github.com/jmoiron/sqlx"
+)
+
+func init() {
+	Register("mock", nil)
+}
+
+// Connect connects to a new sqlmock database (the driverName and dataSourceName are not used)
+func Connect(ctx context.Context, driverName, dataSourceName string) (db *sqlx.DB, err error) {
+	mockDB, _, err := sqlmock.New()
+	if err != nil {
+		return nil, err
+	}
+	return sqlx.NewDb(mockDB, "sqlmock"), nil
+}
+
+// Register adds (or replaces) a mock database type which connects to a new sqlmock database for each shared database session,
+// and calls setup (if not nil) with the dataSourceName and the sqlmock of each new database to set the expectations for the session
+func Register(driverName string, setup func(dataSourceName string, mock sqlmock.Sqlmock)) {
+	dblocker.RegisterDriver(driverName, dblocker.DriverSpec{
+		Connect: func(ctx context.Context, driverName, dataSourceName string) (db *sqlx.DB, err error) {
+			mockDB, mock, err := sqlmock.New()
+			if err != nil {
+				return nil, err
+			}
+			if setup != nil {
+				setup(dataSourceName, mock)
+			}
+			return sqlx.NewDb(mockDB, "sqlmock"), nil
+		},
+	})
+}
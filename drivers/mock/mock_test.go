@@ -0,0 +1,32 @@
+package mock
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/calmdocs/dblocker"
+)
+
+func TestRegister(t *testing.T) {
+	Register("mock-expectations", func(dataSourceName string, mock sqlmock.Sqlmock) {
+		mock.ExpectExec("UPDATE files").WithArgs(dataSourceName).WillReturnResult(sqlmock.NewResult(0, 1))
+	})
+	s, err := dblocker.New(context.Background(), "mock-expectations", "tenant", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := s.RWHold("id", context.Background(), "update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Release()
+	_, err = h.DB().Exec("UPDATE files SET name = ?", "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = h.DB().Exec("UPDATE files SET name = ?", "other")
+	if err == nil {
+		t.Fatal("expected unexpected query error")
+	}
+}
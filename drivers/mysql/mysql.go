@@ -0,0 +1,40 @@
+// Package mysql registers the github.com/go-sql-driver/mysql database/sql driver used by the dblocker "mysql" database type,
+// and adds the mysql specific DriverSpec functions (see dblocker.RegisterDriver).
+//
+// Import the package for its side effects:
+//
+//	import _ "github.com/calmdocs/dblocker/drivers/mysql"
+package mysql
+
+import (
+	"errors"
+
+	"github.com/calmdocs/dblocker"
+	"github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	spec, _ := dblocker.LookupDriver("mysql")
+	spec.IsFatalError = IsFatalError
+	spec.IsConnectionLost = IsConnectionLost
+	dblocker.RegisterDriver("mysql", spec)
+}
+
+// IsFatalError returns true for access denied and unknown database errors, which are not retried (see dblocker.ErrFatalConnect)
+func IsFatalError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	switch mysqlErr.Number {
+	case 1044, 1045, 1049:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsConnectionLost returns true for invalid connection errors, which mean that the connection to the database was lost (see dblocker.Store.ObserveError)
+func IsConnectionLost(err error) bool {
+	return errors.Is(err, mysql.ErrInvalidConn)
+}
@@ -0,0 +1,54 @@
+// Package postgres registers the github.com/lib/pq database/sql driver used by the dblocker "postgres" database type,
+// and adds the postgres LISTEN support used by dblocker.Store.Notifications (see dblocker.DriverSpec Listen).
+//
+// Import the package for its side effects:
+//
+//	import _ "github.com/calmdocs/dblocker/drivers/postgres"
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/calmdocs/dblocker"
+	"github.com/lib/pq"
+)
+
+func init() {
+	spec, _ := dblocker.LookupDriver("postgres")
+	spec.Listen = Listen
+	dblocker.RegisterDriver("postgres", spec)
+}
+
+// Listen LISTENs for notifications on channel using a pq.Listener, and calls notify for each notification received until ctx is done.
+// Connection errors are passed to onError, and the pq.Listener reconnects automatically.
+func Listen(ctx context.Context, dataSourceName, channel string, notify func(n dblocker.Notification), onError func(err error)) error {
+	l := pq.NewListener(dataSourceName, 2*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			onError(err)
+		}
+	})
+	defer l.Close()
+
+	err := l.Listen(channel)
+	if err != nil {
+		onError(err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-l.Notify:
+
+			// A nil notification is sent after the connection is re-established
+			if n == nil {
+				continue
+			}
+			notify(dblocker.Notification{
+				Channel: n.Channel,
+				Payload: n.Extra,
+				BePid:   n.BePid,
+			})
+		}
+	}
+}
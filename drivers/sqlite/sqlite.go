@@ -0,0 +1,10 @@
+// Package sqlite registers the github.com/mattn/go-sqlite3 database/sql driver used by the dblocker "sqlite3" database type.
+//
+// Import the package for its side effects:
+//
+//	import _ "github.com/calmdocs/dblocker/drivers/sqlite"
+package sqlite
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
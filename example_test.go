@@ -0,0 +1,161 @@
+package dblocker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/calmdocs/dblocker"
+	_ "github.com/calmdocs/dblocker/drivers/mock"
+	"github.com/jmoiron/sqlx"
+)
+
+func ExampleStore_RWGetDB() {
+	ctx := context.Background()
+	s, err := dblocker.New(ctx, "mock", "", false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Lock the database for id 1, and always call cancel() to unlock it
+	cancel, db, err := s.RWGetDB(1, ctx, "update user")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer cancel()
+
+	err = db.Ping()
+	fmt.Println("locked:", err == nil)
+	// Output: locked: true
+}
+
+func ExampleStore_ReadGetDB() {
+	ctx := context.Background()
+	s, err := dblocker.New(ctx, "mock", "", false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Read locks for the same id are held at the same time
+	cancel1, db1, err := s.ReadGetDB(1, ctx, "read user")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer cancel1()
+	cancel2, db2, err := s.ReadGetDB(1, ctx, "read user again")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer cancel2()
+
+	fmt.Println("shared database session:", db1 == db2)
+	// Output: shared database session: true
+}
+
+func Example_withTransactions() {
+	ctx := context.Background()
+
+	// Use sqlmock expectations in place of a real database
+	connectDBFunc := func(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (*sqlx.DB, error) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			return nil, err
+		}
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE accounts").WithArgs(100, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		return sqlx.NewDb(mockDB, "sqlmock"), nil
+	}
+	s, err := dblocker.NewWithConnectDBFuncAndTimeouts(ctx, connectDBFunc, "mock", "", nil, nil, false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Hold the rw lock for the whole transaction, and unlock after the transaction is committed or rolled back
+	cancel, db, err := s.RWGetDBx(1, ctx, "transfer")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer cancel()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE accounts SET balance = balance + ? WHERE id = ?", 100, 1)
+	if err != nil {
+		tx.Rollback()
+		fmt.Println(err)
+		return
+	}
+	err = tx.Commit()
+	fmt.Println("committed:", err == nil)
+	// Output: committed: true
+}
+
+func Example_sharding() {
+	ctx := context.Background()
+
+	// Connect each id to one of two database shards
+	connectDBFunc := func(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (*sqlx.DB, error) {
+		shard := fmt.Sprintf("%s-%d", dataSourceName, id.(int)%2)
+		fmt.Println("connect", id, "to", shard)
+		return dblocker.DefaultConnectDBFunc(ctx, id, driverName, shard, statementTimeout)
+	}
+	s, err := dblocker.NewWithConnectDBFuncAndTimeouts(ctx, connectDBFunc, "mock", "shard", nil, nil, false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for id := 1; id <= 2; id++ {
+		cancel, _, err := s.RWGetDB(id, ctx, "sharded")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		cancel()
+	}
+	// Output:
+	// connect 1 to shard-1
+	// connect 2 to shard-0
+}
+
+func Example_timeouts() {
+	ctx := context.Background()
+
+	// Holds are released after the unlockTimeout, even if cancel() is not called
+	unlockTimeout := 50 * time.Millisecond
+	s, err := dblocker.NewWithUnlockAndStatementTimeouts(ctx, "mock", "", &unlockTimeout, nil, false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	h, err := s.RWHold(1, ctx, "slow job")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Requests stop waiting when their context is done
+	waitCtx, waitCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer waitCancel()
+	_, _, err = s.RWGetDB(1, waitCtx, "impatient job")
+	fmt.Println("wait timed out:", errors.Is(err, context.DeadlineExceeded))
+
+	<-h.Done()
+	fmt.Println("hold released:", errors.Is(h.Err(), context.DeadlineExceeded))
+	// Output:
+	// wait timed out: true
+	// hold released: true
+}
@@ -1,9 +1,10 @@
 module github.com/calmdocs/dblocker
 
-go 1.22.5
+go 1.23
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/calmdocs/dblocker/v2 v2.0.0
 	github.com/go-sql-driver/mysql v1.8.1
 	github.com/jmoiron/sqlx v1.4.0
 	github.com/lib/pq v1.10.9
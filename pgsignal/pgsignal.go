@@ -0,0 +1,267 @@
+// Package pgsignal signals RW hold releases between processes using postgres LISTEN/NOTIFY.
+//
+// When a RW hold for an id is released by a dblocker Store, the Signaler sends a NOTIFY message for the id,
+// and the Signalers of other processes (which LISTEN on the same channel) invalidate the cached read results for the id (see dblocker.Cache),
+// call their OnWrite function, and wake local goroutines waiting for a write to the id (see Wait).
+// This gives processes sharing a database cheap awareness of each other's writes, without distributed locking.
+package pgsignal
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmdocs/dblocker"
+	"github.com/lib/pq"
+)
+
+// DefaultChannel is the NOTIFY channel used for all ids unless the Signaler Channel function is set
+const DefaultChannel = "dblocker_writes"
+
+// signalQueueSize is the number of released ids waiting to be sent before further ids are dropped
+const signalQueueSize = 256
+
+// payload is the NOTIFY payload for a released RW hold
+type payload struct {
+	Origin string `json:"origin"`
+	ID     string `json:"id"`
+
+	// Key is the id encoded using the Store IDCodec ("" if the id type is not supported by the IDCodec, see dblocker.Store.EncodeID)
+	Key string `json:"key,omitempty"`
+}
+
+// execer sends NOTIFY messages (e.g. a *sql.DB)
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Signaler sends a NOTIFY message when a RW hold is released by the Store, and handles the NOTIFY messages sent by other processes.
+// Set the Signaler fields before calling Start.
+type Signaler struct {
+	Store          *dblocker.Store
+	DataSourceName string
+
+	// Channel optionally returns the NOTIFY channel for an id (default DefaultChannel for all ids).
+	// Messages are only received on DefaultChannel and on the channels added using Listen.
+	Channel func(id interface{}) string
+
+	// ParseID optionally converts an id sent by another process (formatted using fmt "%v") to the id used by the Store.
+	// By default, ids are decoded using the Store IDCodec (see dblocker.Store.DecodeID), and ids which the IDCodec can not encode are received as the formatted string.
+	ParseID func(id string) interface{}
+
+	// OnWrite is optionally called when another process releases a RW hold for an id
+	OnWrite func(id interface{})
+
+	origin   string
+	signals  chan interface{}
+	listener *pq.Listener
+
+	mu      sync.Mutex
+	waiters map[interface{}][]chan struct{}
+}
+
+// New creates a new Signaler for a Store, using dataSourceName to connect to postgres
+func New(s *dblocker.Store, dataSourceName string) *Signaler {
+	return &Signaler{
+		Store:          s,
+		DataSourceName: dataSourceName,
+	}
+}
+
+// Start connects to postgres, LISTENs on DefaultChannel, and adds a Store OnWriteReleased hook which sends a NOTIFY message for each released RW hold
+// (calling any existing OnWriteReleased hook first).
+// The connections are closed when ctx is done.
+// Start must be called before making database access requests using the Store.
+func (sig *Signaler) Start(ctx context.Context) error {
+	db, err := sql.Open("postgres", sig.DataSourceName)
+	if err != nil {
+		return fmt.Errorf("pgsignal start error: %w", err)
+	}
+	sig.listener = pq.NewListener(sig.DataSourceName, 2*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			fmt.Println("dbLocker pgsignal listen error:", err.Error())
+		}
+	})
+	if sig.Channel == nil {
+		err = sig.listener.Listen(DefaultChannel)
+		if err != nil {
+			db.Close()
+			sig.listener.Close()
+			return fmt.Errorf("pgsignal start error: %w", err)
+		}
+	}
+	err = sig.start(ctx, db, sig.listener.Notify)
+	if err != nil {
+		db.Close()
+		sig.listener.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		db.Close()
+		sig.listener.Close()
+	}()
+	return nil
+}
+
+// start adds the Store OnWriteReleased hook, and sends and receives NOTIFY messages until ctx is done
+func (sig *Signaler) start(ctx context.Context, db execer, notifications <-chan *pq.Notification) error {
+	origin := make([]byte, 8)
+	_, err := rand.Read(origin)
+	if err != nil {
+		return fmt.Errorf("pgsignal start error: %w", err)
+	}
+	sig.origin = hex.EncodeToString(origin)
+	sig.signals = make(chan interface{}, signalQueueSize)
+
+	onWriteReleased := sig.Store.Hooks.OnWriteReleased
+	sig.Store.Hooks.OnWriteReleased = func(id interface{}, tag string, heldFor time.Duration) {
+		if onWriteReleased != nil {
+			onWriteReleased(id, tag, heldFor)
+		}
+
+		// Do not delay the next request for the id while the NOTIFY message is sent
+		select {
+		case sig.signals <- id:
+		default:
+			fmt.Println("dbLocker pgsignal error: signal queue full, dropped id:", id)
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case id := <-sig.signals:
+				err := sig.send(ctx, db, id)
+				if err != nil && ctx.Err() == nil {
+					fmt.Println("dbLocker pgsignal notify error:", err.Error())
+				}
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-notifications:
+
+				// A nil notification is sent after the connection is re-established
+				if n != nil {
+					sig.receive(n)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Listen LISTENs on the channels for the ids (see Channel), so that messages for the ids are received when the Channel function is set
+func (sig *Signaler) Listen(ids ...interface{}) error {
+	for _, id := range ids {
+		err := sig.listener.Listen(sig.channel(id))
+		if err != nil && err != pq.ErrChannelAlreadyOpen {
+			return fmt.Errorf("pgsignal listen error: %w", err)
+		}
+	}
+	return nil
+}
+
+// Wait waits until another process releases a RW hold for the specified id, or until ctx is done
+func (sig *Signaler) Wait(ctx context.Context, id interface{}) error {
+	ch := make(chan struct{})
+	sig.mu.Lock()
+	if sig.waiters == nil {
+		sig.waiters = make(map[interface{}][]chan struct{})
+	}
+	sig.waiters[id] = append(sig.waiters[id], ch)
+	sig.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		sig.mu.Lock()
+		defer sig.mu.Unlock()
+		waiters := sig.waiters[id]
+		for i, other := range waiters {
+			if other == ch {
+				sig.waiters[id] = append(waiters[:i:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(sig.waiters[id]) == 0 {
+			delete(sig.waiters, id)
+		}
+		return ctx.Err()
+	}
+}
+
+// channel returns the NOTIFY channel for an id
+func (sig *Signaler) channel(id interface{}) string {
+	if sig.Channel == nil {
+		return DefaultChannel
+	}
+	return sig.Channel(id)
+}
+
+// send sends the NOTIFY message for a released RW hold
+func (sig *Signaler) send(ctx context.Context, db execer, id interface{}) error {
+	p := payload{Origin: sig.origin, ID: fmt.Sprint(id)}
+	if key, err := sig.Store.EncodeID(id); err == nil {
+		p.Key = key
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "SELECT pg_notify($1, $2);", sig.channel(id), string(b))
+	return err
+}
+
+// receive handles a NOTIFY message, ignoring messages sent by this Signaler
+func (sig *Signaler) receive(n *pq.Notification) {
+	var p payload
+	err := json.Unmarshal([]byte(n.Extra), &p)
+	if err != nil {
+		fmt.Println("dbLocker pgsignal payload error:", err.Error())
+		return
+	}
+	if p.Origin == sig.origin {
+		return
+	}
+	var id interface{} = p.ID
+	switch {
+	case sig.ParseID != nil:
+		id = sig.ParseID(p.ID)
+	case p.Key != "":
+		decoded, err := sig.Store.DecodeID(p.Key)
+		if err != nil {
+			fmt.Println("dbLocker pgsignal payload error:", err.Error())
+			return
+		}
+		id = decoded
+	}
+
+	if sig.Store.Cache != nil {
+		sig.Store.Cache.Invalidate(id)
+	}
+	if sig.OnWrite != nil {
+		sig.OnWrite(id)
+	}
+
+	sig.mu.Lock()
+	waiters := sig.waiters[id]
+	delete(sig.waiters, id)
+	sig.mu.Unlock()
+	for _, ch := range waiters {
+		close(ch)
+	}
+}
@@ -0,0 +1,110 @@
+package pgsignal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/calmdocs/dblocker"
+	"github.com/lib/pq"
+)
+
+type notifyFunc func(channel, payload string)
+
+func (f notifyFunc) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	f(args[0].(string), args[1].(string))
+	return nil, nil
+}
+
+func TestSignaler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := dblocker.New(ctx, "lockonly", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Cache = dblocker.NewMemoryCache()
+	hooked := make(chan interface{}, 1)
+	s.Hooks.OnWriteReleased = func(id interface{}, tag string, heldFor time.Duration) { hooked <- id }
+
+	// RW releases send a NOTIFY message after calling the existing hook
+	sent := make(chan payload, 1)
+	notifications := make(chan *pq.Notification)
+	sig := New(s, "")
+	written := make(chan interface{}, 1)
+	sig.OnWrite = func(id interface{}) { written <- id }
+	err = sig.start(ctx, notifyFunc(func(channel, extra string) {
+		var p payload
+		if channel != DefaultChannel || json.Unmarshal([]byte(extra), &p) != nil {
+			t.Errorf("unexpected notify: %s %s", channel, extra)
+		}
+		sent <- p
+	}), notifications)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := s.RWHold("tenant", ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+	if id := <-hooked; id != "tenant" {
+		t.Fatalf("unexpected id: %v", id)
+	}
+	p := <-sent
+	if p.ID != "tenant" || p.Key != "string:tenant" || p.Origin != sig.origin {
+		t.Fatalf("unexpected payload: %+v", p)
+	}
+
+	// Messages sent by this Signaler are ignored
+	s.Cache.Set("tenant", "key", []byte("cached"), time.Minute)
+	b, _ := json.Marshal(p)
+	notifications <- &pq.Notification{Channel: DefaultChannel, Extra: string(b)}
+	if _, ok := s.Cache.Get("tenant", "key"); !ok {
+		t.Fatal("expected cached value")
+	}
+
+	// Messages sent by other processes invalidate the cache and wake waiters
+	waited := make(chan error)
+	go func() { waited <- sig.Wait(ctx, "tenant") }()
+	for {
+		sig.mu.Lock()
+		n := len(sig.waiters["tenant"])
+		sig.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	b, _ = json.Marshal(payload{Origin: "other", ID: "tenant"})
+	notifications <- &pq.Notification{Channel: DefaultChannel, Extra: string(b)}
+	if err = <-waited; err != nil {
+		t.Fatal(err)
+	}
+	if id := <-written; id != "tenant" {
+		t.Fatalf("unexpected id: %v", id)
+	}
+	if _, ok := s.Cache.Get("tenant", "key"); ok {
+		t.Fatal("expected invalidated cache")
+	}
+
+	// Ids are decoded using the Store IDCodec
+	b, _ = json.Marshal(payload{Origin: "other", ID: "12", Key: "int:12"})
+	notifications <- &pq.Notification{Channel: DefaultChannel, Extra: string(b)}
+	if id := <-written; id != 12 {
+		t.Fatalf("unexpected id: %#v", id)
+	}
+
+	// Wait returns when ctx is done
+	waitCtx, waitCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer waitCancel()
+	if err = sig.Wait(waitCtx, "tenant"); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sig.waiters) != 0 {
+		t.Fatal("unexpected waiters")
+	}
+}
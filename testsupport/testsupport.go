@@ -0,0 +1,72 @@
+// Package testsupport provides assertions for the tests of applications which use a dblocker Store,
+// so that tests can check deterministically (without sleeping) that every Hold has been released.
+//
+// For example, at the end of a test:
+//
+//	testsupport.RequireEventuallyIdle(t, s, time.Second)
+package testsupport
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmdocs/dblocker"
+)
+
+// RequireEventuallyUnlocked fails the test if requests for the id are still waiting or granted after timeout (see Store.WaitUnlocked)
+func RequireEventuallyUnlocked(t testing.TB, s *dblocker.Store, id interface{}, timeout time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := s.WaitUnlocked(ctx, id)
+	if err != nil {
+		t.Fatalf("dblocker: id %v still locked after %v: %s", id, timeout, describe(s, func(groupID interface{}) bool { return groupID == id }))
+	}
+}
+
+// RequireEventuallyIdle fails the test if requests for any id are still waiting or granted after timeout (see Store.WaitIdle)
+func RequireEventuallyIdle(t testing.TB, s *dblocker.Store, timeout time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := s.WaitIdle(ctx)
+	if err != nil {
+		t.Fatalf("dblocker: store not idle after %v: %s", timeout, describe(s, nil))
+	}
+}
+
+// RequireNoActiveHolds fails the test if any Hold has been granted and not yet released
+func RequireNoActiveHolds(t testing.TB, s *dblocker.Store) {
+	t.Helper()
+	if holds := s.Resources().Holds; holds != 0 {
+		t.Fatalf("dblocker: %d active holds: %s", holds, describe(s, nil))
+	}
+}
+
+// describe lists the ids with holds or waiting requests (only for the ids for which match returns true, if match is not nil)
+func describe(s *dblocker.Store, match func(id interface{}) bool) string {
+	var ids []string
+	for g := range s.Groups() {
+		if (match != nil && !match(g.ID)) || (g.Holds == 0 && g.Waiting == 0) {
+			continue
+		}
+		var tags []string
+		for w := range dblocker.Filter(s.Waiters(), func(w dblocker.WaiterInfo) bool { return w.ID == g.ID }) {
+			tags = append(tags, fmt.Sprintf("%q", w.Tag))
+		}
+		desc := fmt.Sprintf("id %v (%d holds, %d waiting", g.ID, g.Holds, g.Waiting)
+		if len(tags) > 0 {
+			desc += ": " + strings.Join(tags, ", ")
+		}
+		ids = append(ids, desc+")")
+	}
+	if len(ids) == 0 {
+		return "holds are being released"
+	}
+	return strings.Join(ids, "; ")
+}
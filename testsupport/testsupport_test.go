@@ -0,0 +1,56 @@
+package testsupport
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/calmdocs/dblocker"
+)
+
+// recorder records the failures of an assertion instead of failing the test
+type recorder struct {
+	testing.TB
+
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	s, err := dblocker.New(context.Background(), "lockonly", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Active holds and waiting requests fail the assertions, and are described
+	h, err := s.RWHold("id", context.Background(), "leak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		waiting, err := s.RWHold("id", context.Background(), "next")
+		if err == nil {
+			waiting.Release()
+		}
+	}()
+	r := &recorder{TB: t}
+	RequireNoActiveHolds(r, s)
+	RequireEventuallyUnlocked(r, s, "id", 50*time.Millisecond)
+	RequireEventuallyIdle(r, s, 50*time.Millisecond)
+	if len(r.failures) != 3 || !strings.Contains(r.failures[1], `id id (1 holds, 1 waiting: "next")`) {
+		t.Fatalf("unexpected failures: %q", r.failures)
+	}
+
+	// The assertions pass once every Hold has been released
+	h.Release()
+	RequireEventuallyUnlocked(t, s, "id", time.Second)
+	RequireEventuallyIdle(t, s, time.Second)
+	RequireNoActiveHolds(t, s)
+}
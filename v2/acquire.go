@@ -0,0 +1,140 @@
+// Package dblocker (github.com/calmdocs/dblocker/v2) locks a shared database session for each id behind what is effectively a RWMutex.
+//
+// Requests take the context first and the id second, return a *Hold, and are configured using options rather than positional arguments:
+//
+//	s, err := dblocker.Open(ctx, "sqlite3", "file.db", dblocker.WithUnlockTimeout(time.Minute))
+//	h, err := s.RW(ctx, userID, dblocker.WithTag("update profile"))
+//	defer h.Release()
+//
+// The v1 module (github.com/calmdocs/dblocker) is a compatibility package which forwards to this module using type aliases,
+// so the v1 API (e.g. RWGetDB(id, ctx, tag)) remains available on the same Store, and existing code can migrate incrementally:
+// a Store created using either module can be passed to code which uses the other, and both APIs use the same locks and shared database sessions.
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Option configures a Store opened using Open
+type Option func(o *options)
+
+type options struct {
+	debug               bool
+	connector           Connector
+	unlockTimeout       *time.Duration
+	statementTimeout    *time.Duration
+	hasStatementTimeout bool
+}
+
+// WithDebug enables debug mode (see New)
+func WithDebug() Option {
+	return func(o *options) {
+		o.debug = true
+	}
+}
+
+// WithConnector connects database sessions using a custom Connector (default DefaultConnectDBFunc)
+func WithConnector(connector Connector) Option {
+	return func(o *options) {
+		o.connector = connector
+	}
+}
+
+// WithUnlockTimeout sets the time after which holds are released (default 2 minutes), where zero means no timeout
+func WithUnlockTimeout(unlockTimeout time.Duration) Option {
+	return func(o *options) {
+		o.unlockTimeout = timeout(unlockTimeout)
+	}
+}
+
+// WithStatementTimeout sets the statement timeout for database sessions (default 4 minutes where the database supports statement timeouts), where zero means no timeout
+func WithStatementTimeout(statementTimeout time.Duration) Option {
+	return func(o *options) {
+		o.statementTimeout = timeout(statementTimeout)
+		o.hasStatementTimeout = true
+	}
+}
+
+// timeout returns nil for zero (no timeout), or otherwise a pointer to the timeout
+func timeout(d time.Duration) *time.Duration {
+	if d == 0 {
+		return nil
+	}
+	return &d
+}
+
+// Open creates and starts a Store for the driverName database type and dataSourceName (see New)
+func Open(ctx context.Context, driverName string, dataSourceName string, opts ...Option) (*Store, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("open error: nil context")
+	}
+	unlockTimeout := 2 * time.Minute
+	o := options{
+		connector:     ConnectDBFuncConnector(DefaultConnectDBFunc),
+		unlockTimeout: &unlockTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !o.hasStatementTimeout {
+		if caps, _ := DriverCapabilities(driverName); caps.StatementTimeout {
+			o.statementTimeout = timeout(4 * time.Minute)
+		}
+	}
+	return NewWithConnector(ctx, o.connector, driverName, dataSourceName, o.unlockTimeout, o.statementTimeout, o.debug)
+}
+
+// AcquireOption configures a database access request
+type AcquireOption func(r *acquireRequest)
+
+type acquireRequest struct {
+	tag              string
+	statementTimeout *time.Duration
+}
+
+// WithTag sets the tag of the request, which describes the request in events, metrics, and logs
+func WithTag(tag string) AcquireOption {
+	return func(r *acquireRequest) {
+		r.tag = tag
+	}
+}
+
+// WithSessionStatementTimeout sets the statement timeout of the new database session of an AccessRWSeparate request, where zero means no timeout
+func WithSessionStatementTimeout(statementTimeout time.Duration) AcquireOption {
+	return func(r *acquireRequest) {
+		r.statementTimeout = &statementTimeout
+	}
+}
+
+// Acquire waits for access to the database for the id using the access mode, and returns the granted Hold.
+// The Hold is released when Release is called, when ctx is done, or when the unlockTimeout expires.
+func (s *Store) Acquire(ctx context.Context, id interface{}, mode AccessMode, opts ...AcquireOption) (*Hold, error) {
+	var r acquireRequest
+	for _, opt := range opts {
+		opt(&r)
+	}
+	switch mode {
+	case AccessRW:
+		return s.RWHold(id, ctx, r.tag)
+	case AccessRWSeparate:
+		return s.RWHoldWithTimeout(id, ctx, r.tag, r.statementTimeout)
+	case AccessRead:
+		return s.ReadHold(id, ctx, r.tag)
+	case AccessStream:
+		return s.StreamHold(id, ctx, r.tag)
+	default:
+		return nil, fmt.Errorf("unknown access type error: %s", mode)
+	}
+}
+
+// RW waits for exclusive access to the shared database session for the id (see Acquire)
+func (s *Store) RW(ctx context.Context, id interface{}, opts ...AcquireOption) (*Hold, error) {
+	return s.Acquire(ctx, id, AccessRW, opts...)
+}
+
+// Read waits for shared access to the shared database session for the id (see Acquire)
+func (s *Store) Read(ctx context.Context, id interface{}, opts ...AcquireOption) (*Hold, error) {
+	return s.Acquire(ctx, id, AccessRead, opts...)
+}
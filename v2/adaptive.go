@@ -0,0 +1,148 @@
+package dblocker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// querySampleCount is the maximum number of recent query durations kept for each id
+const querySampleCount = 128
+
+// AdaptiveStatementTimeout adjusts the statement timeout for each id based on the p99 of the query durations observed for the id (see ObserveQuery),
+// so that rare slow-but-legitimate ids are not cancelled while fast ids keep a tight timeout.
+// The adjusted statement timeout is used when the shared database session for the id is connected, and is set for each connection returned by Hold.Conn.
+type AdaptiveStatementTimeout struct {
+
+	// Min and Max bound the statement timeout
+	Min time.Duration
+	Max time.Duration
+
+	// Multiplier is applied to the p99 query duration (default 2)
+	Multiplier float64
+
+	// MinSamples is the number of observed query durations required before the statement timeout is adjusted (default 20)
+	MinSamples int
+}
+
+type adaptive struct {
+	sync.Mutex
+
+	m map[interface{}]*querySamples
+}
+
+// querySamples are the recent query durations and the adapted statement timeout for an id
+type querySamples struct {
+	buf     [querySampleCount]time.Duration
+	next    int
+	n       int
+	timeout time.Duration
+	at      time.Time
+}
+
+// ObserveQuery records the duration of a query for the specified id, which is used to adjust the statement timeout for the id if the Store AdaptiveStatementTimeout is set.
+// ObserveQuery can be called, for example, from instrumentation added using WrapDBFunc.
+func (s *Store) ObserveQuery(id interface{}, d time.Duration) {
+	id = s.lockKey(id)
+	cfg := s.AdaptiveStatementTimeout
+	if cfg == nil {
+		return
+	}
+	minSamples := cfg.MinSamples
+	if minSamples <= 0 {
+		minSamples = 20
+	}
+
+	s.adaptive.Lock()
+	if s.adaptive.m == nil {
+		s.adaptive.m = make(map[interface{}]*querySamples)
+	}
+
+	// Forget ids without recent queries
+	now := time.Now()
+	if len(s.adaptive.m) >= maxHoldStats {
+		for otherID, qs := range s.adaptive.m {
+			if now.Sub(qs.at) > 10*time.Minute {
+				delete(s.adaptive.m, otherID)
+			}
+		}
+	}
+
+	qs, ok := s.adaptive.m[id]
+	if !ok {
+		qs = &querySamples{}
+		s.adaptive.m[id] = qs
+	}
+	qs.buf[qs.next] = d
+	qs.next = (qs.next + 1) % querySampleCount
+	if qs.n < querySampleCount {
+		qs.n++
+	}
+	qs.at = now
+	if qs.n < minSamples {
+		s.adaptive.Unlock()
+		return
+	}
+
+	// Only change the statement timeout by more than 10%
+	timeout := cfg.timeout(qs.p99())
+	if qs.timeout == 0 || timeout > qs.timeout+qs.timeout/10 || timeout < qs.timeout-qs.timeout/10 {
+		qs.timeout = timeout
+	}
+	s.adaptive.Unlock()
+}
+
+// ObserveQuery records the duration of a query made using the Hold (see Store.ObserveQuery).
+// In debug mode, ObserveQuery also counts the queries made using the Hold (see Queries and the Store QueryBudget).
+func (h *Hold) ObserveQuery(d time.Duration) {
+	h.countQuery()
+	h.s.ObserveQuery(h.id, d)
+}
+
+// StatementTimeoutFor returns the statement timeout for the shared database session for the specified id,
+// which is the adapted statement timeout if the Store AdaptiveStatementTimeout is set, the database supports statement timeouts, and enough query durations have been observed,
+// or the Store StatementTimeout otherwise (nil means no timeout).
+func (s *Store) StatementTimeoutFor(id interface{}) *time.Duration {
+	id = s.lockKey(id)
+	s.Lock()
+	statementTimeout := s.StatementTimeout
+	caps, _ := DriverCapabilities(s.DriverName)
+	s.Unlock()
+	if s.AdaptiveStatementTimeout == nil || !caps.StatementTimeout {
+		return statementTimeout
+	}
+
+	s.adaptive.Lock()
+	defer s.adaptive.Unlock()
+
+	qs, ok := s.adaptive.m[id]
+	if !ok || qs.timeout == 0 {
+		return statementTimeout
+	}
+	timeout := qs.timeout
+	return &timeout
+}
+
+// p99 returns the 99th percentile query duration
+func (qs *querySamples) p99() time.Duration {
+	durations := make([]time.Duration, qs.n)
+	copy(durations, qs.buf[:qs.n])
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[(len(durations)*99)/100]
+}
+
+// timeout returns the statement timeout for a p99 query duration
+func (cfg *AdaptiveStatementTimeout) timeout(p99 time.Duration) time.Duration {
+	multiplier := cfg.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	timeout := time.Duration(float64(p99) * multiplier)
+	if cfg.Max > 0 && timeout > cfg.Max {
+		timeout = cfg.Max
+	}
+	if timeout < cfg.Min {
+		timeout = cfg.Min
+	}
+	return timeout
+}
@@ -0,0 +1,42 @@
+package dblocker
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AdoptDB uses an externally created database (*sqlx.DB) as the shared database session for the specified id.
+// The adopted database is locked and managed in the same way as databases connected using the connectDBFunc,
+// except that the group for the id is not deleted when there are no requests for the id.
+// The adopted database is closed when Evict is called for the id, or replaced when Reconnect is called for the id.
+// RWGetDBWithTimeout sessions for the id are still connected using the connectDBFunc.
+// AdoptDB returns an error if a group already exists for the id (call Evict first).
+func (s *Store) AdoptDB(id interface{}, db *sqlx.DB) error {
+	id = s.lockKey(id)
+	if db == nil {
+		return fmt.Errorf("adopt error: nil database")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, ok := s.m[id]
+	if ok {
+		return fmt.Errorf("adopt error: group already exists for id: %v", id)
+	}
+	if s.adopted == nil {
+		s.adopted = make(map[interface{}]*sqlx.DB)
+	}
+	s.adopted[id] = db
+	return nil
+}
+
+// AdoptSQLDB uses an externally created database (*sql.DB) as the shared database session for the specified id (see AdoptDB).
+func (s *Store) AdoptSQLDB(id interface{}, db *sql.DB) error {
+	if db == nil {
+		return fmt.Errorf("adopt error: nil database")
+	}
+	return s.AdoptDB(id, sqlx.NewDb(db, s.settings().driverName))
+}
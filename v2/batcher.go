@@ -0,0 +1,187 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// States of a batchItem
+const (
+	batchPending int32 = iota
+	batchRunning
+	batchSkipped
+)
+
+// batchItem is a write submitted using BatchWrite
+type batchItem struct {
+	ctx  context.Context
+	tag  string
+	fn   func(tx *sqlx.Tx) error
+	done chan error
+
+	// state is changed from batchPending to batchRunning by the batch before the write is executed, or to batchSkipped if the write is skipped
+	state atomic.Int32
+}
+
+// batcher is the queue of writes waiting to be executed for an id
+type batcher struct {
+	items []*batchItem
+}
+
+type batchers struct {
+	sync.Mutex
+
+	m map[interface{}]*batcher
+}
+
+// BatchWrite submits a small write for the specified id and waits for it to be executed.
+// Writes for an id are executed in order, in batches of up to the Store BatchMaxSize writes (default 100), under a single RW hold and a single transaction,
+// which reduces lock churn for high-frequency small updates to the same id.
+// Each write runs within a savepoint, so a write that returns an error is rolled back without affecting the other writes in the batch.
+// BatchWrite returns the error returned by fn, the error from committing the batch, or an error if the write is not authorized (see Authorizer) or the RW hold could not be acquired.
+// Each write is authorized using its own ctx when it is submitted, and the RW hold for a batch is acquired using the values (but not the cancellation) of the ctx of the first write in the batch.
+// If ctx is done before the write is executed, BatchWrite returns the ctx error and the write is skipped.
+// If ctx is done after the batch has started executing the write, BatchWrite waits for the result of the batch, so that a write which is committed is never reported as skipped.
+func (s *Store) BatchWrite(id interface{}, ctx context.Context, tag string, fn func(tx *sqlx.Tx) error) error {
+	id = s.lockKey(id)
+	tag = s.requestTag(ctx, tag)
+	err := s.checkTag("rw", id, tag)
+	if err != nil {
+		return err
+	}
+	err = s.authorize(ctx, id, AccessRW, tag)
+	if err != nil {
+		return err
+	}
+
+	item := &batchItem{
+		ctx:  ctx,
+		tag:  tag,
+		fn:   fn,
+		done: make(chan error, 1),
+	}
+
+	s.batchers.Lock()
+	if s.batchers.m == nil {
+		s.batchers.m = make(map[interface{}]*batcher)
+	}
+	b, ok := s.batchers.m[id]
+	if !ok {
+		b = &batcher{}
+		s.batchers.m[id] = b
+		s.spawn("batcher", func() { s.runBatcher(id, b) })
+	}
+	b.items = append(b.items, item)
+	s.batchers.Unlock()
+
+	select {
+	case err := <-item.done:
+		return err
+	case <-ctx.Done():
+		if item.state.CompareAndSwap(batchPending, batchSkipped) {
+			return ctx.Err()
+		}
+		return <-item.done
+	}
+}
+
+// runBatcher executes batches of writes for an id until there are no more writes for the id
+func (s *Store) runBatcher(id interface{}, b *batcher) {
+	maxSize := s.BatchMaxSize
+	if maxSize <= 0 {
+		maxSize = 100
+	}
+
+	for {
+		s.batchers.Lock()
+		if len(b.items) == 0 {
+			delete(s.batchers.m, id)
+			s.batchers.Unlock()
+			return
+		}
+		n := len(b.items)
+		if n > maxSize {
+			n = maxSize
+		}
+		items := b.items[:n:n]
+		b.items = b.items[n:]
+		s.batchers.Unlock()
+
+		s.runBatch(id, items)
+	}
+}
+
+// runBatch executes a batch of writes under a single RW hold and a single transaction, and reports the result of each write
+func (s *Store) runBatch(id interface{}, items []*batchItem) {
+	errs := make([]error, len(items))
+	report := func() {
+		for i, item := range items {
+			item.done <- errs[i]
+		}
+	}
+	fail := func(err error) {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		report()
+	}
+
+	// The hold for the batch has its own context, which is not cancelled by the ctx of the first write, and which is cancelled when the batch is finished (see RequireRequestEnd)
+	holdCtx, cancel := context.WithCancel(context.WithoutCancel(items[0].ctx))
+	defer cancel()
+	h, err := s.RWHold(id, holdCtx, items[0].tag)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer h.Release()
+
+	tx, err := h.BeginTxx(nil)
+	if err != nil {
+		fail(err)
+		return
+	}
+	for i, item := range items {
+
+		// Skip writes whose ctx is done, and writes which BatchWrite has stopped waiting for
+		if item.ctx.Err() != nil {
+			item.state.CompareAndSwap(batchPending, batchSkipped)
+		}
+		if !item.state.CompareAndSwap(batchPending, batchRunning) {
+			errs[i] = item.ctx.Err()
+			continue
+		}
+
+		_, err = tx.ExecContext(h.Context(), "SAVEPOINT dblocker_batch")
+		if err != nil {
+			tx.Rollback()
+			fail(err)
+			return
+		}
+		errs[i] = item.fn(tx)
+		if errs[i] != nil {
+			_, err = tx.ExecContext(h.Context(), "ROLLBACK TO SAVEPOINT dblocker_batch")
+		} else {
+			_, err = tx.ExecContext(h.Context(), "RELEASE SAVEPOINT dblocker_batch")
+		}
+		if err != nil {
+			tx.Rollback()
+			fail(fmt.Errorf("batch error: savepoint: %w", err))
+			return
+		}
+	}
+
+	// Writes which succeeded fail with the commit error
+	err = tx.Commit()
+	if err != nil {
+		fail(err)
+		return
+	}
+	report()
+}
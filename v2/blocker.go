@@ -0,0 +1,88 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// blockerStackSize is the maximum size of the stack captured for each Hold in debug mode (see BlockerThreshold)
+const blockerStackSize = 8192
+
+// Blocker describes a Hold which was active when a request timed out (or was cancelled) while waiting for access to the same id (see BlockedError)
+type Blocker struct {
+	Tag       string
+	Mode      AccessMode
+	Metadata  Metadata
+	GrantedAt time.Time
+
+	// Stack is the stack of the goroutine that requested the Hold (or that last transferred the Hold, see Hold.Transfer), which is only captured in debug mode
+	Stack string
+}
+
+// BlockedError is returned when a request waits for longer than the Store BlockerThreshold and then times out or is cancelled.
+// BlockedError wraps the wait error (e.g. context.DeadlineExceeded), and describes the holds for the id which were active when the request stopped waiting.
+type BlockedError struct {
+	ID       interface{}
+	Wait     time.Duration
+	Blockers []Blocker
+	Err      error
+}
+
+func (e *BlockedError) Error() string {
+	if len(e.Blockers) == 0 {
+		return fmt.Sprintf("waited %s for id %v: %s", e.Wait.Round(time.Millisecond), e.ID, e.Err.Error())
+	}
+	blockers := make([]string, 0, len(e.Blockers))
+	for _, b := range e.Blockers {
+		blockers = append(blockers, fmt.Sprintf("%s hold %q granted %s ago", b.Mode, b.Tag, time.Since(b.GrantedAt).Round(time.Millisecond)))
+	}
+	return fmt.Sprintf("waited %s for id %v: %s (blocked by %s)", e.Wait.Round(time.Millisecond), e.ID, e.Err.Error(), strings.Join(blockers, ", "))
+}
+
+func (e *BlockedError) Unwrap() error {
+	return e.Err
+}
+
+// blockedError returns a BlockedError describing the active holds for an id if the Store BlockerThreshold is set,
+// the request waited for at least the BlockerThreshold, and err is a context error. Otherwise blockedError returns err.
+func (s *Store) blockedError(id interface{}, wait time.Duration, err error) error {
+	if s.BlockerThreshold <= 0 || wait < s.BlockerThreshold {
+		return err
+	}
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	blocked := &BlockedError{ID: id, Wait: wait, Err: err}
+	if q, ok := s.queues.m[id]; ok {
+		for h := range q.holds {
+			if h.ctx.Err() != nil {
+				continue
+			}
+			blocked.Blockers = append(blocked.Blockers, Blocker{
+				Tag:       h.tag,
+				Mode:      AccessMode(h.accessType),
+				Metadata:  h.Metadata(),
+				GrantedAt: h.grantedAt,
+				Stack:     h.ownerStack(),
+			})
+		}
+	}
+	return blocked
+}
+
+// captureStack returns the stack of the current goroutine if the Store BlockerThreshold is set and the Store is in debug mode
+func (s *Store) captureStack() string {
+	if s.BlockerThreshold <= 0 || !s.settings().debug {
+		return ""
+	}
+	buf := make([]byte, blockerStackSize)
+	return string(buf[:runtime.Stack(buf, false)])
+}
@@ -0,0 +1,35 @@
+package dblocker
+
+import (
+	"context"
+	"time"
+)
+
+type waitDeadlineKey struct{}
+
+// WithWaitBudget returns a context which splits the time remaining until the ctx deadline (e.g. the budget for an HTTP request)
+// between waiting for access to the database and holding access to the database.
+// Requests made using the returned context stop waiting for access after waitRatio (between 0 and 1) of the remaining time,
+// and holds granted using the returned context are released at the ctx deadline (so are held for at most the rest of the remaining time).
+// WithWaitBudget returns ctx unchanged if ctx has no deadline.
+func WithWaitBudget(ctx context.Context, waitRatio float64) context.Context {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx
+	}
+	switch {
+	case waitRatio < 0:
+		waitRatio = 0
+	case waitRatio > 1:
+		waitRatio = 1
+	}
+	remaining := time.Until(deadline)
+	waitDeadline := time.Now().Add(time.Duration(float64(remaining) * waitRatio))
+	return context.WithValue(ctx, waitDeadlineKey{}, waitDeadline)
+}
+
+// waitDeadlineFromContext returns the wait deadline set using WithWaitBudget
+func waitDeadlineFromContext(ctx context.Context) (waitDeadline time.Time, ok bool) {
+	waitDeadline, ok = ctx.Value(waitDeadlineKey{}).(time.Time)
+	return waitDeadline, ok
+}
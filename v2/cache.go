@@ -0,0 +1,121 @@
+package dblocker
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Cache is a backend for caching the results of read queries for each id.
+// Cached results for an id are invalidated whenever a RW hold for that id is granted and released.
+type Cache interface {
+	Get(id interface{}, key string) (value []byte, ok bool)
+	Set(id interface{}, key string, value []byte, ttl time.Duration)
+	Invalidate(id interface{})
+}
+
+// MemoryCache is an in-memory Cache
+type MemoryCache struct {
+	sync.Mutex
+
+	m map[interface{}]map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryCache creates a new in-memory Cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		m: make(map[interface{}]map[string]memoryCacheEntry),
+	}
+}
+
+// Get returns the cached value for the id and key if it exists and has not expired
+func (c *MemoryCache) Get(id interface{}, key string) (value []byte, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.m[id][key]
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(c.m[id], key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set caches the value for the id and key.  A ttl of zero means that the value does not expire.
+func (c *MemoryCache) Set(id interface{}, key string, value []byte, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.m[id] == nil {
+		c.m[id] = make(map[string]memoryCacheEntry)
+	}
+	e := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.m[id][key] = e
+}
+
+// Invalidate removes all cached values for the id
+func (c *MemoryCache) Invalidate(id interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.m, id)
+}
+
+// CachedSelect runs sqlx SelectContext using the Hold database and scans the results into dest,
+// using results cached under key in the Store Cache for read Holds where available.
+// dest must be able to be encoded and decoded using encoding/json.
+// RW Holds, and Stores without a Cache, always query the database.
+func (h *Hold) CachedSelect(key string, dest interface{}, query string, args ...interface{}) (err error) {
+	return h.cached(key, dest, func() error {
+		return h.db.SelectContext(h.ctx, dest, query, args...)
+	})
+}
+
+// CachedGet runs sqlx GetContext using the Hold database and scans the result into dest,
+// using results cached under key in the Store Cache for read Holds where available.
+// dest must be able to be encoded and decoded using encoding/json.
+// RW Holds, and Stores without a Cache, always query the database.
+func (h *Hold) CachedGet(key string, dest interface{}, query string, args ...interface{}) (err error) {
+	return h.cached(key, dest, func() error {
+		return h.db.GetContext(h.ctx, dest, query, args...)
+	})
+}
+
+func (h *Hold) cached(key string, dest interface{}, queryFunc func() error) (err error) {
+	c := h.s.Cache
+	if c == nil || h.accessType != "read" {
+		return queryFunc()
+	}
+
+	// Use cached value
+	value, ok := c.Get(h.id, key)
+	if ok {
+		err = json.Unmarshal(value, dest)
+		if err == nil {
+			return nil
+		}
+	}
+
+	// Query and cache value
+	err = queryFunc()
+	if err != nil {
+		return err
+	}
+	value, err = json.Marshal(dest)
+	if err != nil {
+		return err
+	}
+	c.Set(h.id, key, value, h.s.settings().cacheTTL)
+	return nil
+}
@@ -0,0 +1,165 @@
+package dblocker
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// IDCodec serializes ids for backends shared between processes (e.g. the pgsignal NOTIFY payloads and advisory lock keys, see AdvisoryKey),
+// so that every process refers to an id using the same string, and ids sent by other processes are decoded to the ids used by the Store.
+type IDCodec interface {
+	Encode(id interface{}) (string, error)
+	Decode(s string) (id interface{}, err error)
+}
+
+// DefaultIDCodec encodes ids as the name of the id type and the id value (e.g. "string:abc", "int64:12", or "ID:org/12/db/3"), so that decoded ids have the same type as the encoded ids.
+// Strings, integers, bools, Keys, and IDs are supported by default, and other id types can be added using RegisterIDType.
+var DefaultIDCodec IDCodec = defaultIDCodec{}
+
+// idType encodes and decodes the ids of one type
+type idType struct {
+	name   string
+	encode func(id interface{}) (string, error)
+	decode func(s string) (id interface{}, err error)
+}
+
+var idTypes = struct {
+	sync.RWMutex
+
+	byType map[reflect.Type]idType
+	byName map[string]idType
+}{
+	byType: make(map[reflect.Type]idType),
+	byName: make(map[string]idType),
+}
+
+func init() {
+	RegisterIDType("string", "", func(id interface{}) (string, error) { return id.(string), nil }, func(s string) (interface{}, error) { return s, nil })
+	RegisterIDType("Key", Key(""), func(id interface{}) (string, error) { return string(id.(Key)), nil }, func(s string) (interface{}, error) { return Key(s), nil })
+	RegisterIDType("ID", ID{}, func(id interface{}) (string, error) { return id.(ID).key, nil }, func(s string) (interface{}, error) { return ID{key: s}, nil })
+	RegisterIDType("bool", false, func(id interface{}) (string, error) { return strconv.FormatBool(id.(bool)), nil }, func(s string) (interface{}, error) { return strconv.ParseBool(s) })
+	registerIntIDType("int", int(0), 0)
+	registerIntIDType("int8", int8(0), 8)
+	registerIntIDType("int16", int16(0), 16)
+	registerIntIDType("int32", int32(0), 32)
+	registerIntIDType("int64", int64(0), 64)
+	registerIntIDType("uint", uint(0), 0)
+	registerIntIDType("uint8", uint8(0), 8)
+	registerIntIDType("uint16", uint16(0), 16)
+	registerIntIDType("uint32", uint32(0), 32)
+	registerIntIDType("uint64", uint64(0), 64)
+}
+
+// registerIntIDType registers an integer id type, using reflect to convert between the integer type and int64 or uint64
+func registerIntIDType(name string, sample interface{}, bitSize int) {
+	t := reflect.TypeOf(sample)
+	signed := t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64
+	RegisterIDType(name, sample, func(id interface{}) (string, error) {
+		if signed {
+			return strconv.FormatInt(reflect.ValueOf(id).Int(), 10), nil
+		}
+		return strconv.FormatUint(reflect.ValueOf(id).Uint(), 10), nil
+	}, func(s string) (interface{}, error) {
+		v := reflect.New(t).Elem()
+		if signed {
+			n, err := strconv.ParseInt(s, 10, bitSize)
+			if err != nil {
+				return nil, err
+			}
+			v.SetInt(n)
+			return v.Interface(), nil
+		}
+		n, err := strconv.ParseUint(s, 10, bitSize)
+		if err != nil {
+			return nil, err
+		}
+		v.SetUint(n)
+		return v.Interface(), nil
+	})
+}
+
+// RegisterIDType adds (or replaces) an id type encoded by the DefaultIDCodec, using the type of sample (e.g. RegisterIDType("tenant", TenantID{}, encode, decode)).
+// The name identifies the id type in encoded ids, so it must be the same in every process and must not contain ":".
+// encode is only called with ids of the type of sample, and decode must return ids of the same type.
+func RegisterIDType(name string, sample interface{}, encode func(id interface{}) (string, error), decode func(s string) (id interface{}, err error)) {
+	if name == "" || strings.Contains(name, ":") {
+		panic(fmt.Sprintf("dblocker: invalid id type name: %q", name))
+	}
+	t := idType{name: name, encode: encode, decode: decode}
+
+	idTypes.Lock()
+	defer idTypes.Unlock()
+
+	idTypes.byType[reflect.TypeOf(sample)] = t
+	idTypes.byName[name] = t
+}
+
+type defaultIDCodec struct{}
+
+// Encode encodes an id as the name of the id type and the id value, and returns an error if the id type has not been registered
+func (defaultIDCodec) Encode(id interface{}) (string, error) {
+	idTypes.RLock()
+	t, ok := idTypes.byType[reflect.TypeOf(id)]
+	idTypes.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("id codec error: id type not registered: %T", id)
+	}
+	s, err := t.encode(id)
+	if err != nil {
+		return "", fmt.Errorf("id codec error: %w", err)
+	}
+	return t.name + ":" + s, nil
+}
+
+// Decode decodes an id encoded by Encode
+func (defaultIDCodec) Decode(s string) (id interface{}, err error) {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("id codec error: invalid encoded id: %q", s)
+	}
+	idTypes.RLock()
+	t, ok := idTypes.byName[name]
+	idTypes.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("id codec error: id type not registered: %s", name)
+	}
+	id, err = t.decode(value)
+	if err != nil {
+		return nil, fmt.Errorf("id codec error: %w", err)
+	}
+	return id, nil
+}
+
+// idCodec returns the Store IDCodec, or the DefaultIDCodec if not set
+func (s *Store) idCodec() IDCodec {
+	if s.IDCodec == nil {
+		return DefaultIDCodec
+	}
+	return s.IDCodec
+}
+
+// EncodeID encodes the id used for the lock of an id (see Keyer) using the Store IDCodec
+func (s *Store) EncodeID(id interface{}) (string, error) {
+	return s.idCodec().Encode(s.lockKey(id))
+}
+
+// DecodeID decodes an id encoded by EncodeID (e.g. in another process) using the Store IDCodec
+func (s *Store) DecodeID(encoded string) (id interface{}, err error) {
+	return s.idCodec().Decode(encoded)
+}
+
+// AdvisoryKey returns the int64 advisory lock key for an id (see the DriverSpec AdvisoryLockSQL), which is a hash of the id encoded using the Store IDCodec.
+// Processes using the same IDCodec use the same advisory lock key for an id.
+func (s *Store) AdvisoryKey(id interface{}) (int64, error) {
+	encoded, err := s.EncodeID(id)
+	if err != nil {
+		return 0, err
+	}
+	h := fnv.New64a()
+	h.Write([]byte(encoded))
+	return int64(h.Sum64()), nil
+}
@@ -0,0 +1,290 @@
+package dblocker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Config is a declarative Store configuration, which can be loaded from JSON (see LoadConfigFile), YAML (using a YAML library), or environment variables (see ConfigFromEnv).
+type Config struct {
+	DriverName     string `json:"driver_name" yaml:"driver_name" env:"DRIVER_NAME"`
+	DataSourceName string `json:"data_source_name" yaml:"data_source_name" env:"DATA_SOURCE_NAME"`
+	Debug          bool   `json:"debug" yaml:"debug" env:"DEBUG"`
+
+	// UnlockTimeout and StatementTimeout use the New defaults if not set, and zero means no timeout.
+	UnlockTimeout    *Duration `json:"unlock_timeout" yaml:"unlock_timeout" env:"UNLOCK_TIMEOUT"`
+	StatementTimeout *Duration `json:"statement_timeout" yaml:"statement_timeout" env:"STATEMENT_TIMEOUT"`
+
+	// LockTimeout is the timeout for waiting for database locks (see the Store LockTimeout), and zero (or not set) uses the database default.
+	LockTimeout *Duration `json:"lock_timeout" yaml:"lock_timeout" env:"LOCK_TIMEOUT"`
+
+	MaxOpenConns    int      `json:"max_open_conns" yaml:"max_open_conns" env:"MAX_OPEN_CONNS"`
+	MaxIdleConns    int      `json:"max_idle_conns" yaml:"max_idle_conns" env:"MAX_IDLE_CONNS"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime" yaml:"conn_max_lifetime" env:"CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time" yaml:"conn_max_idle_time" env:"CONN_MAX_IDLE_TIME"`
+	ReconnectDelay  Duration `json:"reconnect_delay" yaml:"reconnect_delay" env:"RECONNECT_DELAY"`
+	ConnectTimeout  Duration `json:"connect_timeout" yaml:"connect_timeout" env:"CONNECT_TIMEOUT"`
+
+	TeardownPolicy TeardownPolicy `json:"teardown_policy" yaml:"teardown_policy" env:"TEARDOWN_POLICY"`
+	TeardownLinger Duration       `json:"teardown_linger" yaml:"teardown_linger" env:"TEARDOWN_LINGER"`
+
+	CacheTTL               Duration `json:"cache_ttl" yaml:"cache_ttl" env:"CACHE_TTL"`
+	AfterReleaseRetries    int      `json:"after_release_retries" yaml:"after_release_retries" env:"AFTER_RELEASE_RETRIES"`
+	AfterReleaseRetryDelay Duration `json:"after_release_retry_delay" yaml:"after_release_retry_delay" env:"AFTER_RELEASE_RETRY_DELAY"`
+
+	StreamMaxDuration Duration `json:"stream_max_duration" yaml:"stream_max_duration" env:"STREAM_MAX_DURATION"`
+
+	// ReadOnly makes the Store read-only (see Store.SetReadOnly)
+	ReadOnly bool `json:"read_only" yaml:"read_only" env:"READ_ONLY"`
+}
+
+// Duration is a time.Duration which is encoded as a string (e.g. "2m30s")
+type Duration time.Duration
+
+// String returns the duration as a string
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalText encodes the duration as a string
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes a duration string (e.g. "2m30s")
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q (use a value such as \"30s\" or \"2m\")", string(text))
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// String returns the teardown policy name
+func (p TeardownPolicy) String() string {
+	switch p {
+	case TeardownImmediate:
+		return "immediate"
+	case TeardownLinger:
+		return "linger"
+	case TeardownNever:
+		return "never"
+	default:
+		return fmt.Sprintf("TeardownPolicy(%d)", int(p))
+	}
+}
+
+// MarshalText encodes the teardown policy name
+func (p TeardownPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a teardown policy name ("immediate", "linger", or "never")
+func (p *TeardownPolicy) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "", "immediate":
+		*p = TeardownImmediate
+	case "linger":
+		*p = TeardownLinger
+	case "never":
+		*p = TeardownNever
+	default:
+		return fmt.Errorf("invalid teardown policy %q (use \"immediate\", \"linger\", or \"never\")", string(text))
+	}
+	return nil
+}
+
+// LoadConfigFile loads a JSON Config file
+func LoadConfigFile(path string) (cfg Config, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("config error: %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ConfigFromEnv loads a Config from environment variables named using the prefix and the Config env tags (e.g. DBLOCKER_DRIVER_NAME for the prefix "DBLOCKER_").
+// Unset environment variables are left as zero values.
+func ConfigFromEnv(prefix string) (cfg Config, err error) {
+	v := reflect.ValueOf(&cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := prefix + t.Field(i).Tag.Get("env")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Ptr {
+			field.Set(reflect.New(field.Type().Elem()))
+			field = field.Elem()
+		}
+		if u, ok := field.Addr().Interface().(interface{ UnmarshalText([]byte) error }); ok {
+			err = u.UnmarshalText([]byte(value))
+		} else {
+			switch field.Kind() {
+			case reflect.String:
+				field.SetString(value)
+			case reflect.Bool:
+				var b bool
+				b, err = strconv.ParseBool(value)
+				field.SetBool(b)
+			case reflect.Int:
+				var n int64
+				n, err = strconv.ParseInt(value, 10, 64)
+				field.SetInt(n)
+			default:
+				err = fmt.Errorf("unsupported type")
+			}
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("config error: %s: %w", name, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Validate returns an error describing every invalid setting in the Config
+func (cfg Config) Validate() error {
+	var errs []error
+	invalid := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf("config error: "+format, a...))
+	}
+
+	spec, ok := LookupDriver(cfg.DriverName)
+	switch {
+	case cfg.DriverName == "":
+		invalid("driver_name is required (use \"sqlite3\", \"postgres\", or \"mysql\")")
+	case !ok:
+		invalid("driver_name %q not implemented (use \"sqlite3\", \"postgres\", or \"mysql\", or use NewWithConnectDBFuncAndTimeouts for other databases)%s", cfg.DriverName, driverImportHint(cfg.DriverName))
+	default:
+		if err := spec.validateCapabilities(); err != nil {
+			invalid("driver_name %q is invalid: %v", cfg.DriverName, err)
+		}
+	}
+	switch {
+	case cfg.DataSourceName == "" && cfg.DriverName != "mock":
+		invalid("data_source_name is required")
+	case ok && spec.Validate != nil:
+		if err := spec.Validate(cfg.DataSourceName); err != nil {
+			invalid("data_source_name is invalid for driver_name %q: %v", cfg.DriverName, err)
+		}
+	}
+
+	if cfg.UnlockTimeout != nil && *cfg.UnlockTimeout < 0 {
+		invalid("unlock_timeout must not be negative (use \"0s\" for no timeout)")
+	}
+	if cfg.StatementTimeout != nil {
+		switch {
+		case *cfg.StatementTimeout < 0:
+			invalid("statement_timeout must not be negative (use \"0s\" for no timeout)")
+		case *cfg.StatementTimeout > 0 && spec.SetStatementTimeout == nil:
+			invalid("statement_timeout is not supported for driver_name %q (remove statement_timeout or set it to \"0s\")", cfg.DriverName)
+		}
+	}
+	if cfg.LockTimeout != nil {
+		switch {
+		case *cfg.LockTimeout < 0:
+			invalid("lock_timeout must not be negative (use \"0s\" for the database default)")
+		case *cfg.LockTimeout > 0 && spec.SetLockTimeout == nil:
+			invalid("lock_timeout is not supported for driver_name %q (remove lock_timeout or set it to \"0s\")", cfg.DriverName)
+		}
+	}
+
+	if cfg.MaxOpenConns < 0 {
+		invalid("max_open_conns must not be negative (use 0 for no limit)")
+	}
+	if cfg.MaxIdleConns < 0 {
+		invalid("max_idle_conns must not be negative")
+	}
+	if cfg.MaxOpenConns > 0 && cfg.MaxIdleConns > cfg.MaxOpenConns {
+		invalid("max_idle_conns (%d) must not be greater than max_open_conns (%d)", cfg.MaxIdleConns, cfg.MaxOpenConns)
+	}
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"conn_max_lifetime", cfg.ConnMaxLifetime},
+		{"conn_max_idle_time", cfg.ConnMaxIdleTime},
+		{"reconnect_delay", cfg.ReconnectDelay},
+		{"connect_timeout", cfg.ConnectTimeout},
+		{"teardown_linger", cfg.TeardownLinger},
+		{"cache_ttl", cfg.CacheTTL},
+		{"after_release_retry_delay", cfg.AfterReleaseRetryDelay},
+		{"stream_max_duration", cfg.StreamMaxDuration},
+	} {
+		if d.value < 0 {
+			invalid("%s must not be negative", d.name)
+		}
+	}
+
+	switch cfg.TeardownPolicy {
+	case TeardownImmediate, TeardownNever:
+	case TeardownLinger:
+		if cfg.TeardownLinger <= 0 {
+			invalid("teardown_linger is required for the \"linger\" teardown_policy (e.g. \"30s\")")
+		}
+	default:
+		invalid("teardown_policy %v not implemented (use \"immediate\", \"linger\", or \"never\")", cfg.TeardownPolicy)
+	}
+	if cfg.AfterReleaseRetries < 0 {
+		invalid("after_release_retries must not be negative")
+	}
+	return errors.Join(errs...)
+}
+
+// NewFromConfig creates a new dblocker Store using the default connectDBFunc and the settings in the Config
+func NewFromConfig(ctx context.Context, cfg Config) (s *Store, err error) {
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err = New(ctx, cfg.DriverName, cfg.DataSourceName, cfg.Debug)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.UnlockTimeout != nil {
+		s.UnlockTimeout = cfg.UnlockTimeout.timeout()
+	}
+	if cfg.StatementTimeout != nil {
+		s.StatementTimeout = cfg.StatementTimeout.timeout()
+	}
+	if cfg.LockTimeout != nil {
+		s.LockTimeout = cfg.LockTimeout.timeout()
+	}
+	s.MaxOpenConns = cfg.MaxOpenConns
+	s.MaxIdleConns = cfg.MaxIdleConns
+	s.ConnMaxLifetime = time.Duration(cfg.ConnMaxLifetime)
+	s.ConnMaxIdleTime = time.Duration(cfg.ConnMaxIdleTime)
+	s.ReconnectDelay = time.Duration(cfg.ReconnectDelay)
+	s.ConnectTimeout = time.Duration(cfg.ConnectTimeout)
+	s.TeardownPolicy = cfg.TeardownPolicy
+	s.TeardownLinger = time.Duration(cfg.TeardownLinger)
+	s.CacheTTL = time.Duration(cfg.CacheTTL)
+	s.AfterReleaseRetries = cfg.AfterReleaseRetries
+	s.AfterReleaseRetryDelay = time.Duration(cfg.AfterReleaseRetryDelay)
+	s.StreamMaxDuration = time.Duration(cfg.StreamMaxDuration)
+	s.SetReadOnly(cfg.ReadOnly)
+	return s, nil
+}
+
+// timeout returns nil for a zero duration (i.e. no timeout)
+func (d Duration) timeout() *time.Duration {
+	if d == 0 {
+		return nil
+	}
+	return durationPtr(time.Duration(d))
+}
@@ -0,0 +1,135 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Budget is a process wide limit on the total number of open database connections, which can be shared by several Stores (e.g. one Store for each database cluster, see the Store ConnectionBudget).
+// Each database session connected by a Store using the Budget reserves connections from the Budget until the session is closed.
+type Budget struct {
+	limit int
+
+	mu    sync.Mutex
+	inUse int
+
+	// released is closed (and replaced) when reserved connections are returned to the Budget
+	released chan struct{}
+}
+
+// budgetReservation is the number of connections reserved from a Budget by a database session
+type budgetReservation struct {
+	b *Budget
+	n int
+}
+
+// NewBudget returns a Budget which limits the total number of open database connections of the Stores using the Budget to limit.
+// NewBudget panics if limit is less than 1.
+func NewBudget(limit int) *Budget {
+	if limit < 1 {
+		panic(fmt.Sprintf("dblocker: invalid connection budget limit: %d", limit))
+	}
+	return &Budget{limit: limit}
+}
+
+// Limit returns the maximum number of open database connections of the Stores using the Budget
+func (b *Budget) Limit() int {
+	return b.limit
+}
+
+// InUse returns the number of database connections currently reserved by the database sessions of the Stores using the Budget
+func (b *Budget) InUse() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.inUse
+}
+
+// reserve waits until n connections are available and reserves them, and returns an error if ctx is done first.
+// reserve returns a fatal connection error (see ErrFatalConnect) if n is more than the Budget limit, as the connections would never be available.
+func (b *Budget) reserve(ctx context.Context, n int) error {
+	if n > b.limit {
+		return FatalConnectError(fmt.Errorf("connection budget error: %d connections requested, but the budget limit is %d", n, b.limit))
+	}
+	for {
+		b.mu.Lock()
+		if b.inUse+n <= b.limit {
+			b.inUse += n
+			b.mu.Unlock()
+			return nil
+		}
+		if b.released == nil {
+			b.released = make(chan struct{})
+		}
+		released := b.released
+		b.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return fmt.Errorf("connection budget error: %w", ctx.Err())
+		}
+	}
+}
+
+// release returns n reserved connections to the Budget, and wakes the database sessions waiting to reserve connections
+func (b *Budget) release(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inUse -= n
+	if b.released != nil {
+		close(b.released)
+		b.released = nil
+	}
+}
+
+// reserveConnections reserves the connections for a new database session from the Store ConnectionBudget (if set),
+// which is the Store MaxOpenConns setting, or one connection if MaxOpenConns is zero
+func (s *Store) reserveConnections(ctx context.Context) (r budgetReservation, err error) {
+	b := s.ConnectionBudget
+	if b == nil {
+		return r, nil
+	}
+	n := s.settings().maxOpenConns
+	if n <= 0 {
+		n = 1
+	}
+	err = b.reserve(ctx, n)
+	if err != nil {
+		return r, err
+	}
+	return budgetReservation{b: b, n: n}, nil
+}
+
+// release returns the reserved connections to the Budget
+func (r budgetReservation) release() {
+	if r.b != nil {
+		r.b.release(r.n)
+	}
+}
+
+// reservedDB records the connections reserved by a database opened by the Store, which are returned to the Budget when the database is closed (see closedDB)
+func (s *Store) reservedDB(db *sqlx.DB, r budgetReservation) {
+	if r.b == nil {
+		return
+	}
+	s.resources.Lock()
+	defer s.resources.Unlock()
+
+	if s.resources.reservations == nil {
+		s.resources.reservations = make(map[*sqlx.DB]budgetReservation)
+	}
+	s.resources.reservations[db] = r
+}
+
+// reservedConns returns the number of connections reserved by a database opened by the Store, or zero if the database has not reserved connections from a Budget
+func (s *Store) reservedConns(db *sqlx.DB) int {
+	s.resources.Lock()
+	defer s.resources.Unlock()
+
+	return s.resources.reservations[db].n
+}
@@ -0,0 +1,297 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultConnectDBFunc is the default function used to connecct to the database
+// This default function has an unused id variable.  This function could be customised, for example, to send requests to different database shards based on the provided id.
+// DefaultConnectDBFunc connects to the database types added using RegisterDriver, which include "sqlite3", "postgres", and "mysql".
+// The database/sql drivers for these database types are registered by importing the github.com/calmdocs/dblocker/drivers/sqlite, drivers/postgres, and drivers/mysql packages
+// (the "mock" database type is added by importing the drivers/mock package), so that the core package does not depend on any database drivers.
+// The "libsql" driverName connects to libSQL (Turso) servers using a database/sql driver registered as "libsql" (e.g. github.com/tursodatabase/libsql-client-go/libsql).
+// The "sqlcipher" driverName connects using a database/sql driver registered as "sqlcipher" (e.g. github.com/mutecomm/go-sqlcipher), and the Store KeyProvider adds the encryption key for each id to the dataSourceName.
+// The "lockonly" (or "none") driverName does not connect to a database, so that the Store is only a per-id RW lock manager (see ErrLockOnly).
+func DefaultConnectDBFunc(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (db *sqlx.DB, err error) {
+	spec, ok := LookupDriver(driverName)
+	if !ok {
+		return nil, fmt.Errorf("connectDB error: database type not implemented: %s%s", driverName, driverImportHint(driverName))
+	}
+	if statementTimeout != nil && spec.SetStatementTimeout == nil {
+		return nil, fmt.Errorf("connectDB error: statementTimeout for database type not implemented: %s", driverName)
+	}
+
+	db, err = spec.connect(ctx, driverName, dataSourceName)
+	if err != nil {
+		if spec.IsFatalError != nil && spec.IsFatalError(err) {
+			return nil, FatalConnectError(err)
+		}
+		return nil, err
+	}
+	if statementTimeout != nil {
+		err = spec.SetStatementTimeout(ctx, withLabelComment(db, connectionLabelFromContext(ctx)), *statementTimeout)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// connectDB connects to the database using the Store Connector (adding SQLCipher keys from the Store KeyProvider), applies the Store connection pool settings, and wraps the database using the Store WrapDBFunc
+func (s *Store) connectDB(ctx context.Context, r ConnectRequest) (db *sqlx.DB, err error) {
+
+	// Add the encryption key for SQLCipher databases
+	if r.DriverName == "sqlcipher" && s.KeyProvider != nil {
+		key, err := s.KeyProvider.Key(ctx, r.ID)
+		if err != nil {
+			return nil, fmt.Errorf("connectDB error: sqlcipher key error: %w", err)
+		}
+		r.DataSourceName = withSQLCipherKey(r.DataSourceName, key)
+	}
+
+	// Label the database session (see LabelConnections)
+	r.Label = s.connectionLabel(r.ID)
+	if r.Label != "" {
+		r.DataSourceName, err = s.labelDataSourceName(r.ID, r.DriverName, r.DataSourceName)
+		if err != nil {
+			return nil, fmt.Errorf("connectDB error: connection label error: %w", err)
+		}
+	}
+
+	// Session settings are not kept by transaction pooling proxies (see TransactionPooling)
+	err = s.validateTransactionPooling(r.DriverName)
+	if err != nil {
+		return nil, err
+	}
+	if s.TransactionPooling {
+		r.StatementTimeout = nil
+	}
+
+	// Reserve the connections of the database session from the ConnectionBudget shared with other Stores
+	reservation, err := s.reserveConnections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound the connection attempt (see ConnectTimeout)
+	connectCtx := ctx
+	if connectTimeout := s.settings().connectTimeout; connectTimeout > 0 {
+		var connectCancel context.CancelFunc
+		connectCtx, connectCancel = context.WithTimeout(ctx, connectTimeout)
+		defer connectCancel()
+		defer func() {
+			if err != nil && ctx.Err() == nil && errors.Is(connectCtx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("connect attempt timed out after %v: %w", connectTimeout, err)
+			}
+		}()
+	}
+	db, cleanup, err := s.connector(connectCtx, r)
+	if err != nil {
+		reservation.release()
+		return nil, err
+	}
+
+	// Set the lock timeout (and the statement timeout) for each connection of the database session, as the session may use more than one connection (see MaxOpenConns)
+	if s.TransactionPooling {
+		r.LockTimeout = nil
+	}
+	if r.LockTimeout != nil {
+		db, err = withConnTimeouts(connectCtx, db, r)
+		if err != nil {
+			if cleanup != nil {
+				cleanup()
+			}
+			reservation.release()
+			return nil, err
+		}
+	}
+	s.applyPoolSettings(db)
+	if reservation.n > 0 {
+		db.SetMaxOpenConns(reservation.n)
+	}
+	if s.WrapDBFunc != nil {
+		db = s.WrapDBFunc(db)
+	}
+
+	s.openedDB(db)
+	s.reservedDB(db, reservation)
+
+	// Keep the cleanup function until the database is closed (see closeDB)
+	if cleanup != nil {
+		s.Lock()
+		if s.cleanups == nil {
+			s.cleanups = make(map[*sqlx.DB]func())
+		}
+		s.cleanups[db] = cleanup
+		s.Unlock()
+	}
+	return db, nil
+}
+
+// withConnTimeouts replaces a database with a database which connects using the same driver and the DataSourceName of the ConnectRequest,
+// and which sets the StatementTimeout and LockTimeout of the ConnectRequest for each new connection (see timeoutConnector).
+// The database is closed, including if withConnTimeouts returns an error.
+func withConnTimeouts(ctx context.Context, db *sqlx.DB, r ConnectRequest) (*sqlx.DB, error) {
+	defer db.Close()
+
+	spec, _ := LookupDriver(r.DriverName)
+	if spec.SetLockTimeout == nil {
+		return nil, fmt.Errorf("connectDB error: lockTimeout for database type not implemented: %s", r.DriverName)
+	}
+	var connector driver.Connector = dsnConnector{dataSourceName: r.DataSourceName, driver: db.Driver()}
+	if driverContext, ok := db.Driver().(driver.DriverContext); ok {
+		var err error
+		connector, err = driverContext.OpenConnector(r.DataSourceName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.StatementTimeout != nil && spec.SetStatementTimeout == nil {
+		return nil, fmt.Errorf("connectDB error: statementTimeout for database type not implemented: %s", r.DriverName)
+	}
+	timeoutDB := sqlx.NewDb(sql.OpenDB(timeoutConnector{
+		Connector:        connector,
+		spec:             spec,
+		driverName:       r.DriverName,
+		label:            r.Label,
+		statementTimeout: r.StatementTimeout,
+		lockTimeout:      *r.LockTimeout,
+	}), db.DriverName())
+	err := timeoutDB.PingContext(ctx)
+	if err != nil {
+		timeoutDB.Close()
+		return nil, err
+	}
+	return timeoutDB, nil
+}
+
+// timeoutConnector sets the statement timeout (if not nil) and the timeout for waiting for database locks for each new connection
+type timeoutConnector struct {
+	driver.Connector
+	spec             DriverSpec
+	driverName       string
+	label            string
+	statementTimeout *time.Duration
+	lockTimeout      time.Duration
+}
+
+func (c timeoutConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("connectDB error: lockTimeout requires a driver connection which implements driver.ExecerContext: %s", c.driverName)
+	}
+	db := withLabelComment(connExecer{execer}, c.label)
+	if c.statementTimeout != nil {
+		err = c.spec.SetStatementTimeout(ctx, db, *c.statementTimeout)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	err = c.spec.SetLockTimeout(ctx, db, c.lockTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dsnConnector connects using a driver which does not implement driver.DriverContext
+type dsnConnector struct {
+	dataSourceName string
+	driver         driver.Driver
+}
+
+func (c dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dataSourceName)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// connExecer executes statements without arguments (such as the statements which set session timeouts) using a driver connection
+type connExecer struct {
+	execer driver.ExecerContext
+}
+
+func (e connExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("connExecer error: arguments not supported")
+	}
+	return e.execer.ExecContext(ctx, query, nil)
+}
+
+// applyPoolSettings applies the Store connection pool settings to a database, using the database/sql defaults for zero values.
+// Databases which have reserved connections from the Store ConnectionBudget are limited to the reserved connections.
+func (s *Store) applyPoolSettings(db *sqlx.DB) {
+	settings := s.settings()
+	maxIdleConns := settings.maxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = 2
+	}
+	maxOpenConns := settings.maxOpenConns
+	if reserved := s.reservedConns(db); reserved > 0 {
+		maxOpenConns = reserved
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(settings.connMaxLifetime)
+	db.SetConnMaxIdleTime(settings.connMaxIdleTime)
+}
+
+// connectDBAndWait connects to the database, retrying after the reconnectDelay until connected, until ctx is done, or until isFatal returns a fatal connection error
+func connectDBAndWait(
+	ctx context.Context,
+	connectDB func(ctx context.Context, r ConnectRequest) (db *sqlx.DB, err error),
+	r ConnectRequest,
+	reconnectDelay time.Duration,
+	isFatal func(err error) error,
+) (db *sqlx.DB, err error) {
+
+	idleDuration := reconnectDelay
+	if idleDuration <= 0 {
+		idleDuration = 2 * time.Second
+	}
+	idleDelay := time.NewTimer(idleDuration)
+	defer idleDelay.Stop()
+
+	done := false
+	for !done {
+		done = true
+
+		r.Attempt++
+		db, err = connectDB(ctx, r)
+		if err != nil {
+			done = false
+
+			fmt.Println("dbLocker connect error:", err.Error())
+
+			// Do not retry fatal errors
+			if fatalErr := isFatal(err); fatalErr != nil {
+				return nil, fatalErr
+			}
+
+			idleDelay.Reset(idleDuration)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-idleDelay.C:
+			}
+		}
+	}
+	return db, nil
+}
@@ -0,0 +1,143 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ConnectRequest describes an attempt to connect a database session for an id
+type ConnectRequest struct {
+	ID               interface{}
+	DriverName       string
+	DataSourceName   string
+	StatementTimeout *time.Duration
+
+	// LockTimeout is the timeout for waiting for database locks (see the Store LockTimeout), which is set for each connection of the database session after the Connector returns
+	// by replacing the returned database with a database which connects using the same driver and DataSourceName, and which sets the lock timeout for each new connection
+	// (so Connectors do not need to set it, but can use it, e.g. in connection options)
+	LockTimeout *time.Duration
+
+	// Attempt is the connection attempt number, starting at 1 and increasing each time a failed attempt to connect the shared database session for the id is retried
+	Attempt int
+
+	// Label is the label of the database session when the Store LabelConnections setting is true (and is otherwise empty), which has already been added to the DataSourceName application name.
+	// Connectors can use Label to label the database session in other ways (e.g. in connection options).
+	Label string
+
+	// Tag and Metadata are from the database access request that caused the connection
+	Tag      string
+	Metadata Metadata
+
+	// Replica is the name of the read replica for connections to read replicas (see the Store Replicas setting), which are shared by all ids, so ID is nil
+	Replica string
+
+	// Logger logs connector messages in the same way as other Store messages
+	Logger func(a ...interface{})
+}
+
+// Connector connects to the database for a ConnectRequest.
+// cleanup is optional, and is called after the returned database has been closed by the Store (e.g. to close an SSH tunnel or release a credential lease).
+type Connector func(ctx context.Context, r ConnectRequest) (db *sqlx.DB, cleanup func(), err error)
+
+// ConnectDBFuncConnector returns a Connector which connects using a connectDBFunc (such as DefaultConnectDBFunc)
+func ConnectDBFuncConnector(
+	connectDBFunc func(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (db *sqlx.DB, err error),
+) Connector {
+	return func(ctx context.Context, r ConnectRequest) (db *sqlx.DB, cleanup func(), err error) {
+		db, err = connectDBFunc(withConnectionLabel(ctx, r.Label), r.ID, r.DriverName, r.DataSourceName, r.StatementTimeout)
+		return db, nil, err
+	}
+}
+
+// NewWithConnector creates a new dblocker Store
+// (which is started unless ctx is nil, see Start)
+// with a custom Connector;
+// with an unlockTimeout for waiting for access to the database; and
+// with a statemenTimeout for database sessions (returns an error if not nil and the database does not support statement timeouts, see DriverCapabilities).
+func NewWithConnector(
+	ctx context.Context,
+	connector Connector,
+	driverName string,
+	dataSourceName string,
+	unlockTimeout *time.Duration,
+	statementTimeout *time.Duration,
+	debug bool,
+) (s *Store, err error) {
+
+	// Return an error if statementTimeout is not nil and the database does not support statement timeouts
+	if statementTimeout != nil {
+		caps, ok := DriverCapabilities(driverName)
+		if !ok {
+			return nil, fmt.Errorf("connectDB error: database type not implemented: %s", driverName)
+		}
+		if !caps.StatementTimeout {
+			return nil, fmt.Errorf("connectDB error: statementTimeout for database type not implemented: %s", driverName)
+		}
+	}
+
+	s = &Store{
+		m:                make(map[interface{}]*Group),
+		connector:        connector,
+		DriverName:       driverName,
+		DataSourceName:   dataSourceName,
+		UnlockTimeout:    unlockTimeout,
+		StatementTimeout: statementTimeout,
+		debug:            debug,
+	}
+
+	// Start the Store unless ctx is nil (see Start)
+	if ctx != nil {
+		err = s.Start(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// connectLogger logs connector messages
+func connectLogger(a ...interface{}) {
+	fmt.Println(append([]interface{}{"dbLocker connect:"}, a...)...)
+}
+
+// closeDB closes a database connected by the Store and calls the Connector cleanup function for the database
+func (s *Store) closeDB(db *sqlx.DB) {
+	db.Close()
+	s.closedDB(db)
+
+	s.Lock()
+	cleanup := s.cleanups[db]
+	delete(s.cleanups, db)
+	s.Unlock()
+
+	if cleanup != nil {
+		cleanup()
+	}
+}
+
+// ErrFatalConnect is wrapped by connection errors which are not retried (e.g. bad credentials or an unknown database).
+// Requests waiting for the shared database session for an id fail immediately with the connection error.
+var ErrFatalConnect = errors.New("dblocker: fatal connect error")
+
+// FatalConnectError marks a connection error returned by a Connector or connectDBFunc as fatal (see ErrFatalConnect)
+func FatalConnectError(err error) error {
+	if err == nil || errors.Is(err, ErrFatalConnect) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrFatalConnect, err)
+}
+
+// fatalConnectError returns err marked as fatal if it is a fatal connection error (see FatalConnectError and the Store ConnectErrorIsFatal classifier), or nil otherwise
+func (s *Store) fatalConnectError(err error) error {
+	if errors.Is(err, ErrFatalConnect) {
+		return err
+	}
+	if s.ConnectErrorIsFatal != nil && s.ConnectErrorIsFatal(err) {
+		return FatalConnectError(err)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultConnectionLabel is the label of the database sessions connected by the Store when LabelConnections is true and ConnectionLabel is nil
+const DefaultConnectionLabel = "dblocker"
+
+// connectionLabel returns the label for the database sessions of an id, or "" if LabelConnections is false
+func (s *Store) connectionLabel(id interface{}) string {
+	if !s.LabelConnections {
+		return ""
+	}
+	label := DefaultConnectionLabel
+	if s.ConnectionLabel != nil {
+		label = s.ConnectionLabel(id)
+	}
+	return label
+}
+
+// labelDataSourceName adds the connection label for an id to the application name of a data source name using the DriverSpec ApplicationName function
+func (s *Store) labelDataSourceName(id interface{}, driverName, dataSourceName string) (string, error) {
+	label := s.connectionLabel(id)
+	if label == "" {
+		return dataSourceName, nil
+	}
+	spec, _ := LookupDriver(driverName)
+	if spec.ApplicationName == nil {
+		return dataSourceName, nil
+	}
+	return spec.ApplicationName(dataSourceName, label)
+}
+
+// labelExecer labels the statements executed using db (which set session timeouts) with the connection label for an id
+func (s *Store) labelExecer(id interface{}, db sqlx.ExecerContext) sqlx.ExecerContext {
+	return withLabelComment(db, s.connectionLabel(id))
+}
+
+type connectionLabelKey struct{}
+
+// withConnectionLabel returns a copy of ctx with the connection label used by DefaultConnectDBFunc (see ConnectRequest Label)
+func withConnectionLabel(ctx context.Context, label string) context.Context {
+	if label == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, connectionLabelKey{}, label)
+}
+
+// connectionLabelFromContext returns the connection label set using withConnectionLabel
+func connectionLabelFromContext(ctx context.Context) string {
+	label, _ := ctx.Value(connectionLabelKey{}).(string)
+	return label
+}
+
+// labelCommentExecer prefixes a comment to each statement
+type labelCommentExecer struct {
+	sqlx.ExecerContext
+	comment string
+}
+
+func (e labelCommentExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return e.ExecerContext.ExecContext(ctx, e.comment+query, args...)
+}
+
+// withLabelComment returns db, or an execer which prefixes a comment containing the label to each statement if the label is not empty
+func withLabelComment(db sqlx.ExecerContext, label string) sqlx.ExecerContext {
+	if label == "" {
+		return db
+	}
+
+	// Remove comment delimiters, and start the comment with a space so that it is never a mysql executable comment or optimizer hint
+	label = strings.NewReplacer("/*", "", "*/", "").Replace(label)
+	return labelCommentExecer{ExecerContext: db, comment: fmt.Sprintf("/* %s */ ", label)}
+}
+
+// appendLabel adds a label to the end of an application name
+func appendLabel(applicationName, label string) string {
+	if applicationName == "" {
+		return label
+	}
+	return applicationName + " " + label
+}
+
+// postgresApplicationName adds the label to the application_name of a postgres URL or key=value data source name
+func postgresApplicationName(dataSourceName, label string) (string, error) {
+	if strings.HasPrefix(dataSourceName, "postgres://") || strings.HasPrefix(dataSourceName, "postgresql://") {
+		u, err := url.Parse(dataSourceName)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set("application_name", appendLabel(q.Get("application_name"), label))
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	params, err := parsePostgresKeyValues(dataSourceName)
+	if err != nil {
+		return "", err
+	}
+	found := false
+	for i, param := range params {
+		if param[0] == "application_name" {
+			params[i][1] = appendLabel(param[1], label)
+			found = true
+		}
+	}
+	if !found {
+		params = append(params, [2]string{"application_name", label})
+	}
+	fields := make([]string, 0, len(params))
+	for _, param := range params {
+		value := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(param[1])
+		fields = append(fields, fmt.Sprintf("%s='%s'", param[0], value))
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// parsePostgresKeyValues parses a postgres key=value data source name, where values can be single quoted (with backslash escapes)
+func parsePostgresKeyValues(dataSourceName string) (params [][2]string, err error) {
+	s := strings.TrimSpace(dataSourceName)
+	for s != "" {
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid data source name: missing \"=\" after %q", s)
+		}
+		key := strings.TrimSpace(s[:i])
+		s = strings.TrimLeft(s[i+1:], " \t\n")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, "'") {
+			s = s[1:]
+			closed := false
+			for len(s) > 0 && !closed {
+				switch {
+				case s[0] == '\\' && len(s) > 1:
+					value.WriteByte(s[1])
+					s = s[2:]
+				case s[0] == '\'':
+					closed = true
+					s = s[1:]
+				default:
+					value.WriteByte(s[0])
+					s = s[1:]
+				}
+			}
+			if !closed {
+				return nil, fmt.Errorf("invalid data source name: unterminated quoted value for %s", key)
+			}
+		} else {
+			end := strings.IndexAny(s, " \t\n")
+			if end < 0 {
+				end = len(s)
+			}
+			value.WriteString(s[:end])
+			s = s[end:]
+		}
+		params = append(params, [2]string{key, value.String()})
+		s = strings.TrimLeft(s, " \t\n")
+	}
+	return params, nil
+}
+
+// mysqlApplicationName adds the label to the program_name connection attribute of a go-sql-driver/mysql data source name
+func mysqlApplicationName(dataSourceName, label string) (string, error) {
+	label = strings.NewReplacer(",", "_", ":", "_").Replace(label)
+
+	base, query := dataSourceName, ""
+	if slash := strings.LastIndex(dataSourceName, "/"); slash >= 0 {
+		if i := strings.IndexByte(dataSourceName[slash:], '?'); i >= 0 {
+			base, query = dataSourceName[:slash+i], dataSourceName[slash+i+1:]
+		}
+	}
+
+	var params []string
+	found := false
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(param, "=")
+		if key == "connectionAttributes" {
+			attributes, err := url.QueryUnescape(value)
+			if err != nil {
+				return "", fmt.Errorf("invalid connectionAttributes value: %w", err)
+			}
+			attributes = mysqlProgramName(attributes, label)
+			param = key + "=" + url.QueryEscape(attributes)
+			found = true
+		}
+		params = append(params, param)
+	}
+	if !found {
+		params = append(params, "connectionAttributes="+url.QueryEscape("program_name:"+label))
+	}
+	return base + "?" + strings.Join(params, "&"), nil
+}
+
+// mysqlProgramName adds the label to the program_name in comma separated key:value connection attributes
+func mysqlProgramName(attributes, label string) string {
+	var fields []string
+	found := false
+	for _, field := range strings.Split(attributes, ",") {
+		if field == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(field, ":")
+		if key == "program_name" {
+			field = key + ":" + appendLabel(value, label)
+			found = true
+		}
+		fields = append(fields, field)
+	}
+	if !found {
+		fields = append(fields, "program_name:"+label)
+	}
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,113 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// connectionLostPingTimeout limits the Ping used by the Store PingOnError setting
+const connectionLostPingTimeout = 5 * time.Second
+
+// ErrConnectionLost is the cause of the Hold context (and of the error returned by Hold.Err) when a Hold is force released because the shared database session for the id lost its connection to the database (see ObserveError).
+// Work done using the Hold may not have been completed, so should be retried using a new Hold.
+var ErrConnectionLost = errors.New("dblocker: database connection lost")
+
+// ObserveError reports an error returned by a query made using the Hold (see Store.ObserveError).
+// If the error means that the connection to the database was lost, the Hold is also force released with an error wrapping ErrConnectionLost.
+func (h *Hold) ObserveError(err error) {
+	h.s.observeError(h.id, h, err)
+}
+
+// ObserveError reports an error returned by a query for the specified id, and recovers the shared database session for the id if the error means that the connection to the database was lost
+// (driver.ErrBadConn, sql.ErrConnDone, or an error for which the DriverSpec IsConnectionLost function returns true).
+// If the Store PingOnError setting is true, the shared database session is also pinged after other errors, and a failed Ping means that the connection was lost.
+// When the connection is lost, the loss is reported to the log and to the OnConnectionLost hook, the holds using the shared database session for the id are force released
+// with an error wrapping ErrConnectionLost (see OutcomeConnectionLost) so that each holder does not discover the lost connection separately,
+// and the shared database session for the id is then reconnected (see Reconnect). Errors observed while the id is being recovered are ignored.
+// Requests granted before the shared database session is reconnected use the existing session (in which database/sql replaces connections that return driver.ErrBadConn).
+// ObserveError can be called, for example, from instrumentation added using WrapDBFunc.
+func (s *Store) ObserveError(id interface{}, err error) {
+	s.observeError(s.lockKey(id), nil, err)
+}
+
+// observeError recovers the shared database session for an id after an error which means that the connection was lost.
+// h is the Hold that observed the error (nil if none), which is force released even if it does not use the shared database session.
+func (s *Store) observeError(id interface{}, h *Hold, err error) {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, sql.ErrNoRows) || errors.Is(err, sql.ErrTxDone) {
+		return
+	}
+	lost := isConnectionLost(s.settings().driverName, err)
+	if !lost && !s.PingOnError {
+		return
+	}
+
+	// Recover the shared database session for each id only once at a time
+	storeCtx := s.storeCtx()
+	s.Lock()
+	g, ok := s.m[id]
+	if !ok || g.DB == nil || g.connectionLost {
+		s.Unlock()
+		return
+	}
+	g.connectionLost = true
+	db := g.DB
+	dataSourceName := s.dataSourceNames[id]
+	s.Unlock()
+
+	s.spawn("connection lost", func() {
+		defer func() {
+			s.Lock()
+			g.connectionLost = false
+			s.Unlock()
+		}()
+
+		// Check that the connection was lost using a Ping (database/sql retries the Ping using a new connection if the old connection is bad)
+		if !lost {
+			ctx, cancel := context.WithTimeout(storeCtx, connectionLostPingTimeout)
+			pingErr := db.PingContext(ctx)
+			cancel()
+			if pingErr == nil || storeCtx.Err() != nil {
+				return
+			}
+			err = fmt.Errorf("%w (ping error: %w)", err, pingErr)
+		}
+
+		fmt.Printf("dbLocker connection lost error: id %v: %s\n", id, err.Error())
+		if s.Hooks.OnConnectionLost != nil {
+			s.Hooks.OnConnectionLost(id, err)
+		}
+
+		// Force release the holds using the shared database session, and then reconnect once the holds are released
+		cause := fmt.Errorf("%w: %w", ErrConnectionLost, err)
+		var holds []*Hold
+		s.queues.Lock()
+		if q, ok := s.queues.m[id]; ok {
+			for other := range q.holds {
+				if other.accessType != "rwseparate" || other == h {
+					holds = append(holds, other)
+				}
+			}
+		}
+		s.queues.Unlock()
+		for _, other := range holds {
+			other.forceRelease(cause)
+		}
+		reconnectErr := s.Reconnect(storeCtx, id, dataSourceName)
+		if reconnectErr != nil && storeCtx.Err() == nil {
+			fmt.Println("dbLocker connection lost reconnect error:", reconnectErr.Error())
+		}
+	})
+}
+
+// isConnectionLost returns true for errors which mean that the connection to the database was lost
+func isConnectionLost(driverName string, err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+	spec, _ := LookupDriver(driverName)
+	return spec.IsConnectionLost != nil && spec.IsConnectionLost(err)
+}
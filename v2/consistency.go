@@ -0,0 +1,67 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ConsistencyToken is the write position of the primary database after a RW hold (e.g. a postgres LSN or a mysql GTID set, see ReleaseWithToken).
+// Read holds which present a ConsistencyToken (see WithConsistencyToken) are only routed to read replicas which have replayed the write position,
+// and otherwise use the shared database session for the id (the primary), so that they read their writes (see the Store Replicas setting).
+// ConsistencyTokens are strings so that they can be passed between requests (e.g. in a cookie or a response header).
+type ConsistencyToken string
+
+type consistencyTokenKey struct{}
+
+// WithConsistencyToken returns a copy of ctx with a ConsistencyToken, which read holds requested using ctx present to read the writes made before the token was returned
+func WithConsistencyToken(ctx context.Context, token ConsistencyToken) context.Context {
+	return context.WithValue(ctx, consistencyTokenKey{}, token)
+}
+
+// ConsistencyTokenFromContext returns the ConsistencyToken added to ctx using WithConsistencyToken, and false if ctx does not have a ConsistencyToken
+func ConsistencyTokenFromContext(ctx context.Context) (token ConsistencyToken, ok bool) {
+	token, ok = ctx.Value(consistencyTokenKey{}).(ConsistencyToken)
+	return token, ok
+}
+
+// ReleaseWithToken releases a RW hold, and returns the write position of the primary database once the writes made using the hold are complete,
+// which read holds can present to read those writes from read replicas (see ConsistencyToken).
+// Commit the transactions of the hold before calling ReleaseWithToken. The hold is released even if ReleaseWithToken returns an error,
+// which it does for read and stream holds, and for databases which do not support read-your-writes consistency (see Capabilities ReadYourWrites).
+func (h *Hold) ReleaseWithToken(ctx context.Context) (token ConsistencyToken, err error) {
+	defer h.Release()
+
+	if AccessMode(h.accessType).isRead() {
+		return "", fmt.Errorf("consistency token error: %s hold does not write to the database: %s", h.accessType, h.tag)
+	}
+	if h.ctx.Err() != nil {
+		return "", fmt.Errorf("consistency token error: hold already released: %s", h.tag)
+	}
+	driverName := h.s.settings().driverName
+	spec, _ := LookupDriver(driverName)
+	if spec.WritePosition == nil {
+		return "", fmt.Errorf("consistency token error: write position for database type not implemented: %s", driverName)
+	}
+	position, err := spec.WritePosition(ctx, h.db)
+	if err != nil {
+		return "", fmt.Errorf("consistency token error: %w", err)
+	}
+	return ConsistencyToken(position), nil
+}
+
+// replayed returns true if a read replica has replayed the write position of a ConsistencyToken
+func (s *Store) replayed(ctx context.Context, replica string, db *sqlx.DB, token ConsistencyToken) bool {
+	driverName := s.settings().driverName
+	spec, _ := LookupDriver(driverName)
+	if spec.ReplayedPosition == nil {
+		return false
+	}
+	replayed, err := spec.ReplayedPosition(ctx, db, string(token))
+	if err != nil {
+		fmt.Printf("dbLocker replica %s consistency token error: %s\n", replica, err.Error())
+		return false
+	}
+	return replayed
+}
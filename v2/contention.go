@@ -0,0 +1,136 @@
+package dblocker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// contentionSampleCount is the maximum number of recent requests kept for TopContended
+const contentionSampleCount = 16384
+
+// Contention describes the requests for an id within the Store ContentionWindow
+type Contention struct {
+	ID interface{}
+
+	// TotalWait is the total time spent waiting for access to the database for the id, and Requests is the number of requests for the id
+	TotalWait time.Duration
+	Requests  int
+
+	// PeakWaiting is the largest number of requests waiting for the id at the same time
+	PeakWaiting int
+
+	// TagWaits are the total wait times for each tag used with the id, ordered by wait time (most first)
+	TagWaits []TagWait
+}
+
+// TagWait is the total wait time for requests with a tag
+type TagWait struct {
+	Tag       string
+	TotalWait time.Duration
+	Requests  int
+}
+
+type contentionSample struct {
+	at      time.Time
+	id      interface{}
+	tag     string
+	wait    time.Duration
+	waiting int
+}
+
+type contention struct {
+	sync.Mutex
+
+	buf  []contentionSample
+	next int
+}
+
+// TopContended returns the n ids with the most total wait time within the Store ContentionWindow, ordered by total wait time (most first).
+// TopContended can be used, for example, to identify hot tenants that should be moved to their own shard.
+// TopContended returns no ids unless the Store ContentionWindow is set.
+func (s *Store) TopContended(n int) []Contention {
+	window := s.ContentionWindow
+	if window <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-window)
+
+	s.contention.Lock()
+	byID := make(map[interface{}]*Contention)
+	tagWaits := make(map[interface{}]map[string]*TagWait)
+	for _, sample := range s.contention.buf {
+		if !sample.at.After(cutoff) {
+			continue
+		}
+		c, ok := byID[sample.id]
+		if !ok {
+			c = &Contention{ID: sample.id}
+			byID[sample.id] = c
+			tagWaits[sample.id] = make(map[string]*TagWait)
+		}
+		c.TotalWait += sample.wait
+		c.Requests++
+		if sample.waiting > c.PeakWaiting {
+			c.PeakWaiting = sample.waiting
+		}
+		tw, ok := tagWaits[sample.id][sample.tag]
+		if !ok {
+			tw = &TagWait{Tag: sample.tag}
+			tagWaits[sample.id][sample.tag] = tw
+		}
+		tw.TotalWait += sample.wait
+		tw.Requests++
+	}
+	s.contention.Unlock()
+
+	top := make([]Contention, 0, len(byID))
+	for id, c := range byID {
+		for _, tw := range tagWaits[id] {
+			c.TagWaits = append(c.TagWaits, *tw)
+		}
+		sort.Slice(c.TagWaits, func(i, j int) bool {
+			if c.TagWaits[i].TotalWait != c.TagWaits[j].TotalWait {
+				return c.TagWaits[i].TotalWait > c.TagWaits[j].TotalWait
+			}
+			return c.TagWaits[i].Tag < c.TagWaits[j].Tag
+		})
+		top = append(top, *c)
+	}
+	sort.SliceStable(top, func(i, j int) bool { return top[i].TotalWait > top[j].TotalWait })
+	if n >= 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// recordContention records the wait time of a request for TopContended if the Store ContentionWindow is set
+func (s *Store) recordContention(id interface{}, tag string, wait time.Duration) {
+	if s.ContentionWindow <= 0 {
+		return
+	}
+
+	s.queues.Lock()
+	waiting := 0
+	if q, ok := s.queues.m[id]; ok {
+		waiting = len(q.waiters)
+	}
+	s.queues.Unlock()
+
+	s.contention.Lock()
+	defer s.contention.Unlock()
+
+	sample := contentionSample{
+		at:      time.Now(),
+		id:      id,
+		tag:     tag,
+		wait:    wait,
+		waiting: waiting,
+	}
+	if len(s.contention.buf) < contentionSampleCount {
+		s.contention.buf = append(s.contention.buf, sample)
+		return
+	}
+	s.contention.buf[s.contention.next] = sample
+	s.contention.next = (s.contention.next + 1) % contentionSampleCount
+}
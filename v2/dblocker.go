@@ -0,0 +1,1025 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Store is the dblocker store
+type Store struct {
+	sync.Mutex
+
+	// Ctx is the context for the current run of the Store, which is set by Start (see Start and Stop).
+	// Do not set Ctx directly.
+	//
+	// Deprecated: Ctx is replaced by Start while the Store may be in use, so use Context instead.
+	Ctx   context.Context
+	run   *storeRun
+	ctxMu sync.RWMutex
+
+	// settingsMu guards the settings that can be changed by Reload while the Store is running (see settings)
+	settingsMu sync.RWMutex
+
+	m         map[interface{}]*Group
+	connector Connector
+
+	// cleanups are the Connector cleanup functions for open databases
+	cleanups map[*sqlx.DB]func()
+
+	// DriverName, DataSourceName, UnlockTimeout, and StatementTimeout are the database type, the default data source name, the time after which holds are released (nil means no timeout),
+	// and the statement timeout for database sessions (nil means no timeout), which are set by the New functions.
+	//
+	// Deprecated: these fields can be changed by Reload while the Store is running, so reading or setting them directly is a data race.
+	// Use CurrentDriverName, CurrentDataSourceName, CurrentUnlockTimeout, and CurrentStatementTimeout to read them,
+	// and SetDriverName, SetDataSourceName, SetUnlockTimeout, and SetStatementTimeout (or Reload) to change them.
+	DriverName       string
+	DataSourceName   string
+	UnlockTimeout    *time.Duration
+	StatementTimeout *time.Duration
+	debug            bool
+
+	// Hooks are optional functions called during the lifecycle of database access requests.
+	// Set Hooks before making any database access requests.
+	Hooks Hooks
+
+	// ListenChannel optionally returns the postgres LISTEN channel name for an id.
+	// If set (and the Store uses the postgres driver), a LISTEN connection is maintained alongside the shared database session for each id,
+	// and notifications are sent to channels returned by the Notifications function.
+	// Set ListenChannel before making any database access requests.
+	ListenChannel func(id interface{}) string
+	subscribers   map[interface{}]map[*subscriber]struct{}
+
+	// AfterReleaseRetries is the number of times that a failing Hold.AfterRelease callback is retried (default 0).
+	// AfterReleaseRetryDelay is the delay before the first retry, which doubles after each retry (default 1 second).
+	AfterReleaseRetries    int
+	AfterReleaseRetryDelay time.Duration
+	outboxes               map[interface{}]*outbox
+
+	// Cache is an optional cache for the results of Hold.CachedSelect and Hold.CachedGet queries for read holds, with values expiring after CacheTTL (zero means no expiry).
+	// Cached values for an id are invalidated whenever a RW hold for that id is granted and released.
+	Cache    Cache
+	CacheTTL time.Duration
+
+	// TeardownPolicy controls when the shared database session for an id is closed once there are no requests for the id (default TeardownImmediate).
+	// TeardownLinger is the linger duration for the TeardownLinger policy.
+	TeardownPolicy TeardownPolicy
+	TeardownLinger time.Duration
+
+	// TransactionPooling is true if the database is accessed through a transaction pooling proxy (e.g. PgBouncer with pool_mode = transaction),
+	// where each transaction (or statement outside a transaction) may use a different database server connection, so session state is not kept between transactions.
+	// When TransactionPooling is true:
+	// the StatementTimeout and LockTimeout are not set for database sessions, and are instead set for each transaction begun using Hold.BeginTxx (e.g. using SET LOCAL);
+	// Hold.Conn does not set session statement timeouts (and returns ErrTransactionPooling if a statementTimeout is passed) and does not cancel running statements;
+	// and requests fail with a fatal connection error wrapping ErrTransactionPooling if LISTEN notifications (ListenChannel) or the SessionResetDiscard policy are used,
+	// or if the database does not support transaction timeouts (see Capabilities) and a StatementTimeout or LockTimeout is set.
+	// Set TransactionPooling before making any database access requests.
+	TransactionPooling bool
+
+	// LockTimeout optionally sets the timeout for waiting for database locks (e.g. lock_timeout for postgres, innodb_lock_wait_timeout for mysql, or busy_timeout for sqlite)
+	// for each database session when it is connected, in the same way as the StatementTimeout (nil uses the database default, see Capabilities).
+	// Changes to the LockTimeout (e.g. using Reload) apply to database sessions connected after the change.
+	LockTimeout *time.Duration
+
+	// Ping controls when the shared database session for an id is checked using Ping (default PingOnConnect)
+	Ping PingStrategy
+
+	// InheritDeadline optionally tightens the statement timeout of a request to the time remaining until its context deadline when the deadline is sooner than the statement timeout,
+	// so that the database stops work as soon as the caller gives up.
+	// The tightened statement timeout is used for RWGetDBWithTimeout sessions and for Hold.Conn connections (where it is restored when the Hold is released).
+	// The statement timeout of the shared database session for an id is not changed, as the session is shared by other requests.
+	InheritDeadline bool
+
+	// Keyer optionally returns the key for an id, so that ids which can not be used as map keys (e.g. structs containing slices) can be used as Store ids.
+	// When Keyer is set, ids are replaced by their Key for all requests, so Holds, hooks, stats, and the Connector use the Key in place of the id.
+	// Ids with the same key share the same lock and shared database session.
+	// Set Keyer before making any database access requests.
+	Keyer func(id interface{}) string
+
+	// IDCodec optionally serializes ids for backends shared between processes, such as the pgsignal NOTIFY payloads and advisory lock keys (default DefaultIDCodec, see EncodeID).
+	// Every process sharing a backend must use the same IDCodec.
+	IDCodec IDCodec
+
+	// Strict optionally validates scheduler invariants at runtime (e.g. that a hold is never granted while another writer is active, that request counts are never negative, and that channels are never closed twice).
+	// Violations are reported to the OnInvariantViolation hook as errors wrapping ErrInvariantViolation, and requests granted in violation of an invariant fail with the error.
+	// Strict is intended for use in tests to catch scheduler regressions early.
+	Strict bool
+
+	// PanicOnMisuse optionally panics with a clear message (an error wrapping ErrMisuse) when the Store is used incorrectly,
+	// i.e. when Release() is called more than once for a Hold, when Hold.DB, Hold.Conn, or Hold.BeginTxx is called after Release(), or when a request is made with a nil context.
+	// Otherwise Release() can be called more than once, and requests with a nil context return an error wrapping ErrMisuse.
+	// PanicOnMisuse is intended for use in development to catch bugs early.
+	PanicOnMisuse bool
+
+	// SessionVariables optionally returns session variables (e.g. {"app.tenant_id": "42"}, see IDVariable) for the id and the Metadata of each Hold,
+	// which are set for the transactions begun using the Hold (Hold.BeginTxx, RWGetTx, and BatchWrite) only (e.g. using set_config with is_local true on the connection of the transaction),
+	// so that row-level security policies automatically scope the queries of each transaction to the locked id. The shared database session itself is never changed.
+	// Transactions fail to begin if SessionVariables returns an error, or if the database does not support transaction variables (see Capabilities LocalVariables).
+	SessionVariables func(ctx context.Context, id interface{}, metadata Metadata) (map[string]string, error)
+
+	// DefaultTag is optionally the tag used for requests made with an empty tag (and without Metadata, see WithMetadata).
+	// RequireTags optionally rejects requests with an empty tag (after DefaultTag is applied, and after the OnAcquireRequested hook, which can set the tag) with an error wrapping ErrTagRequired,
+	// so that every request can be identified in events, metrics, and logs.
+	DefaultTag  string
+	RequireTags bool
+
+	// DeadlinePolicy controls how the unlockTimeout and the request context deadline are combined to release each Hold (default DeadlineEarliest, see DeadlinePolicy).
+	DeadlinePolicy DeadlinePolicy
+
+	// Each Hold is released immediately when the request context (or the context of the current owner, see Hold.Transfer) is done,
+	// so a Hold requested using the context of an HTTP request is released at the end of the request even if Release() is never called.
+	// RequireRequestEnd optionally makes this guarantee explicit by rejecting (with an error wrapping ErrMisuse, see PanicOnMisuse)
+	// requests made with a context which is never done (e.g. context.Background()) when no unlockTimeout applies to the Hold,
+	// as such a Hold is only released by Release() or when the Store context is cancelled.
+	RequireRequestEnd bool
+
+	// MaxHoldDuration is optionally an absolute cap on the time that any Hold is held after it is granted, independent of the unlockTimeout, the DeadlinePolicy, and the request context
+	// (zero means no cap). Holds held for longer are force released with an error wrapping ErrMaxHoldDuration, which is always reported to the log, to the OnMaxHoldDuration hook,
+	// and to the MetricsSink (see OutcomeMaxHoldDuration), as the last line of defense against leaked writers.
+	// MaxHoldDurationFor optionally returns the cap for an id, where zero uses MaxHoldDuration (e.g. to allow a longer cap for ids used by batch jobs).
+	// Set RecycleOnMaxHold to also reconnect the shared database session for the id when a Hold is force released, so that the leaked holder can no longer use the session.
+	MaxHoldDuration    time.Duration
+	MaxHoldDurationFor func(id interface{}) time.Duration
+	RecycleOnMaxHold   bool
+
+	// PingOnError optionally pings the shared database session for an id after errors reported using ObserveError which do not by themselves mean that the connection to the database was lost,
+	// and recovers the shared database session for the id if the Ping fails (see ObserveError).
+	PingOnError bool
+
+	// BlockerThreshold optionally describes the holds blocking requests that wait for at least BlockerThreshold and then time out or are cancelled,
+	// by returning a BlockedError (which wraps the context error) instead of the context error, so that timeouts say what they were waiting for.
+	// In debug mode, the stack of the goroutine that requested each Hold is also captured when the Hold is granted (see Blocker).
+	BlockerThreshold time.Duration
+
+	// Replicas optionally routes read holds (ReadHold and ReadGetDB, but not StreamHold or ReadPassthrough) to read replicas of the database in turn, in place of the shared database session for the id.
+	// Read holds still take the read lock for the id (so they wait for RW holds for the id), but can only see the writes which the replica has replayed.
+	// ReplicaMaxLag optionally evicts replicas whose replication lag exceeds ReplicaMaxLag from read routing (see DriverSpec ReplicaLag), where zero means that replicas are not evicted for lag,
+	// and ReplicaLagInterval is how often each replica is checked (default 1 second). Replicas which cannot be connected are also evicted, and read holds use the shared database session for the id
+	// when every replica is evicted (see Hooks OnReplicasEvicted, ReplicaStatuses, and ReplicaMetricsSink).
+	// Read holds which present a ConsistencyToken are only routed to replicas which have replayed the writes made before the token was returned (see WithConsistencyToken).
+	// Set Replicas before making any database access requests.
+	Replicas           []Replica
+	ReplicaMaxLag      time.Duration
+	ReplicaLagInterval time.Duration
+
+	// ProfileLabels optionally sets pprof labels for each Hold (dblocker_id, dblocker_tag, and dblocker_mode) on the goroutine which requested the Hold when the Hold is granted,
+	// and on the goroutine which calls Hold.Transfer, so that CPU and goroutine profiles attribute the work done under a Hold to its current owner (see runtime/pprof SetGoroutineLabels).
+	// The labels are also added to the Hold context (see Hold.Context), and remain set on the goroutine until the goroutine sets its labels again (e.g. using pprof.Do).
+	ProfileLabels bool
+
+	// Scheduler selects how the requests for each id are granted access (default SchedulerChannels, see SchedulerPolicy).
+	// Evict and Reconnect wait for the requests granted by SchedulerCond, SchedulerSemaphore, and SchedulerPriority group locks to be released in the same way as for SchedulerChannels.
+	// Set Scheduler before making any database access requests.
+	Scheduler SchedulerPolicy
+
+	// PriorityAging is the wait after which a waiting request gains one priority level for the SchedulerPriority policy (default 1 second, and negative disables aging).
+	// Set PriorityAging before making any database access requests.
+	PriorityAging time.Duration
+
+	// QueryBudget is the number of queries that each Hold is expected to make (zero means no budget), which can be used to find requests making N+1 queries while holding access to an id.
+	// In debug mode, queries are counted for each Hold using Hold.ObserveQuery (e.g. from instrumentation added using WrapDBFunc),
+	// and a warning is logged when a Hold makes more than QueryBudget queries.
+	// If QueryBudgetRelease is true, the Hold is also released (and Hold.Err returns an error wrapping ErrQueryBudget).
+	QueryBudget        int
+	QueryBudgetRelease bool
+
+	// SessionReset controls how session state is reset on the shared database session for an id before each RW hold is granted (default SessionResetNone).
+	// Use SessionReset to prevent session state (e.g. SET variables, temporary tables, and advisory locks) leaking between requests from different tenants.
+	SessionReset SessionResetPolicy
+
+	// dataSourceNames are the data source names for ids set using Reconnect
+	dataSourceNames map[interface{}]string
+
+	// Sampler optionally samples the completed requests recorded in the recent events and passed to the OnReleased hook (see Sampler), for stores with heavy traffic.
+	// Set Sampler before making any database access requests.
+	Sampler *Sampler
+
+	// RecentEventsSize is the number of completed requests kept for RecentEvents in debug mode (default 256)
+	RecentEventsSize int
+	events           events
+
+	// eventSubscribers receive the Store lifecycle events (see Subscribe)
+	eventSubscribers eventSubscribers
+
+	// janitor is the state of the group janitor (see CheckGroups)
+	janitor janitor
+
+	// WaitSLOs optionally sets target 99th percentile wait times for requests with specific tags, measured over the WaitSLOWindow (default 1 minute).
+	// When the target for any tag is exceeded, new requests with tags for which ShedTag returns true (i.e. lower priority requests) fail immediately with ErrShed.
+	// Set WaitSLOs and ShedTag before making any database access requests.
+	WaitSLOs      map[string]time.Duration
+	WaitSLOWindow time.Duration
+	ShedTag       func(tag string) bool
+	slo           slo
+
+	// ContentionWindow optionally enables recording the wait time of each request for TopContended, and is the window used by TopContended
+	// (zero disables recording, as recording adds a small cost to each request).
+	ContentionWindow time.Duration
+	contention       contention
+
+	// RecordWaitDistributions optionally enables recording the wait time distributions for each tag and access mode for WaitDistributions
+	// (disabled by default, as recording adds a small cost to each request).
+	RecordWaitDistributions bool
+	waitDistributions       waitDistributions
+
+	// AdaptiveStatementTimeout optionally adjusts the statement timeout for each id based on observed query durations (see ObserveQuery).
+	// The Store StatementTimeout is used until enough query durations have been observed for an id.
+	AdaptiveStatementTimeout *AdaptiveStatementTimeout
+	adaptive                 adaptive
+
+	// TagStatementTimeouts optionally maps request tags (or tag patterns using path.Match syntax, e.g. "report-*") to statement timeouts (e.g. 15 minutes for "report-*" and 5 seconds for "api-*"),
+	// which are applied automatically when a Hold is granted, so that call sites do not need to choose between RWGetDB and RWGetDBWithTimeout.
+	// An exact tag is used before patterns, and the longest matching pattern is used if more than one pattern matches.
+	// TagStatementTimeouts are ignored if the database does not support statement timeouts (see Capabilities).
+	// RW requests with a matching tag are granted a new database session with the statement timeout (as for RWGetDBWithTimeout) if TransactionPooling is false,
+	// and RWGetDBWithTimeout requests made with a nil statementTimeout use the statement timeout.
+	// The shared database session is not changed for read and stream requests with a matching tag, and the statement timeout is instead used by Hold.Conn (with a nil statementTimeout) and by Hold.BeginTxx when TransactionPooling is true.
+	// Set TagStatementTimeouts before making any database access requests.
+	TagStatementTimeouts map[string]time.Duration
+
+	// StreamMaxDuration is the maximum duration of StreamHold holds, which are exempt from the UnlockTimeout (zero means no maximum)
+	StreamMaxDuration time.Duration
+
+	// ReadPassthrough optionally returns true for ids (or tags) where readers never need to be excluded by writers.
+	// ReadGetDB and ReadGetDBx requests for these ids and tags are authorized (see Authorizer) and then bypass locking entirely,
+	// and return the shared database session for the id without waiting for writers.
+	// The shared database session is kept open until the returned cancel() function is called (unless Evict or Reconnect is called for the id, or the Store context is cancelled).
+	// Set ReadPassthrough before making any database access requests.
+	ReadPassthrough func(id interface{}, tag string) bool
+	passthroughs    int
+
+	// BatchMaxSize is the maximum number of BatchWrite writes executed in a single batch (default 100)
+	BatchMaxSize int
+	batchers     batchers
+
+	// resources are the goroutines and databases counted by Resources
+	resources resources
+
+	// serializers are the workers for ids with jobs submitted using Serialize
+	serializers serializers
+
+	// ShedByDeadline optionally fails requests immediately with ErrShedDeadline if the wait time estimated by EstimateWait is longer than the time until the request context deadline.
+	ShedByDeadline bool
+
+	// WriteRateLimit optionally limits the rate of RW requests for each id.
+	// RW requests that exceed the limit wait (within the unlockTimeout) unless the limit is FailFast.
+	WriteRateLimit *RateLimit
+	rateLimiter    rateLimiter
+
+	// MetricsSink optionally receives wait time, hold time, and request count metrics.
+	// MetricsSink functions must not call Store functions.
+	// Set MetricsSink before making any database access requests.
+	MetricsSink MetricsSink
+
+	// LabelMapper optionally maps each id to the label used in place of the id for the MetricsSink (e.g. using ShardLabel, or a tenant tier),
+	// so that stores with many ids do not create a time series for each id.
+	// When LabelMapper is set, SetWaiting is called with the label and the total number of waiting requests for the ids with the label.
+	// Set LabelMapper before making any database access requests.
+	LabelMapper func(id interface{}) string
+	labels      metricLabels
+
+	// Authorizer is optionally called before each request is queued, and the request fails with ErrUnauthorized (wrapping the returned error) if it returns an error.
+	// Authorizer can be used, for example, to check that a request context authenticated for one tenant can not access the id of another tenant.
+	Authorizer func(ctx context.Context, id interface{}, mode AccessMode, tag string) error
+
+	// KeyProvider optionally provides the encryption key for each id when using the "sqlcipher" driverName.
+	KeyProvider KeyProvider
+
+	// queues are the requests waiting for access to the database for each id
+	queues queues
+
+	// adopted are the databases for ids set using AdoptDB
+	adopted map[interface{}]*sqlx.DB
+
+	// handover is set when the Store is handed over to a successor Store (see Handover)
+	handover atomic.Pointer[handover]
+
+	// WrapDBFunc optionally wraps every database session connected by the Store (both shared and RWGetDBWithTimeout sessions),
+	// for example to add instrumentation such as otelsql or sqlhooks.
+	// Wrappers that work at the driver level can instead be used in a custom connectDBFunc.
+	// Set WrapDBFunc before making any database access requests.
+	WrapDBFunc func(db *sqlx.DB) *sqlx.DB
+
+	// MaxOpenConns, MaxIdleConns, ConnMaxLifetime, and ConnMaxIdleTime are applied to every database session connected by the Store if not zero (see database/sql).
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// ConnectionBudget optionally limits the total number of open database connections of the Store and of the other Stores using the same Budget (e.g. one Store for each database cluster) to one process wide limit.
+	// Each database session connected by the Store reserves MaxOpenConns connections (or one connection if MaxOpenConns is zero) from the Budget until the session is closed,
+	// and is limited to the reserved connections. Connecting a database session waits until enough connections have been returned to the Budget by other database sessions.
+	// Set ConnectionBudget before making any database access requests.
+	ConnectionBudget *Budget
+
+	// LabelConnections labels the database sessions connected by the Store, so that DBAs can distinguish the sessions managed by the Store from other sessions when auditing server activity
+	// (e.g. using pg_stat_activity or performance_schema). The label is added to the end of the application name in the data source name (the postgres application_name or the mysql program_name
+	// connection attribute, see the DriverSpec ApplicationName function), and is added as a comment to the statements which set session timeouts.
+	// ConnectionLabel optionally returns the label for the database sessions of an id (default DefaultConnectionLabel).
+	LabelConnections bool
+	ConnectionLabel  func(id interface{}) string
+
+	// ProbeTimeout optionally checks that the database server is reachable (using a TCP dial bounded by ProbeTimeout) before connecting the shared database session for an id without one,
+	// so that requests fail quickly with an error wrapping ErrUnreachable when the database is down, rather than waiting for the connection attempts to time out.
+	// Only database types with a DriverSpec Address function (including postgres and mysql) are probed. Zero (the default) disables the probe.
+	ProbeTimeout time.Duration
+
+	// HotIDs optionally saves the HotIDCount (default DefaultHotIDCount) most recently active ids when the Store is stopped (see SaveHotIDs),
+	// so that the next Store can connect the shared database sessions for those ids before requests arrive (see WarmHotIDs and HotIDFile).
+	// The ids granted access are only recorded when HotIDs is set. Set HotIDs before making any database access requests.
+	HotIDs     HotIDStore
+	HotIDCount int
+	hotIDs     hotIDs
+
+	// readOnly is true while the Store is read-only (see SetReadOnly)
+	readOnly atomic.Bool
+
+	// MaintenanceConn optionally connects a second database session (limited to a single connection) for each id with a shared database session,
+	// which is reserved for health checks, stats queries, and cancellation commands (see MaintenanceDB), so that these never wait for the holds using the shared database session.
+	// The maintenance session is connected in the background after the shared database session, and is closed with the shared database session.
+	// Databases set using AdoptDB do not have a maintenance session.
+	// Set MaintenanceConn before making any database access requests.
+	MaintenanceConn bool
+
+	// ReconnectDelay is the delay between failed attempts to connect the shared database session for an id (default 2 seconds).
+	ReconnectDelay time.Duration
+
+	// ConnectTimeout optionally bounds each attempt to connect a database session (including the initial ping and setting the session timeouts),
+	// so that attempts to connect to unresponsive hosts fail and are retried after the ReconnectDelay rather than hanging (zero, the default, means no timeout).
+	// The context passed to the Connector is done when the attempt returns, so Connectors must not use it for the lifetime of the database session (e.g. for a tunnel) when ConnectTimeout is set.
+	ConnectTimeout time.Duration
+
+	// stats are the counters and connection statuses returned by Stats
+	stats storeStats
+
+	// ConnectErrorIsFatal optionally classifies connection errors as fatal, in addition to errors marked using FatalConnectError.
+	// Fatal connection errors are not retried, and requests waiting for the shared database session for the id fail immediately with the connection error.
+	ConnectErrorIsFatal func(err error) bool
+}
+
+// ErrUnauthorized is returned (wrapping the Authorizer error) for requests rejected by the Store Authorizer
+var ErrUnauthorized = errors.New("dblocker: request not authorized")
+
+// ErrStoreClosed is returned for requests made (or still waiting) after the Store context is cancelled
+var ErrStoreClosed = errors.New("dblocker: store closed")
+
+// Request is a database access request
+type Request struct {
+	ctx context.Context
+
+	// released is closed once the release hooks of a RW request have been called, and the group waits for it before granting the next request
+	released chan struct{}
+}
+
+// New creates a new dblocker Store
+// using the default connectDBFunc;
+// with a default unlockTimeout for waiting for access to the database of 2 minutes, and
+// with a default statemenTimeout for database sessions of 4 minutes (where the database supports statement timeouts, see DriverCapabilities)
+func New(
+	ctx context.Context,
+	driverName string,
+	dataSourceName string,
+	debug bool,
+) (s *Store, err error) {
+
+	// Use default connectDBFunc
+	connectDBFunc := DefaultConnectDBFunc
+
+	// Default timeout for waiting for access to the database
+	unlockTimeout := 2 * time.Minute
+	defaultStatementTimeout := 4 * time.Minute
+
+	// Default statement timeout for database sessions
+	var statementTimeout *time.Duration
+	if caps, _ := DriverCapabilities(driverName); caps.StatementTimeout {
+		statementTimeout = &defaultStatementTimeout
+	}
+
+	return NewWithConnectDBFuncAndTimeouts(ctx, connectDBFunc, driverName, dataSourceName, &unlockTimeout, statementTimeout, debug)
+}
+
+// NewWithUnlockAndStatementTimeouts creates a new dblocker Store
+// using the default connectDBFunc;
+// with an unlockTimeout for waiting for access to the database; and
+// with a statemenTimeout for database sessions (returns an error if not nil and the database does not support statement timeouts).
+func NewWithUnlockAndStatementTimeouts(
+	ctx context.Context,
+	driverName string,
+	dataSourceName string,
+	unlockTimeout *time.Duration,
+	statementTimeout *time.Duration,
+	debug bool,
+) (s *Store, err error) {
+
+	// Use default connectDBFunc
+	connectDBFunc := DefaultConnectDBFunc
+
+	return NewWithConnectDBFuncAndTimeouts(ctx, connectDBFunc, driverName, dataSourceName, unlockTimeout, statementTimeout, debug)
+}
+
+// NewWithConnectDBFuncAndTimeouts creates a new dblocker Store
+// with a custom connectDBFunc (which can be used for database types not added using RegisterDriver and/or to shard requests by id for example);
+// with an unlockTimeout for waiting for access to the database; and
+// with a statemenTimeout for database sessions (returns an error if not nil and the database does not support statement timeouts, see DriverCapabilities).
+func NewWithConnectDBFuncAndTimeouts(
+	ctx context.Context,
+	connectDBFunc func(ctx context.Context, id interface{}, driverName, dataSourceName string, statementTimeout *time.Duration) (db *sqlx.DB, err error),
+	driverName string,
+	dataSourceName string,
+	unlockTimeout *time.Duration,
+	statementTimeout *time.Duration,
+	debug bool,
+) (s *Store, err error) {
+
+	return NewWithConnector(ctx, ConnectDBFuncConnector(connectDBFunc), driverName, dataSourceName, unlockTimeout, statementTimeout, debug)
+}
+
+// RWGetDB returns a shared copy of a database session (*sql.DB) for the specified id.
+// RWGetDB acts like Lock() for a RWMutex for the specified id.
+// All other RWGetDB, RWGetDBWithTimeout, and ReadDB function calls will wait for access to the database for the specified id until the returned cancel() function is called.
+func (s *Store) RWGetDB(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sql.DB, err error) {
+	h, err := s.waitGetDB(id, "rw", ctx, tag, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.Release, h.db.DB, nil
+}
+
+// RWGetDB returns a shared copy of a database session (*sqlx.DB) for the specified id.
+// github.com/jmoiron/sqlx is a library which provides a set of extensions on go's standard database/sql library.
+// RWGetDB acts like Lock() for a RWMutex for the specified id.
+// All other RWGetDB, RWGetDBWithTimeout, and ReadDB function calls will wait for access to the database for the specified id until the returned cancel() function is called.
+func (s *Store) RWGetDBx(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sqlx.DB, err error) {
+	h, err := s.waitGetDB(id, "rw", ctx, tag, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.Release, h.db, nil
+}
+
+// RWGetDBWithTimeout returns a new database session (*sql.DB) for the specified id with a custom session timeout.
+// RWGetDBWithTimeout acts like Lock() for a RWMutex for the specified id.
+// All other RWGetDB, RWGetDBWithTimeout, and ReadDB function calls will wait for access to the database for the specified id until the returned cancel() function is called.
+// The new database session is closed when the returned cancel() function is called.
+func (s *Store) RWGetDBWithTimeout(id interface{}, ctx context.Context, tag string, statementTimeout *time.Duration) (cancel context.CancelFunc, db *sql.DB, err error) {
+	h, err := s.waitGetDB(id, "rwseparate", ctx, tag, statementTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.Release, h.db.DB, nil
+}
+
+// RWGetDBWithTimeout returns a new database session (*sqlx.DB) for the specified id with a custom session timeout.
+// github.com/jmoiron/sqlx is a library which provides a set of extensions on go's standard database/sql library.
+// RWGetDBWithTimeout acts like Lock() for a RWMutex for the specified id.
+// All other RWGetDB, RWGetDBWithTimeout, and ReadDB function calls will wait for access to the database for the specified id until the returned cancel() function is called.
+// The new database session is closed when the returned cancel() function is called.
+func (s *Store) RWGetDBxWithTimeout(id interface{}, ctx context.Context, tag string, statementTimeout *time.Duration) (cancel context.CancelFunc, db *sqlx.DB, err error) {
+	h, err := s.waitGetDB(id, "rwseparate", ctx, tag, statementTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.Release, h.db, nil
+}
+
+// ReadDB returns a shared copy of a database session (*sql.DB) for the specified id.
+// ReadDB acts like RLock() for a RWMutex for the specified id.
+// Multiple ReadDB function calls can access the shared database at the same time.
+// All RWGetDB and RWGetDBWithTimeout function calls will wait for access to the database for the specified id until the returned cancel() function is called
+// (unless the Store ReadPassthrough returns true for the id and tag).
+func (s *Store) ReadGetDB(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sql.DB, err error) {
+	id = s.lockKey(id)
+	if s.isPassthrough(id, tag) {
+		release, dbx, err := s.passthroughDB(id, ctx, tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		return release, dbx.DB, nil
+	}
+
+	h, err := s.waitGetDB(id, "read", ctx, tag, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.Release, h.db.DB, nil
+}
+
+// ReadDB returns a shared copy of a database session (*sqlx.DB) for the specified id.
+// github.com/jmoiron/sqlx is a library which provides a set of extensions on go's standard database/sql library.
+// ReadDB acts like RLock() for a RWMutex for the specified id.
+// Multiple ReadDB function calls can access the shared database at the same time.
+// All RWGetDB and RWGetDBWithTimeout function calls will wait for access to the database for the specified id until the returned cancel() function is called
+// (unless the Store ReadPassthrough returns true for the id and tag).
+func (s *Store) ReadGetDBx(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sqlx.DB, err error) {
+	id = s.lockKey(id)
+	if s.isPassthrough(id, tag) {
+		release, db, err := s.passthroughDB(id, ctx, tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		return release, db, nil
+	}
+
+	h, err := s.waitGetDB(id, "read", ctx, tag, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.Release, h.db, nil
+}
+
+func (s *Store) waitGetDB(id interface{}, accessType string, parentCtx context.Context, tag string, statementTimeout *time.Duration) (h *Hold, err error) {
+	if parentCtx == nil {
+		return nil, s.misuse("%s request with a nil context (id %v, tag %q)", accessType, id, tag)
+	}
+
+	// Forward requests to the successor Store once the Store has been handed over (see Handover)
+	successor, err := s.handoverSuccessor(parentCtx)
+	if err != nil {
+		return nil, err
+	}
+	if successor != nil {
+		return successor.waitGetDB(id, accessType, parentCtx, tag, statementTimeout)
+	}
+	id = s.lockKey(id)
+
+	storeCtx := s.storeCtx()
+
+	// Use the Metadata component and operation (or the DefaultTag) as the tag if the tag is empty
+	metadata, _ := MetadataFromContext(parentCtx)
+	tag = s.requestTag(parentCtx, tag)
+
+	// Record failed requests
+	requestedAt := time.Now()
+	defer func() {
+		if err != nil {
+			err = s.blockedError(id, time.Since(requestedAt), err)
+			if err != ErrShed && err != ErrShedDeadline {
+				s.recordWait(tag, time.Since(requestedAt))
+				s.recordContention(id, tag, time.Since(requestedAt))
+			}
+			s.recordWaitError(id, accessType, tag, metadata, requestedAt, err)
+		}
+	}()
+
+	// Fail requests after the Store context is cancelled
+	if storeCtx.Err() != nil {
+		return nil, ErrStoreClosed
+	}
+
+	// Fail RW requests while the Store is read-only
+	err = s.checkReadOnly(accessType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Call the OnAcquireRequested hook, which can reject the request or modify its tag, metadata, and priority
+	parentCtx, tag, metadata, err = s.acquireRequested(parentCtx, id, AccessMode(accessType), tag, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject untagged requests (see RequireTags)
+	err = s.checkTag(accessType, id, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check that the request is authorized
+	err = s.authorize(parentCtx, id, AccessMode(accessType), tag)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the statement timeout for the tag (see TagStatementTimeouts)
+	accessType, statementTimeout, holdTimeout := s.routeTagTimeout(accessType, tag, statementTimeout)
+
+	// Shed lower priority requests when wait time SLOs are exceeded
+	if s.shed(tag) {
+		return nil, ErrShed
+	}
+
+	// Create context
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var db *sqlx.DB
+	var replica string
+	settings := s.settings()
+	unlockTimeout := settings.unlockTimeout
+	if accessType == "stream" {
+		unlockTimeout = nil
+		if settings.streamMaxDuration > 0 {
+			unlockTimeout = &settings.streamMaxDuration
+		}
+	}
+
+	// Migration holds are released when their lease expires instead (see Migrate)
+	if exemptFromUnlockTimeout(parentCtx) {
+		unlockTimeout = nil
+	}
+	// The parentCtx deadline replaces the unlockTimeout for the DeadlineCaller policy
+	deadlinePolicy := s.DeadlinePolicy
+	if _, ok := parentCtx.Deadline(); ok && deadlinePolicy == DeadlineCaller {
+		unlockTimeout = nil
+	}
+
+	// Reject requests which would only be released by Release() (see RequireRequestEnd)
+	if s.RequireRequestEnd && unlockTimeout == nil && parentCtx.Done() == nil {
+		return nil, s.misuse("%s request with a context which is never done and no unlockTimeout (id %v, tag %q)", accessType, id, tag)
+	}
+
+	// The context is released when the context of the owner of the Hold (initially parentCtx, see Hold.Transfer) is done
+	// The MaxHoldDuration cap is started when the Hold is granted
+	ownerCtx, owner := bindOwner(parentCtx, deadlinePolicy == DeadlineUnlockTimeout)
+	// The Hold can be force released with a cause (e.g. ErrConnectionLost)
+	maxHoldCtx, startMaxHold, stopMaxHold := withMaxHold(ownerCtx, s.maxHoldDuration(id))
+	releaseCtx, forceRelease := context.WithCancelCause(maxHoldCtx)
+	if unlockTimeout == nil {
+		ctx, cancel = context.WithCancel(releaseCtx)
+	} else {
+		ctx, cancel = context.WithTimeoutCause(releaseCtx, *unlockTimeout, errUnlockTimeout)
+	}
+	cancelCtx := cancel
+	cancel = func() {
+		cancelCtx()
+		forceRelease(nil)
+		stopMaxHold()
+	}
+
+	// Check accessType
+	switch accessType {
+	case "rw":
+	case "rwseparate":
+	case "read":
+	case "stream":
+	default:
+		if cancel != nil {
+			cancel()
+		}
+		return nil, fmt.Errorf("unknown access type error: %s", accessType)
+	}
+
+	// Cancel context when done
+	s.spawn("waiter", func() {
+		if settings.debug {
+			tickerCancel := s.ticker(storeCtx, ctx, tag)
+			defer tickerCancel()
+		}
+
+		select {
+		case <-storeCtx.Done():
+			if cancel != nil {
+				cancel()
+			}
+		case <-ctx.Done():
+			if cancel != nil {
+				cancel()
+			}
+		}
+		owner.unbind()
+	})
+
+	// Limit the time spent waiting for access (see WithWaitBudget), including by the parentCtx deadline if the deadline does not release the Hold
+	waitCtx := ctx
+	if parentDeadline, ok := parentCtx.Deadline(); ok && deadlinePolicy == DeadlineUnlockTimeout {
+		var waitCancel context.CancelFunc
+		waitCtx, waitCancel = context.WithDeadline(waitCtx, parentDeadline)
+		defer waitCancel()
+	}
+	if waitDeadline, ok := waitDeadlineFromContext(parentCtx); ok {
+		var waitCancel context.CancelFunc
+		waitCtx, waitCancel = context.WithDeadline(ctx, waitDeadline)
+		defer waitCancel()
+	}
+
+	// Wait for the rw request rate limit
+	if !AccessMode(accessType).isRead() {
+		err = s.waitWriteRateLimit(storeCtx, waitCtx, id)
+		if err != nil {
+			if cancel != nil {
+				cancel()
+			}
+			return nil, err
+		}
+	}
+
+	// Shed requests that are not expected to be granted before the wait deadline (or the request context deadline, which is not a deadline of the Hold context, see Hold.Transfer)
+	if s.ShedByDeadline {
+		deadline, ok := waitCtx.Deadline()
+		if parentDeadline, parentOK := parentCtx.Deadline(); parentOK && (!ok || parentDeadline.Before(deadline)) {
+			deadline, ok = parentDeadline, true
+		}
+		if ok && s.EstimateWait(id, AccessMode(accessType)) > time.Until(deadline) {
+			if cancel != nil {
+				cancel()
+			}
+			return nil, ErrShedDeadline
+		}
+	}
+
+	// Check that the database is reachable before adding the group for the id (see ProbeTimeout)
+	if err := s.probe(waitCtx, id); err != nil {
+		if cancel != nil {
+			cancel()
+		}
+		return nil, err
+	}
+
+	// Track the position of the request in the queue for the id (see WithProgress)
+	w := s.enqueue(id, tag, AccessMode(accessType))
+	defer s.dequeue(id, w)
+	if onProgress, ok := progressFromContext(parentCtx); ok {
+		s.spawn("progress", func() { s.watchProgress(waitCtx, id, w, onProgress) })
+	}
+
+	// Request channel
+	requestCh := func(g *Group) chan Request {
+		if AccessMode(accessType).isRead() {
+			return g.readRequestCh
+		}
+		return g.rwRequestCh
+	}
+
+	// The group waits for released to be closed (after the release functions of the Hold and the release hooks of a RW Hold are called, see watchRelease) before granting the next request.
+	// released is closed here if no Hold is granted.
+	released := make(chan struct{})
+	defer func() {
+		if h == nil {
+			close(released)
+		}
+	}()
+
+	// Wait for the group lock (see SchedulerPolicy)
+	var g *Group
+	var epoch int
+	if s.Scheduler != SchedulerChannels {
+		g, err = s.lockGroup(id, accessType, tag, metadata, storeCtx, waitCtx, ctx, released)
+		if err != nil {
+			if cancel != nil {
+				cancel()
+			}
+			return nil, err
+		}
+	}
+
+	// Send request and wait, retrying with a new Group if the Group is deleted before the request is received
+	for g == nil {
+		g, epoch, err = s.getGroup(id, tag, metadata)
+		if err != nil {
+			if cancel != nil {
+				cancel()
+			}
+			return nil, err
+		}
+		select {
+		case requestCh(g) <- Request{ctx: ctx, released: released}:
+		case <-g.done:
+			s.releaseGroup(id, g, epoch)
+			if storeCtx.Err() != nil {
+				if cancel != nil {
+					cancel()
+				}
+				return nil, ErrStoreClosed
+			}
+			if g.err != nil {
+				if cancel != nil {
+					cancel()
+				}
+				return nil, g.err
+			}
+			g = nil
+		case <-storeCtx.Done():
+			s.releaseGroup(id, g, epoch)
+			if cancel != nil {
+				cancel()
+			}
+			return nil, waitError(storeCtx, waitCtx)
+		case <-waitCtx.Done():
+			s.releaseGroup(id, g, epoch)
+			if cancel != nil {
+				cancel()
+			}
+			return nil, waitError(storeCtx, waitCtx)
+		}
+	}
+
+	// Decrement request count when this function returns (or when the request is released if granted by the group lock)
+	if g.lock == nil {
+		defer s.releaseGroup(id, g, epoch)
+	}
+
+	// Get database
+	switch accessType {
+	case "rwseparate":
+
+		// Get new database connection (immediately)
+		db, err = s.connectDB(waitCtx, ConnectRequest{
+			ID:               id,
+			DriverName:       settings.driverName,
+			DataSourceName:   s.dataSourceName(id),
+			StatementTimeout: s.inheritedTimeout(parentCtx, statementTimeout),
+			LockTimeout:      settings.lockTimeout,
+			Attempt:          1,
+			Tag:              tag,
+			Metadata:         metadata,
+			Logger:           connectLogger,
+		})
+		if err != nil {
+			if cancel != nil {
+				cancel()
+			}
+			return nil, err
+		}
+	case "rw", "read", "stream":
+
+		// Get shared database connection (wait)
+		select {
+		case db = <-g.dbCh:
+		case <-g.done:
+			if cancel != nil {
+				cancel()
+			}
+			return nil, fmt.Errorf("dblocker error: group deleted before database received: %v", id)
+		case <-storeCtx.Done():
+			if cancel != nil {
+				cancel()
+			}
+			return nil, waitError(storeCtx, waitCtx)
+		case <-waitCtx.Done():
+			if cancel != nil {
+				cancel()
+			}
+			return nil, waitError(storeCtx, waitCtx)
+		}
+
+		// Check the shared database session before granting the request
+		if s.Ping == PingBeforeGrant {
+			err = db.PingContext(waitCtx)
+			if err != nil {
+				if cancel != nil {
+					cancel()
+				}
+				return nil, fmt.Errorf("ping error: %w", err)
+			}
+		}
+
+		// Route reads to the read replicas
+		if accessType == "read" {
+			db, replica = s.routeRead(waitCtx, parentCtx, db)
+		}
+
+		// Reset session state left by previous holds
+		if accessType == "rw" {
+			err = s.resetSession(waitCtx, id, db)
+			if err != nil {
+				if cancel != nil {
+					cancel()
+				}
+				return nil, fmt.Errorf("session reset error: %w", err)
+			}
+		}
+	default:
+		if cancel != nil {
+			cancel()
+		}
+		return nil, fmt.Errorf("unknown access type error: %s", accessType)
+	}
+
+	// Record wait time
+	wait := time.Since(requestedAt)
+	s.recordWait(tag, wait)
+	s.recordContention(id, tag, wait)
+	s.observeWait(id, accessType, tag, wait, OutcomeGranted)
+
+	// Invalidate cached read results for the id
+	if s.Cache != nil && !AccessMode(accessType).isRead() {
+		s.Cache.Invalidate(id)
+	}
+
+	// Call release hooks when the request is released
+	h = &Hold{
+		s:            s,
+		id:           id,
+		accessType:   accessType,
+		tag:          tag,
+		metadata:     metadata,
+		parentCtx:    parentCtx,
+		owner:        owner,
+		storeCtx:     storeCtx,
+		ctx:          ctx,
+		cancel:       cancel,
+		forceRelease: forceRelease,
+		hooksDone:    released,
+		db:           db,
+		timeout:      holdTimeout,
+		requestedAt:  requestedAt,
+		grantedAt:    time.Now(),
+		stack:        s.captureStack(),
+		replica:      replica,
+	}
+	h.ctx = withHold(ctx, h)
+	if s.ProfileLabels {
+		h.ctx = pprof.WithLabels(h.ctx, h.profileLabels())
+	}
+	err = s.checkGrant(h)
+	if err != nil {
+		if cancel != nil {
+			cancel()
+		}
+		return nil, err
+	}
+	h.labelOwner(parentCtx)
+	startMaxHold()
+	s.addHold(h)
+	s.recordHotID(id)
+	s.publishAcquired(h)
+	s.spawn("release", func() { s.watchRelease(h) })
+
+	// Return hold
+	return h, nil
+}
+
+// authorize calls the Store Authorizer (if set) for a request, and returns an error wrapping ErrUnauthorized if the request is not authorized
+func (s *Store) authorize(ctx context.Context, id interface{}, mode AccessMode, tag string) error {
+	if s.Authorizer == nil {
+		return nil
+	}
+	err := s.Authorizer(ctx, id, mode, tag)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+	return nil
+}
+
+// getGroup returns the Group for the specified id (adding a new Group to the Store map if required) and increments the Group request count.
+// The tag and metadata are passed to the Connector if a new Group is added.
+// The request count epoch of the Group is passed to releaseGroup when the request is released (see CheckGroups).
+func (s *Store) getGroup(id interface{}, tag string, metadata Metadata) (g *Group, epoch int, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	g, ok := s.m[id]
+	if !ok {
+		g, err = s.addGroup(id, tag, metadata, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	g.requestCount++
+	s.stats.requests.Add(1)
+	s.observeGroup(id, g.requestCount, len(s.m))
+	return g, g.epoch, nil
+}
+
+// addGroup adds a new Group for the specified id to the Store map and starts the group goroutine.
+// The group uses the handedOver database session (see Handover) if it is not nil, and otherwise connects a new shared database session.
+// addGroup returns ErrStoreClosed if the Store has been stopped (see startRunGroup).
+// The Store must be locked when addGroup is called.
+func (s *Store) addGroup(id interface{}, tag string, metadata Metadata, handedOver *sqlx.DB) (*Group, error) {
+	run, err := s.startRunGroup()
+	if err != nil {
+		return nil, err
+	}
+	g := &Group{
+		requestCount:  0,
+		rwRequestCh:   make(chan Request),
+		readRequestCh: make(chan Request),
+		dbCh:          make(chan *sqlx.DB),
+		evictCh:       make(chan struct{}),
+		reconnectCh:   make(chan reconnectRequest),
+		done:          make(chan struct{}),
+		exited:        make(chan struct{}),
+		handedOver:    handedOver,
+
+		passthroughDoneCh: make(chan struct{}, 1),
+		lock:              s.newGroupLock(),
+	}
+	s.m[id] = g
+	s.spawn("group", func() { s.startGroup(id, g, run, tag, metadata) })
+	return g, nil
+}
+
+// releaseGroup decrements the Group request count.
+// Requests counted before the janitor wrote off the request count of the Group (i.e. in an earlier epoch) have already been removed from the request counts, so are not decremented again.
+func (s *Store) releaseGroup(id interface{}, g *Group, epoch int) {
+	s.Lock()
+	if epoch != g.epoch {
+		s.Unlock()
+		return
+	}
+	g.requestCount--
+	if s.Strict && g.requestCount < 0 {
+		s.invariantViolation(id, "request count is negative: %d", g.requestCount)
+		g.requestCount = 0
+	} else {
+		s.stats.requests.Add(-1)
+	}
+	s.observeGroup(id, g.requestCount, len(s.m))
+	s.Unlock()
+}
@@ -0,0 +1,58 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnlockTimeout is the cause of the Hold context (and of the error returned by Hold.Err and by waiting requests) when the unlockTimeout expires.
+// Errors wrapping ErrUnlockTimeout also wrap context.DeadlineExceeded, and are distinguished from the request context deadline (which is context.DeadlineExceeded).
+var ErrUnlockTimeout = errors.New("dblocker: unlock timeout")
+
+// errUnlockTimeout is the cause of the Hold context when the unlockTimeout expires
+var errUnlockTimeout = fmt.Errorf("%w: %w", ErrUnlockTimeout, context.DeadlineExceeded)
+
+// DeadlinePolicy controls how the unlockTimeout and the request context deadline are combined to release each Hold (see the Store DeadlinePolicy setting).
+// The request context deadline always limits the time spent waiting for access, and cancelling the request context always releases the Hold.
+type DeadlinePolicy int
+
+const (
+
+	// DeadlineEarliest releases each Hold when either the unlockTimeout or the request context deadline expires, whichever is earlier (default)
+	DeadlineEarliest DeadlinePolicy = iota
+
+	// DeadlineUnlockTimeout releases each Hold when the unlockTimeout expires, and the request context deadline does not release the Hold
+	DeadlineUnlockTimeout
+
+	// DeadlineCaller releases each Hold when the request context deadline expires if the request context has a deadline
+	// (i.e. the request context deadline replaces the unlockTimeout, e.g. for long batch jobs), or otherwise when the unlockTimeout expires
+	DeadlineCaller
+)
+
+// inheritedTimeout returns the statement timeout for a request with context ctx, which is tightened to the time remaining until the ctx deadline
+// if the Store InheritDeadline setting is true, the database supports statement timeouts, and the deadline is sooner than statementTimeout (where nil means no timeout).
+func (s *Store) inheritedTimeout(ctx context.Context, statementTimeout *time.Duration) *time.Duration {
+	if !s.InheritDeadline {
+		return statementTimeout
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return statementTimeout
+	}
+	caps, _ := DriverCapabilities(s.settings().driverName)
+	if !caps.StatementTimeout {
+		return statementTimeout
+	}
+
+	// Use at least one millisecond, as a zero statement timeout means no timeout
+	remaining := time.Until(deadline)
+	if remaining < time.Millisecond {
+		remaining = time.Millisecond
+	}
+	if statementTimeout != nil && *statementTimeout > 0 && *statementTimeout <= remaining {
+		return statementTimeout
+	}
+	return &remaining
+}
@@ -0,0 +1,427 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Capabilities are the features supported by a database driver
+type Capabilities struct {
+
+	// StatementTimeout is true if database sessions support statement timeouts
+	StatementTimeout bool
+
+	// LockTimeout is true if database sessions support timeouts for waiting for database locks (e.g. lock_timeout or busy_timeout)
+	LockTimeout bool
+
+	// AdvisoryLocks is true if the database supports advisory locks (e.g. pg_advisory_lock or GET_LOCK)
+	AdvisoryLocks bool
+
+	// ReadOnly is true if database sessions can be restricted to read only access
+	ReadOnly bool
+
+	// SessionAttributes is true if database sessions support setting attributes such as an application name
+	SessionAttributes bool
+
+	// CancelQueries is true if statements running on a connection can be cancelled from another connection (e.g. pg_cancel_backend or KILL QUERY)
+	CancelQueries bool
+
+	// LocalTimeouts is true if statement and lock timeouts can be set for a single transaction (e.g. SET LOCAL), which is required for TransactionPooling
+	LocalTimeouts bool
+
+	// LocalVariables is true if session variables can be set for a single transaction (e.g. set_config with is_local true), which is required for the Store SessionVariables setting
+	LocalVariables bool
+
+	// ReplicaLag is true if the replication lag of read replicas can be measured, which is required for the Store ReplicaMaxLag setting
+	ReplicaLag bool
+
+	// ReadYourWrites is true if the write position of the primary and the replayed position of read replicas can be compared, which is required for ConsistencyTokens
+	ReadYourWrites bool
+}
+
+// DriverSpec describes how the DefaultConnectDBFunc connects to and configures a database type
+type DriverSpec struct {
+
+	// Connect connects to the database (default sqlx.ConnectContext using the driver name)
+	Connect func(ctx context.Context, driverName, dataSourceName string) (db *sqlx.DB, err error)
+
+	// SetStatementTimeout sets the statement timeout for a database session or connection, where zero means no timeout (nil if statement timeouts are not supported)
+	SetStatementTimeout func(ctx context.Context, db sqlx.ExecerContext, statementTimeout time.Duration) error
+
+	// SetLockTimeout sets the timeout for waiting for database locks for a database session or connection, where zero means no timeout (nil if lock timeouts are not supported)
+	SetLockTimeout func(ctx context.Context, db sqlx.ExecerContext, lockTimeout time.Duration) error
+
+	// SetLocalTimeouts sets the statement timeout and the timeout for waiting for database locks for the current transaction only (e.g. SET LOCAL), where nil leaves the timeout unchanged
+	// (nil if transaction timeouts are not supported). SetLocalTimeouts is used by Hold.BeginTxx when the Store TransactionPooling setting is true.
+	SetLocalTimeouts func(ctx context.Context, tx sqlx.ExecerContext, statementTimeout, lockTimeout *time.Duration) error
+
+	// SetLocalVariables sets session variables for the current transaction only (e.g. set_config with is_local true, nil if not supported).
+	// SetLocalVariables is used by Hold.BeginTxx and RWGetTx when the Store SessionVariables setting is set.
+	SetLocalVariables func(ctx context.Context, tx sqlx.ExecerContext, variables map[string]string) error
+
+	// AdvisoryLockSQL and AdvisoryUnlockSQL acquire and release an advisory lock for an int64 key passed as the only argument ("" if advisory locks are not supported).
+	// Use Store.AdvisoryKey to get the advisory lock key for an id.
+	AdvisoryLockSQL   string
+	AdvisoryUnlockSQL string
+
+	// BackendID returns the database server id of a connection (e.g. pg_backend_pid()),
+	// and CancelBackend cancels the statement running on the connection with that id using another connection (nil if not supported).
+	// These are used to cancel statements still running on a Hold connection when the Hold is force released (see Hold.Conn).
+	BackendID     func(ctx context.Context, conn sqlx.QueryerContext) (backendID int64, err error)
+	CancelBackend func(ctx context.Context, db sqlx.ExecerContext, backendID int64) error
+
+	// ReplicaLag returns the replication lag of a read replica (e.g. using pg_last_wal_replay_lsn and pg_last_xact_replay_timestamp, or SHOW SLAVE STATUS),
+	// which is used to exclude lagging replicas from read routing (nil if not supported, see the Store Replicas and ReplicaMaxLag settings)
+	ReplicaLag func(ctx context.Context, db sqlx.QueryerContext) (lag time.Duration, err error)
+
+	// WritePosition returns the current write position of the primary (e.g. pg_current_wal_lsn or @@GLOBAL.gtid_executed),
+	// and ReplayedPosition returns true if a read replica has replayed a write position (e.g. using pg_last_wal_replay_lsn or GTID_SUBSET),
+	// which are used for read-your-writes consistency (nil if not supported, see ConsistencyToken)
+	WritePosition    func(ctx context.Context, db sqlx.QueryerContext) (position string, err error)
+	ReplayedPosition func(ctx context.Context, db sqlx.QueryerContext, position string) (replayed bool, err error)
+
+	// ResetSessionSQL resets the session state of a connection (e.g. DISCARD ALL), and is used by the SessionResetDiscard policy ("" if not supported)
+	ResetSessionSQL string
+
+	// Validate optionally checks a data source name before connecting
+	Validate func(dataSourceName string) error
+
+	// IsFatalError optionally returns true for connection errors which should not be retried (e.g. bad credentials or an unknown database, see ErrFatalConnect)
+	IsFatalError func(err error) bool
+
+	// IsConnectionLost optionally returns true for query errors which mean that the connection to the database was lost, in addition to driver.ErrBadConn (see Store.ObserveError)
+	IsConnectionLost func(err error) bool
+
+	// Address optionally returns the network and address of the database server for a data source name, which is used to check that the database is reachable before connecting
+	// (see the Store ProbeTimeout). Address returns an empty address if the data source name cannot be probed (e.g. a unix socket directory).
+	Address func(dataSourceName string) (network, address string, err error)
+
+	// ApplicationName optionally returns the data source name with the label added to the end of the application name of the database sessions (e.g. postgres application_name),
+	// and is used to label the database sessions connected by the Store (see the Store LabelConnections setting)
+	ApplicationName func(dataSourceName, label string) (string, error)
+
+	// Listen optionally LISTENs for notifications on channel using a new connection to the database, and calls notify for each notification received until ctx is done
+	// (nil if notifications are not supported, see Store.Notifications). Listen calls onError for connection errors, and returns when ctx is done.
+	// The postgres Listen function is set by the github.com/calmdocs/dblocker/drivers/postgres package.
+	Listen func(ctx context.Context, dataSourceName, channel string, notify func(n Notification), onError func(err error)) error
+
+	// Capabilities are the features supported by the database.
+	// StatementTimeout, LockTimeout, AdvisoryLocks, CancelQueries, ReplicaLag, and ReadYourWrites are set by RegisterDriver
+	// from SetStatementTimeout, SetLockTimeout, AdvisoryLockSQL, BackendID, CancelBackend, ReplicaLag, WritePosition, and ReplayedPosition.
+	Capabilities Capabilities
+}
+
+// driverPackages are the packages which register the database/sql drivers (and the driver specific DriverSpec functions) for the built in database types.
+// The core package does not import any database drivers, so that programs only build the drivers that they use.
+var driverPackages = map[string]string{
+	"sqlite3":  "github.com/calmdocs/dblocker/drivers/sqlite",
+	"postgres": "github.com/calmdocs/dblocker/drivers/postgres",
+	"mysql":    "github.com/calmdocs/dblocker/drivers/mysql",
+	"mock":     "github.com/calmdocs/dblocker/drivers/mock",
+}
+
+// driverImportHint returns a hint to import the package which registers a built in database type ("" for other database types)
+func driverImportHint(driverName string) string {
+	pkg, ok := driverPackages[driverName]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" (import _ %q)", pkg)
+}
+
+// sqlDriverRegistered returns true if a database/sql driver has been registered with the driverName
+func sqlDriverRegistered(driverName string) bool {
+	for _, name := range sql.Drivers() {
+		if name == driverName {
+			return true
+		}
+	}
+	return false
+}
+
+// libsqlConnectTimeout is the timeout for connecting to remote libSQL (Turso) servers
+const libsqlConnectTimeout = 30 * time.Second
+
+var drivers = struct {
+	sync.RWMutex
+
+	m map[string]DriverSpec
+}{
+	m: make(map[string]DriverSpec),
+}
+
+func init() {
+	RegisterDriver("sqlite3", DriverSpec{
+		SetLockTimeout: setSQLiteBusyTimeout,
+		Capabilities:   Capabilities{ReadOnly: true},
+	})
+	RegisterDriver("sqlcipher", DriverSpec{
+		SetLockTimeout: setSQLiteBusyTimeout,
+		Capabilities:   Capabilities{ReadOnly: true},
+	})
+	RegisterDriver("libsql", DriverSpec{
+
+		// Bound the initial ping to remote libSQL servers
+		Connect: func(ctx context.Context, driverName, dataSourceName string) (db *sqlx.DB, err error) {
+			connectCtx, connectCancel := context.WithTimeout(ctx, libsqlConnectTimeout)
+			defer connectCancel()
+			return sqlx.ConnectContext(connectCtx, driverName, dataSourceName)
+		},
+	})
+	RegisterDriver("postgres", DriverSpec{
+		SetStatementTimeout: func(ctx context.Context, db sqlx.ExecerContext, statementTimeout time.Duration) error {
+			_, err := db.ExecContext(ctx, fmt.Sprintf("SET statement_timeout = %d;", statementTimeout.Milliseconds()))
+			return err
+		},
+		SetLockTimeout: func(ctx context.Context, db sqlx.ExecerContext, lockTimeout time.Duration) error {
+			_, err := db.ExecContext(ctx, fmt.Sprintf("SET lock_timeout = %d;", lockTimeout.Milliseconds()))
+			return err
+		},
+		SetLocalTimeouts: func(ctx context.Context, tx sqlx.ExecerContext, statementTimeout, lockTimeout *time.Duration) error {
+			if statementTimeout != nil {
+				_, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d;", statementTimeout.Milliseconds()))
+				if err != nil {
+					return err
+				}
+			}
+			if lockTimeout != nil {
+				_, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL lock_timeout = %d;", lockTimeout.Milliseconds()))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		SetLocalVariables: setPostgresLocalVariables,
+		AdvisoryLockSQL:   "SELECT pg_advisory_lock($1);",
+		AdvisoryUnlockSQL: "SELECT pg_advisory_unlock($1);",
+		ResetSessionSQL:   "DISCARD ALL;",
+		BackendID: func(ctx context.Context, conn sqlx.QueryerContext) (backendID int64, err error) {
+			err = conn.QueryRowxContext(ctx, "SELECT pg_backend_pid();").Scan(&backendID)
+			return backendID, err
+		},
+		CancelBackend: func(ctx context.Context, db sqlx.ExecerContext, backendID int64) error {
+			_, err := db.ExecContext(ctx, "SELECT pg_cancel_backend($1);", backendID)
+			return err
+		},
+
+		// Invalid authorization (class 28) and unknown database errors, using the SQLSTATE of the driver error (e.g. lib/pq *pq.Error or pgx *pgconn.PgError)
+		IsFatalError: func(err error) bool {
+			var stateErr interface{ SQLState() string }
+			if !errors.As(err, &stateErr) {
+				return false
+			}
+			code := stateErr.SQLState()
+			return strings.HasPrefix(code, "28") || code == "3D000"
+		},
+
+		// Connection exceptions (class 08) and server shutdowns
+		IsConnectionLost: func(err error) bool {
+			var stateErr interface{ SQLState() string }
+			if !errors.As(err, &stateErr) {
+				return false
+			}
+			code := stateErr.SQLState()
+			return strings.HasPrefix(code, "08") || code == "57P01" || code == "57P02" || code == "57P03"
+		},
+
+		// Replicas which have replayed all of the WAL they have received are not lagging, even if there have been no recent transactions to replay
+		ReplicaLag: func(ctx context.Context, db sqlx.QueryerContext) (lag time.Duration, err error) {
+			var seconds float64
+			err = db.QueryRowxContext(ctx, "SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END;").Scan(&seconds)
+			return time.Duration(seconds * float64(time.Second)), err
+		},
+		WritePosition: func(ctx context.Context, db sqlx.QueryerContext) (position string, err error) {
+			err = db.QueryRowxContext(ctx, "SELECT pg_current_wal_lsn()::text;").Scan(&position)
+			return position, err
+		},
+		ReplayedPosition: func(ctx context.Context, db sqlx.QueryerContext, position string) (replayed bool, err error) {
+			err = db.QueryRowxContext(ctx, "SELECT COALESCE(pg_last_wal_replay_lsn() >= $1::pg_lsn, false);", position).Scan(&replayed)
+			return replayed, err
+		},
+		Address:         postgresAddress,
+		ApplicationName: postgresApplicationName,
+		Capabilities:    Capabilities{ReadOnly: true, SessionAttributes: true},
+	})
+	RegisterDriver("mysql", DriverSpec{
+		SetStatementTimeout: func(ctx context.Context, db sqlx.ExecerContext, statementTimeout time.Duration) error {
+			_, err := db.ExecContext(ctx, fmt.Sprintf("SET SESSION MAX_EXECUTION_TIME=%d;", statementTimeout.Milliseconds()))
+			return err
+		},
+
+		// innodb_lock_wait_timeout is in whole seconds (at least 1), and has no "no timeout" value, so zero uses the maximum
+		SetLockTimeout: func(ctx context.Context, db sqlx.ExecerContext, lockTimeout time.Duration) error {
+			seconds := int64((lockTimeout + time.Second - 1) / time.Second)
+			switch {
+			case lockTimeout == 0:
+				seconds = 1073741824
+			case seconds < 1:
+				seconds = 1
+			}
+			_, err := db.ExecContext(ctx, fmt.Sprintf("SET SESSION innodb_lock_wait_timeout=%d;", seconds))
+			return err
+		},
+		AdvisoryLockSQL:   "SELECT GET_LOCK(?, -1);",
+		AdvisoryUnlockSQL: "SELECT RELEASE_LOCK(?);",
+		BackendID: func(ctx context.Context, conn sqlx.QueryerContext) (backendID int64, err error) {
+			err = conn.QueryRowxContext(ctx, "SELECT CONNECTION_ID();").Scan(&backendID)
+			return backendID, err
+		},
+		CancelBackend: func(ctx context.Context, db sqlx.ExecerContext, backendID int64) error {
+			_, err := db.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d;", backendID))
+			return err
+		},
+		ReplicaLag: mysqlReplicaLag,
+		WritePosition: func(ctx context.Context, db sqlx.QueryerContext) (position string, err error) {
+			err = db.QueryRowxContext(ctx, "SELECT @@GLOBAL.gtid_executed;").Scan(&position)
+			return position, err
+		},
+		ReplayedPosition: func(ctx context.Context, db sqlx.QueryerContext, position string) (replayed bool, err error) {
+			err = db.QueryRowxContext(ctx, "SELECT GTID_SUBSET(?, @@GLOBAL.gtid_executed);", position).Scan(&replayed)
+			return replayed, err
+		},
+		Address:         mysqlAddress,
+		ApplicationName: mysqlApplicationName,
+		Capabilities:    Capabilities{ReadOnly: true, SessionAttributes: true},
+	})
+}
+
+// mysqlReplicaLag returns the Seconds_Behind_Master of SHOW SLAVE STATUS, which is NULL (and returned as an error) if replication is not running
+func mysqlReplicaLag(ctx context.Context, db sqlx.QueryerContext) (lag time.Duration, err error) {
+	rows, err := db.QueryxContext(ctx, "SHOW SLAVE STATUS;")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("replica lag error: not a replica")
+	}
+	status := make(map[string]interface{})
+	err = rows.MapScan(status)
+	if err != nil {
+		return 0, err
+	}
+	var seconds int64
+	switch v := status["Seconds_Behind_Master"].(type) {
+	case int64:
+		seconds = v
+	case []byte:
+		seconds, err = strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("replica lag error: %w", err)
+		}
+	default:
+		return 0, fmt.Errorf("replica lag error: replication not running")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// setSQLiteBusyTimeout sets the time that sqlite waits for database locks held by other connections (where zero means that locks are not waited for)
+func setSQLiteBusyTimeout(ctx context.Context, db sqlx.ExecerContext, lockTimeout time.Duration) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d;", lockTimeout.Milliseconds()))
+	return err
+}
+
+// RegisterDriver adds (or replaces) a database type used by the DefaultConnectDBFunc, constructor validation, and DriverCapabilities.
+// The driverName is also the name of the registered database/sql driver used by the default DriverSpec Connect function.
+func RegisterDriver(driverName string, spec DriverSpec) {
+	spec.Capabilities.StatementTimeout = spec.SetStatementTimeout != nil
+	spec.Capabilities.LockTimeout = spec.SetLockTimeout != nil
+	spec.Capabilities.AdvisoryLocks = spec.AdvisoryLockSQL != ""
+	spec.Capabilities.CancelQueries = spec.BackendID != nil && spec.CancelBackend != nil
+	spec.Capabilities.LocalTimeouts = spec.SetLocalTimeouts != nil
+	spec.Capabilities.LocalVariables = spec.SetLocalVariables != nil
+	spec.Capabilities.ReplicaLag = spec.ReplicaLag != nil
+	spec.Capabilities.ReadYourWrites = spec.WritePosition != nil && spec.ReplayedPosition != nil
+
+	drivers.Lock()
+	defer drivers.Unlock()
+
+	drivers.m[driverName] = spec
+}
+
+// LookupDriver returns the DriverSpec for a database type, and false if the database type has not been registered
+func LookupDriver(driverName string) (spec DriverSpec, ok bool) {
+	drivers.RLock()
+	defer drivers.RUnlock()
+
+	spec, ok = drivers.m[driverName]
+	return spec, ok
+}
+
+// DriverCapabilities returns the Capabilities of a database driver, and false if the driver is unknown.
+// Capabilities for drivers connected using a custom connectDBFunc can be added using RegisterDriverCapabilities.
+func DriverCapabilities(driverName string) (caps Capabilities, ok bool) {
+	spec, ok := LookupDriver(driverName)
+	return spec.Capabilities, ok
+}
+
+// RegisterDriverCapabilities sets the Capabilities of a database driver, for example for a driver connected using a custom connectDBFunc.
+// Use RegisterDriver instead to also allow the DefaultConnectDBFunc to connect to the database.
+// RegisterDriverCapabilities returns an error (and does not change the Capabilities) if a capability is not implemented by the registered DriverSpec
+// (e.g. StatementTimeout is true and the DriverSpec SetStatementTimeout function is nil), as the statement timeouts of Hold connections are set using the DriverSpec.
+func RegisterDriverCapabilities(driverName string, caps Capabilities) error {
+	drivers.Lock()
+	defer drivers.Unlock()
+
+	spec := drivers.m[driverName]
+	spec.Capabilities = caps
+	err := spec.validateCapabilities()
+	if err != nil {
+		return fmt.Errorf("register driver error: %s: %w", driverName, err)
+	}
+	drivers.m[driverName] = spec
+	return nil
+}
+
+// validateCapabilities returns an error if a capability of the DriverSpec is not implemented by the DriverSpec
+func (spec DriverSpec) validateCapabilities() error {
+	caps := spec.Capabilities
+	switch {
+	case caps.StatementTimeout && spec.SetStatementTimeout == nil:
+		return fmt.Errorf("StatementTimeout capability requires SetStatementTimeout")
+	case caps.LockTimeout && spec.SetLockTimeout == nil:
+		return fmt.Errorf("LockTimeout capability requires SetLockTimeout")
+	case caps.AdvisoryLocks && (spec.AdvisoryLockSQL == "" || spec.AdvisoryUnlockSQL == ""):
+		return fmt.Errorf("AdvisoryLocks capability requires AdvisoryLockSQL and AdvisoryUnlockSQL")
+	case caps.CancelQueries && (spec.BackendID == nil || spec.CancelBackend == nil):
+		return fmt.Errorf("CancelQueries capability requires BackendID and CancelBackend")
+	case caps.ReplicaLag && spec.ReplicaLag == nil:
+		return fmt.Errorf("ReplicaLag capability requires ReplicaLag")
+	case caps.ReadYourWrites && (spec.WritePosition == nil || spec.ReplayedPosition == nil):
+		return fmt.Errorf("ReadYourWrites capability requires WritePosition and ReplayedPosition")
+	case caps.LocalTimeouts && spec.SetLocalTimeouts == nil:
+		return fmt.Errorf("LocalTimeouts capability requires SetLocalTimeouts")
+	case caps.LocalVariables && spec.SetLocalVariables == nil:
+		return fmt.Errorf("LocalVariables capability requires SetLocalVariables")
+	}
+	return nil
+}
+
+// connect connects to the database using the DriverSpec
+func (spec DriverSpec) connect(ctx context.Context, driverName, dataSourceName string) (db *sqlx.DB, err error) {
+	if spec.Validate != nil {
+		err = spec.Validate(dataSourceName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if spec.Connect != nil {
+		return spec.Connect(ctx, driverName, dataSourceName)
+	}
+	if !sqlDriverRegistered(driverName) {
+		return nil, FatalConnectError(fmt.Errorf("database/sql driver not registered: %s%s", driverName, driverImportHint(driverName)))
+	}
+	return sqlx.ConnectContext(ctx, driverName, dataSourceName)
+}
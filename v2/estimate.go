@@ -0,0 +1,158 @@
+package dblocker
+
+import (
+	"time"
+)
+
+// holdSampleCount is the maximum number of recent hold times kept for each id
+const holdSampleCount = 32
+
+// maxHoldStats is the number of ids with hold statistics above which ids without recent holds are forgotten
+const maxHoldStats = 4096
+
+type holdSample struct {
+	tag  string
+	hold time.Duration
+}
+
+// holdStats are the recent hold times for an id
+type holdStats struct {
+	buf  [holdSampleCount]holdSample
+	next int
+	n    int
+	at   time.Time
+}
+
+// EstimateWait returns the estimated time until a new request for the specified id and mode would be granted access to the database,
+// based on the current holds for the id, the requests already waiting for the id, and the recent hold times for each tag used with the id.
+// EstimateWait returns zero if there are no holds or waiting requests for the id, or if there are no recent hold times for the id.
+func (s *Store) EstimateWait(id interface{}, mode AccessMode) time.Duration {
+	id = s.lockKey(id)
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	q, ok := s.queues.m[id]
+	if !ok {
+		return 0
+	}
+	return s.estimateWait(id, q, q.waiters, mode)
+}
+
+// estimateWait estimates the wait time for a request behind the current holds and the waiters ahead of it.
+// Read requests are granted together, so consecutive read waiters add their longest expected hold time rather than the sum.
+// The queues must be locked when estimateWait is called.
+func (s *Store) estimateWait(id interface{}, q *queue, ahead []*waiter, mode AccessMode) (wait time.Duration) {
+	stats := s.queues.holdStats[id]
+
+	// Remaining time for the current holds
+	readOnly := true
+	for h := range q.holds {
+		if !AccessMode(h.accessType).isRead() {
+			readOnly = false
+		}
+		remaining := stats.mean(h.tag) - time.Since(h.grantedAt)
+		if remaining > wait {
+			wait = remaining
+		}
+	}
+
+	// Read requests do not wait for read holds unless other requests are waiting
+	if mode.isRead() && readOnly && len(ahead) == 0 {
+		return 0
+	}
+
+	// Expected hold times for the waiting requests
+	var reads time.Duration
+	for _, w := range ahead {
+		hold := stats.mean(w.tag)
+		if w.mode.isRead() {
+			if hold > reads {
+				reads = hold
+			}
+			continue
+		}
+		wait += reads + hold
+		reads = 0
+	}
+	if !mode.isRead() {
+		wait += reads
+	}
+	return wait
+}
+
+// mean returns the mean recent hold time for a tag, or for all tags if there are no recent hold times for the tag
+func (hs *holdStats) mean(tag string) time.Duration {
+	if hs == nil || hs.n == 0 {
+		return 0
+	}
+	var tagTotal, total time.Duration
+	tagCount := 0
+	for i := 0; i < hs.n; i++ {
+		total += hs.buf[i].hold
+		if hs.buf[i].tag == tag {
+			tagTotal += hs.buf[i].hold
+			tagCount++
+		}
+	}
+	if tagCount > 0 {
+		return tagTotal / time.Duration(tagCount)
+	}
+	return total / time.Duration(hs.n)
+}
+
+// addHold adds a granted hold to the current holds for its id
+func (s *Store) addHold(h *Hold) {
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	q := s.queueFor(h.id)
+	if q.holds == nil {
+		q.holds = make(map[*Hold]struct{})
+	}
+	q.holds[h] = struct{}{}
+	if h.accessType == "stream" {
+		s.stats.streams.Add(1)
+	}
+}
+
+// recordHoldTime removes a released hold from the current holds for its id, and records its hold time
+func (s *Store) recordHoldTime(h *Hold, hold time.Duration) {
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	if q, ok := s.queues.m[h.id]; ok {
+		if _, ok := q.holds[h]; ok && h.accessType == "stream" {
+			s.stats.streams.Add(-1)
+		}
+		delete(q.holds, h)
+		if len(q.waiters) == 0 && len(q.holds) == 0 {
+			delete(s.queues.m, h.id)
+		}
+	}
+
+	now := time.Now()
+	if s.queues.holdStats == nil {
+		s.queues.holdStats = make(map[interface{}]*holdStats)
+	}
+
+	// Forget ids without recent holds
+	if len(s.queues.holdStats) >= maxHoldStats {
+		for otherID, hs := range s.queues.holdStats {
+			if now.Sub(hs.at) > 10*time.Minute {
+				delete(s.queues.holdStats, otherID)
+			}
+		}
+	}
+
+	hs, ok := s.queues.holdStats[h.id]
+	if !ok {
+		hs = &holdStats{}
+		s.queues.holdStats[h.id] = hs
+	}
+	hs.buf[hs.next] = holdSample{tag: h.tag, hold: hold}
+	hs.next = (hs.next + 1) % holdSampleCount
+	if hs.n < holdSampleCount {
+		hs.n++
+	}
+	hs.at = now
+}
@@ -0,0 +1,237 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// AccessMode is the type of database access request
+type AccessMode string
+
+const (
+
+	// AccessRW is a RWGetDB request for exclusive access to the shared database session
+	AccessRW AccessMode = "rw"
+
+	// AccessRWSeparate is a RWGetDBWithTimeout request for exclusive access using a new database session
+	AccessRWSeparate AccessMode = "rwseparate"
+
+	// AccessRead is a ReadGetDB request for shared access to the shared database session
+	AccessRead AccessMode = "read"
+
+	// AccessStream is a StreamHold request for shared access to the shared database session for a long streaming read
+	AccessStream AccessMode = "stream"
+)
+
+// isRead returns true for access modes with shared access to the shared database session
+func (mode AccessMode) isRead() bool {
+	return mode == AccessRead || mode == AccessStream
+}
+
+// Outcome is the result of a database access request
+type Outcome string
+
+const (
+
+	// OutcomeGranted means that the hold was granted (used for MetricsSink wait times)
+	OutcomeGranted Outcome = "granted"
+
+	// OutcomeReleased means that the hold was granted and then released by the caller
+	OutcomeReleased Outcome = "released"
+
+	// OutcomeUnlockTimeout means that the hold was granted and then released when the unlockTimeout expired (see ErrUnlockTimeout)
+	OutcomeUnlockTimeout Outcome = "unlock timeout"
+
+	// OutcomeMaxHoldDuration means that the hold was granted and then force released when the Store MaxHoldDuration expired (see ErrMaxHoldDuration)
+	OutcomeMaxHoldDuration Outcome = "max hold duration"
+
+	// OutcomeConnectionLost means that the hold was granted and then force released because the shared database session for the id lost its connection to the database (see ErrConnectionLost)
+	OutcomeConnectionLost Outcome = "connection lost"
+
+	// OutcomeDeadline means that the hold was granted and then released when the parent context deadline expired (see DeadlinePolicy)
+	OutcomeDeadline Outcome = "deadline"
+
+	// OutcomeCancelled means that the hold was granted and then released when the parent context was cancelled
+	OutcomeCancelled Outcome = "cancelled"
+
+	// OutcomeStoreClosed means that the request was released or failed because the Store context was cancelled
+	OutcomeStoreClosed Outcome = "store closed"
+
+	// OutcomeWaitTimeout means that the request timed out before the hold was granted
+	OutcomeWaitTimeout Outcome = "wait timeout"
+
+	// OutcomeWaitCancelled means that the request was cancelled before the hold was granted
+	OutcomeWaitCancelled Outcome = "wait cancelled"
+
+	// OutcomeShed means that the request was shed because a wait time SLO or rate limit was being exceeded, or because it was not expected to be granted before its deadline
+	OutcomeShed Outcome = "shed"
+
+	// OutcomeUnauthorized means that the request was rejected by the Store Authorizer
+	OutcomeUnauthorized Outcome = "unauthorized"
+
+	// OutcomeRejected means that the request was rejected by the OnAcquireRequested hook (see ErrRejected)
+	OutcomeRejected Outcome = "rejected"
+
+	// OutcomeReadOnly means that the RW request failed because the Store is read-only (see ErrReadOnlyStore)
+	OutcomeReadOnly Outcome = "read-only"
+
+	// OutcomeError means that the request failed with an error (e.g. a database connection error)
+	OutcomeError Outcome = "error"
+)
+
+// Event is a record of a completed database access request, or of another Store lifecycle event delivered to subscribers (see Subscribe and Kind)
+type Event struct {
+	Kind        EventKind
+	ID          interface{}
+	Tag         string
+	Metadata    Metadata
+	Mode        AccessMode
+	RequestedAt time.Time
+
+	// Wait is the time spent waiting for the hold to be granted, and Hold is the time that the hold was held for (zero if never granted)
+	Wait time.Duration
+	Hold time.Duration
+
+	Outcome Outcome
+	Err     error
+}
+
+// events is a ring buffer of recent Events
+type events struct {
+	sync.Mutex
+
+	buf  []Event
+	next int
+	full bool
+
+	// recorded is the total number of Events recorded, which is used to find the position of Events in the buffer (see Store.Events)
+	recorded uint64
+}
+
+// RecentEvents returns the most recent completed database access requests, oldest first.
+// Events are only recorded in debug mode (and only sampled Events are recorded if the Store Sampler is set), and the number of events kept is set by Store.RecentEventsSize (default 256).
+func (s *Store) RecentEvents() []Event {
+	e := &s.events
+	e.Lock()
+	defer e.Unlock()
+
+	if !e.full {
+		return append([]Event(nil), e.buf[:e.next]...)
+	}
+	return append(append([]Event(nil), e.buf[e.next:]...), e.buf[:e.next]...)
+}
+
+// recordEvent adds an Event to the ring buffer in debug mode
+func (s *Store) recordEvent(ev Event) {
+	if !s.settings().debug {
+		return
+	}
+
+	e := &s.events
+	e.Lock()
+	defer e.Unlock()
+
+	size := s.RecentEventsSize
+	if size <= 0 {
+		size = 256
+	}
+	if len(e.buf) != size {
+		e.buf = make([]Event, size)
+		e.next = 0
+		e.full = false
+	}
+	e.buf[e.next] = ev
+	e.next = (e.next + 1) % size
+	if e.next == 0 {
+		e.full = true
+	}
+	e.recorded++
+}
+
+// recordWaitError records a request that failed before the hold was granted in the recent events and metrics
+func (s *Store) recordWaitError(id interface{}, accessType string, tag string, metadata Metadata, requestedAt time.Time, err error) {
+	outcome := OutcomeError
+	switch {
+	case errors.Is(err, ErrStoreClosed):
+		outcome = OutcomeStoreClosed
+	case errors.Is(err, ErrUnauthorized):
+		outcome = OutcomeUnauthorized
+	case errors.Is(err, ErrRejected):
+		outcome = OutcomeRejected
+	case errors.Is(err, ErrReadOnlyStore):
+		outcome = OutcomeReadOnly
+	case errors.Is(err, ErrShed), errors.Is(err, ErrShedDeadline), errors.Is(err, ErrRateLimited):
+		outcome = OutcomeShed
+	case errors.Is(err, context.DeadlineExceeded):
+		outcome = OutcomeWaitTimeout
+	case errors.Is(err, context.Canceled):
+		outcome = OutcomeWaitCancelled
+	}
+	wait := time.Since(requestedAt)
+	s.observeWait(id, accessType, tag, wait, outcome)
+	kind := EventFailed
+	if outcome == OutcomeWaitTimeout {
+		kind = EventTimeout
+	}
+	ev := Event{
+		Kind:        kind,
+		ID:          id,
+		Tag:         tag,
+		Metadata:    metadata,
+		Mode:        AccessMode(accessType),
+		RequestedAt: requestedAt,
+		Wait:        wait,
+		Outcome:     outcome,
+		Err:         err,
+	}
+	if s.sample(ev) {
+		s.recordEvent(ev)
+	}
+	s.publish(ev)
+}
+
+// recordRelease records a released hold in the metrics (and in the recent events if sampled, see Sampler), and returns the Event and whether the Event was sampled
+func (s *Store) recordRelease(h *Hold) (ev Event, sampled bool) {
+	outcome := OutcomeReleased
+	switch {
+	case h.releasedOK():
+	case h.storeCtx.Err() != nil:
+		outcome = OutcomeStoreClosed
+	case errors.Is(context.Cause(h.ctx), ErrMaxHoldDuration):
+		outcome = OutcomeMaxHoldDuration
+	case errors.Is(context.Cause(h.ctx), ErrConnectionLost):
+		outcome = OutcomeConnectionLost
+	case errors.Is(context.Cause(h.ctx), ErrUnlockTimeout):
+		outcome = OutcomeUnlockTimeout
+	case errors.Is(context.Cause(h.ctx), context.DeadlineExceeded):
+		outcome = OutcomeDeadline
+	default:
+		outcome = OutcomeCancelled
+	}
+	hold := time.Since(h.grantedAt)
+	s.recordHoldTime(h, hold)
+	s.observeHold(h, hold, outcome)
+	kind := EventReleased
+	if outcome == OutcomeUnlockTimeout {
+		kind = EventTimeout
+	}
+	ev = Event{
+		Kind:        kind,
+		ID:          h.id,
+		Tag:         h.tag,
+		Metadata:    h.Metadata(),
+		Mode:        AccessMode(h.accessType),
+		RequestedAt: h.requestedAt,
+		Wait:        h.grantedAt.Sub(h.requestedAt),
+		Hold:        hold,
+		Outcome:     outcome,
+	}
+	sampled = s.sample(ev)
+	if sampled {
+		s.recordEvent(ev)
+	}
+	s.publish(ev)
+	return ev, sampled
+}
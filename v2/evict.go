@@ -0,0 +1,42 @@
+package dblocker
+
+import (
+	"context"
+)
+
+// Evict waits for all holds for the specified id to be released, closes the shared database session for the id, and deletes the group for the id.
+// Evict does not wait for ReadPassthrough reads for the id to be released.
+// Requests for the id that are still waiting when the group is deleted are granted access using a new shared database session.
+// Evict returns an error if ctx is done before the holds for the id are released.
+func (s *Store) Evict(ctx context.Context, id interface{}) error {
+	id = s.lockKey(id)
+
+	storeCtx := s.storeCtx()
+	s.Lock()
+	g, ok := s.m[id]
+	s.Unlock()
+	if !ok {
+		return nil
+	}
+
+	// Wait for the requests granted by the group lock to be released
+	if g.lock != nil {
+		err := g.lock.lock(ctx, false)
+		if err != nil {
+			return err
+		}
+		defer g.lock.unlock(false)
+	}
+
+	select {
+	case g.evictCh <- struct{}{}:
+	case <-g.done:
+		return nil
+	case <-storeCtx.Done():
+		return ErrStoreClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-g.done
+	return nil
+}
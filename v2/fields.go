@@ -0,0 +1,89 @@
+package dblocker
+
+import (
+	"context"
+	"time"
+)
+
+// Context returns the context for the current run of the Store (see Start and Stop), which is cancelled when the Store is stopped.
+// Context returns a cancelled context if the Store has not been started.
+func (s *Store) Context() context.Context {
+	return s.storeCtx()
+}
+
+// CurrentDriverName returns the database type of the Store
+func (s *Store) CurrentDriverName() string {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+
+	return s.DriverName
+}
+
+// CurrentDataSourceName returns the default data source name of the Store, which is used for ids without a data source name set using Reconnect
+func (s *Store) CurrentDataSourceName() string {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+
+	return s.DataSourceName
+}
+
+// CurrentUnlockTimeout returns a copy of the time after which holds are released (nil means no timeout)
+func (s *Store) CurrentUnlockTimeout() *time.Duration {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+
+	return copyTimeout(s.UnlockTimeout)
+}
+
+// CurrentStatementTimeout returns a copy of the statement timeout for database sessions (nil means no timeout).
+// Use StatementTimeoutFor to get the statement timeout for an id (see AdaptiveStatementTimeout).
+func (s *Store) CurrentStatementTimeout() *time.Duration {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+
+	return copyTimeout(s.StatementTimeout)
+}
+
+// SetDriverName sets the database type used for database sessions connected after SetDriverName returns.
+// Existing database sessions are not reconnected (see Reconnect, or use Reload to drain and reconnect them).
+func (s *Store) SetDriverName(driverName string) {
+	s.setField(func() { s.DriverName = driverName })
+}
+
+// SetDataSourceName sets the default data source name used for database sessions connected after SetDataSourceName returns.
+// Existing database sessions are not reconnected (see Reconnect, or use Reload to drain and reconnect them).
+func (s *Store) SetDataSourceName(dataSourceName string) {
+	s.setField(func() { s.DataSourceName = dataSourceName })
+}
+
+// SetUnlockTimeout sets the time after which holds granted after SetUnlockTimeout returns are released (nil means no timeout)
+func (s *Store) SetUnlockTimeout(unlockTimeout *time.Duration) {
+	unlockTimeout = copyTimeout(unlockTimeout)
+	s.setField(func() { s.UnlockTimeout = unlockTimeout })
+}
+
+// SetStatementTimeout sets the statement timeout for database sessions connected after SetStatementTimeout returns (nil means no timeout)
+func (s *Store) SetStatementTimeout(statementTimeout *time.Duration) {
+	statementTimeout = copyTimeout(statementTimeout)
+	s.setField(func() { s.StatementTimeout = statementTimeout })
+}
+
+// setField sets a Store setting while holding both the Store lock and the settings lock, in the same way as Reload,
+// so that the setting can be read while holding either lock
+func (s *Store) setField(set func()) {
+	s.Lock()
+	defer s.Unlock()
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+
+	set()
+}
+
+// copyTimeout returns a copy of a timeout, so that the caller cannot change the timeout used by the Store
+func copyTimeout(timeout *time.Duration) *time.Duration {
+	if timeout == nil {
+		return nil
+	}
+	t := *timeout
+	return &t
+}
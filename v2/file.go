@@ -0,0 +1,47 @@
+package dblocker
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// OpenFile opens the named file (see os.OpenFile) while holding access to the specified id, and returns the file and a release function.
+// Files opened for writing (i.e. with os.O_WRONLY, os.O_RDWR, os.O_APPEND, os.O_CREATE, or os.O_TRUNC) use a RW hold, and other files use a read hold,
+// so that the files for an id are guarded in the same way as (and at the same time as) the database for the id.
+// OpenFile can be used with any Store, including lock-only Stores (see the "lockonly" database type).
+//
+// The release function closes the file and releases the hold, and can be called more than once.
+// The file is also closed if the hold is force released (i.e. when the unlockTimeout expires or the Store context is cancelled),
+// so that the file is not used after another request has been granted access to the id.
+func (s *Store) OpenFile(id interface{}, ctx context.Context, tag string, name string, flag int, perm os.FileMode) (f *os.File, release func(), err error) {
+	var h *Hold
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		h, err = s.RWHold(id, ctx, tag)
+	} else {
+		h, err = s.ReadHold(id, ctx, tag)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err = os.OpenFile(name, flag, perm)
+	if err != nil {
+		h.Release()
+		return nil, nil, err
+	}
+
+	// Close the file before the next request for the id is granted
+	if !h.addOnRelease(func() { f.Close() }) {
+		f.Close()
+		return nil, nil, h.Err()
+	}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			f.Close()
+			h.Release()
+		})
+	}
+	return f, release, nil
+}
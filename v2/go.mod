@@ -0,0 +1,9 @@
+module github.com/calmdocs/dblocker/v2
+
+go 1.23
+
+require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
+	github.com/jmoiron/sqlx v1.4.0
+	github.com/mattn/go-sqlite3 v1.14.22
+)
@@ -0,0 +1,461 @@
+package dblocker
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TeardownPolicy controls when the shared database session for an id is closed
+type TeardownPolicy int
+
+const (
+
+	// TeardownImmediate closes the shared database session and deletes the group for an id as soon as there are no requests for the id (default)
+	TeardownImmediate TeardownPolicy = iota
+
+	// TeardownLinger keeps the shared database session for an id open for the Store TeardownLinger duration after there are no requests for the id
+	TeardownLinger
+
+	// TeardownNever keeps the shared database session for an id open until Evict is called for the id or the Store context is cancelled
+	TeardownNever
+)
+
+// Group is a group storing the shared database for an id
+type Group struct {
+	requestCount int64
+
+	// epoch is incremented when the janitor writes off the request count (see CheckGroups), so that requests counted in an earlier epoch are not decremented again when they are released
+	epoch int
+
+	DB            *sqlx.DB
+	rwRequestCh   chan Request
+	readRequestCh chan Request
+	dbCh          chan *sqlx.DB
+	evictCh       chan struct{}
+	reconnectCh   chan reconnectRequest
+
+	// passthroughDoneCh is notified when a passthrough read for the id (see ReadPassthrough) or a request granted by the group lock is released
+	passthroughDoneCh chan struct{}
+
+	// maintenanceDB is the maintenance database session for the id (see MaintenanceConn), and maintenanceGeneration is incremented when the maintenance session is started or closed
+	maintenanceDB         *sqlx.DB
+	maintenanceGeneration int
+
+	// lock grants requests for the id when the Store Scheduler is not SchedulerChannels (nil otherwise)
+	lock groupLock
+
+	// handover is true if the shared database session is kept for the successor Store when the group is deleted (see Handover)
+	handover bool
+
+	// handedOver is the shared database session handed over by a predecessor Store (see Handover), which the group uses rather than connecting a new session
+	handedOver *sqlx.DB
+
+	// connectionLost is true while the shared database session is being recovered after the connection to the database was lost (see ObserveError)
+	connectionLost bool
+
+	// done is closed when the group is deleted
+	done chan struct{}
+
+	// exited is closed when the group goroutine returns (see CheckGroups)
+	exited chan struct{}
+
+	// err is set before done is closed if the group was deleted because of a fatal connection error
+	err error
+}
+
+func (s *Store) startGroup(id interface{}, g *Group, run *storeRun, tag string, metadata Metadata) {
+	defer run.groups.Done()
+	defer close(g.exited)
+	storeCtx := run.ctx
+
+	isRW := false
+	readCount := 0
+
+	rwDoneCh := make(chan bool)
+	readDoneCh := make(chan bool)
+
+	// Linger timer for the TeardownLinger policy
+	lingerTimer := time.NewTimer(0)
+	defer lingerTimer.Stop()
+	<-lingerTimer.C
+	var lingerC <-chan time.Time
+
+	// Connect to the database (unless a database has been adopted or handed over for the id) without holding the Store lock
+	dataSourceName := s.dataSourceName(id)
+	statementTimeout := s.StatementTimeoutFor(id)
+	s.Lock()
+	db, adopted := s.adopted[id]
+	if g.handedOver != nil {
+		db = g.handedOver
+	}
+	connectRequest := ConnectRequest{
+		ID:               id,
+		DriverName:       s.DriverName,
+		DataSourceName:   dataSourceName,
+		StatementTimeout: statementTimeout,
+		LockTimeout:      s.LockTimeout,
+		Tag:              tag,
+		Metadata:         metadata,
+		Logger:           connectLogger,
+	}
+	reconnectDelay := s.ReconnectDelay
+	s.Unlock()
+	if db == nil {
+		var err error
+		db, err = s.connectGroupAndWait(storeCtx, id, g, &connectRequest, reconnectDelay)
+		if err != nil {
+
+			// Requests waiting for the group retry (or fail with ErrStoreClosed) if the Store was stopped
+			if storeCtx.Err() != nil {
+				err = nil
+			}
+			s.failGroup(id, g, err)
+			return
+		}
+	}
+	s.Lock()
+	g.DB = db
+	s.Unlock()
+	if !adopted {
+		s.startMaintenanceDB(storeCtx, id, g, connectRequest)
+	}
+
+	// Listen for postgres notifications while the group exists
+	var listenCancel context.CancelFunc
+	if s.ListenChannel != nil && listenSupported(connectRequest.DriverName) {
+		listenCancel = startListen(s, storeCtx, id)
+		defer func() {
+			listenCancel()
+		}()
+	}
+
+	// Groups which are started for a handed over session without any requests are deleted according to the TeardownPolicy
+	if g.handedOver != nil {
+		if s.teardownGroup(id, g, false) {
+			return
+		}
+		lingerC = s.lingerTimer(lingerTimer)
+	}
+
+	for {
+
+		switch {
+
+		// Reading and writing
+		case isRW:
+			for isRW {
+				select {
+
+				// Send shared database to channel if requested
+				case g.dbCh <- g.DB:
+
+				// Wait for rw request to finish
+				case <-rwDoneCh:
+					isRW = false
+
+				case <-storeCtx.Done():
+					s.closeGroup(id, g)
+					return
+				}
+			}
+
+			// Close connection and delete group when done
+			if s.teardownGroup(id, g, false) {
+				return
+			}
+			lingerC = s.lingerTimer(lingerTimer)
+
+		// Reading
+		case readCount > 0:
+			select {
+
+			// Send shared database to channel if requested
+			case g.dbCh <- g.DB:
+
+			// Read request
+			case r := <-g.readRequestCh:
+				readCount++
+
+				// Send message to readDoneCh when the request context is cancelled and the release functions of the Hold have been called
+				s.spawn("waiter", func() {
+					select {
+					case <-r.ctx.Done():
+					case <-storeCtx.Done():
+						return
+					}
+					select {
+					case <-r.released:
+					case <-storeCtx.Done():
+						return
+					}
+					select {
+					case readDoneCh <- true:
+					case <-storeCtx.Done():
+						return
+					}
+				})
+
+			// Read request is finished
+			case <-readDoneCh:
+				readCount--
+				if s.Strict && readCount < 0 {
+					s.invariantViolation(id, "read count is negative: %d", readCount)
+					readCount = 0
+				}
+
+				// Close connection and delete group when all read requests are done
+				if readCount == 0 {
+					if s.teardownGroup(id, g, false) {
+						return
+					}
+					lingerC = s.lingerTimer(lingerTimer)
+				}
+
+			case <-storeCtx.Done():
+				s.closeGroup(id, g)
+				return
+			}
+
+		// Database is unused
+		default:
+			select {
+			case <-storeCtx.Done():
+				s.closeGroup(id, g)
+				return
+
+			// Close connection and delete group when evicted
+			case <-g.evictCh:
+				s.Lock()
+				s.deleteGroup(id, g)
+				s.Unlock()
+				return
+
+			// Close connection and connect using the new data source name
+			case r := <-g.reconnectCh:
+				s.Lock()
+				s.setDataSourceName(id, r.dataSourceName)
+				delete(s.adopted, id)
+				s.Unlock()
+				dataSourceName := s.dataSourceName(id)
+				statementTimeout := s.StatementTimeoutFor(id)
+
+				s.closeDB(g.DB)
+				s.Lock()
+				s.closeMaintenanceDB(g)
+				connectRequest.DriverName = s.DriverName
+				connectRequest.DataSourceName = dataSourceName
+				connectRequest.StatementTimeout = statementTimeout
+				connectRequest.LockTimeout = s.LockTimeout
+				reconnectDelay := s.ReconnectDelay
+				s.Unlock()
+
+				// Stop retrying if the Reconnect context is done
+				connectCtx, connectCancel := context.WithCancel(storeCtx)
+				stop := context.AfterFunc(r.ctx, connectCancel)
+				db, err := connectDBAndWait(connectCtx, s.connectGroupDB, connectRequest, reconnectDelay, s.fatalConnectError)
+				stop()
+				connectCancel()
+				if err != nil {
+
+					// Requests waiting for the group retry with a new group if the Reconnect context is done
+					switch {
+					case storeCtx.Err() != nil:
+						err = ErrStoreClosed
+						s.failGroup(id, g, err)
+					case r.ctx.Err() != nil:
+						err = r.ctx.Err()
+						s.failGroup(id, g, nil)
+					default:
+						s.failGroup(id, g, err)
+					}
+					r.done <- err
+					return
+				}
+				s.Lock()
+				g.DB = db
+				s.Unlock()
+				s.startMaintenanceDB(storeCtx, id, g, connectRequest)
+
+				// Restart the LISTEN connection using the new data source name
+				if listenCancel != nil {
+					listenCancel()
+					listenCancel = startListen(s, storeCtx, id)
+				}
+				r.done <- nil
+
+			// Close connection and delete group when passthrough reads are done
+			case <-g.passthroughDoneCh:
+				if s.teardownGroup(id, g, false) {
+					return
+				}
+				lingerC = s.lingerTimer(lingerTimer)
+
+			// Close connection and delete group after lingering
+			case <-lingerC:
+				lingerC = nil
+				if s.teardownGroup(id, g, true) {
+					return
+				}
+
+			// Send shared database to channel if requested
+			case g.dbCh <- g.DB:
+
+			// RW request
+			case r := <-g.rwRequestCh:
+				isRW = true
+				lingerC = nil
+
+				// Send message to rwDoneCh when the request context is cancelled and the release hooks have been called
+				s.spawn("waiter", func() {
+					select {
+					case <-r.ctx.Done():
+					case <-storeCtx.Done():
+						return
+					}
+					select {
+					case <-r.released:
+					case <-storeCtx.Done():
+						return
+					}
+					select {
+					case rwDoneCh <- true:
+					case <-storeCtx.Done():
+						return
+					}
+				})
+
+			// Read request
+			case r := <-g.readRequestCh:
+				readCount++
+				lingerC = nil
+
+				// Send message to readDoneCh when the request context is cancelled and the release functions of the Hold have been called
+				s.spawn("waiter", func() {
+					select {
+					case <-r.ctx.Done():
+					case <-storeCtx.Done():
+						return
+					}
+					select {
+					case <-r.released:
+					case <-storeCtx.Done():
+						return
+					}
+					select {
+					case readDoneCh <- true:
+					case <-storeCtx.Done():
+						return
+					}
+				})
+			}
+		}
+	}
+}
+
+// teardownGroup closes the shared database connection and deletes the group if there are no requests for the id,
+// and returns true if the group was deleted.
+// Unless lingered is true, groups are only deleted if the Store TeardownPolicy is TeardownImmediate.
+// Groups using adopted databases are only deleted by Evict.
+func (s *Store) teardownGroup(id interface{}, g *Group, lingered bool) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if g.requestCount != 0 {
+		return false
+	}
+	if !lingered && s.TeardownPolicy != TeardownImmediate {
+		return false
+	}
+	if _, ok := s.adopted[id]; ok {
+		return false
+	}
+
+	s.deleteGroup(id, g)
+	return true
+}
+
+// deleteGroup deletes the group and closes the shared database connection.
+// Requests waiting to be received by the group retry with a new group.
+// The Store must be locked when deleteGroup is called.
+func (s *Store) deleteGroup(id interface{}, g *Group) {
+	s.closeGroupDone(id, g)
+
+	// Close the database (which waits for running statements to finish), and call the Connector cleanup function, without holding the Store lock
+	db := g.DB
+	cleanup := s.cleanups[db]
+	delete(s.cleanups, db)
+	if ho := s.handover.Load(); g.handover && ho != nil {
+		ho.dbs[id] = handoverDB{db: db, cleanup: cleanup}
+	} else {
+		s.spawn("cleanup", func() {
+			db.Close()
+			s.closedDB(db)
+			if cleanup != nil {
+				cleanup()
+			}
+		})
+	}
+	g.DB = nil
+	s.closeMaintenanceDB(g)
+	delete(s.m, id)
+	delete(s.adopted, id)
+	s.stats.connections.Delete(id)
+	s.observeGroup(id, 0, len(s.m))
+	s.publish(Event{Kind: EventEvict, ID: id})
+}
+
+// closeGroup deletes a group after the Store context is cancelled, and then closes the shared database session once statements that have already started have finished (see sql.DB.Close).
+// Holds for the group have already been notified that the Store is closed (see Hold.Done).
+func (s *Store) closeGroup(id interface{}, g *Group) {
+	s.Lock()
+	db := g.DB
+	cleanup := s.cleanups[db]
+	delete(s.cleanups, db)
+	s.closeGroupDone(id, g)
+	g.DB = nil
+	s.closeMaintenanceDB(g)
+	delete(s.m, id)
+	delete(s.adopted, id)
+	s.stats.connections.Delete(id)
+	s.observeGroup(id, 0, len(s.m))
+	s.Unlock()
+
+	db.Close()
+	s.closedDB(db)
+	if cleanup != nil {
+		cleanup()
+	}
+}
+
+// failGroup deletes a group which could not connect to the database, and fails the requests waiting for the group with err.
+// The connection status for the id is kept until the group for the id is next deleted after connecting successfully.
+func (s *Store) failGroup(id interface{}, g *Group, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	g.err = err
+	g.DB = nil
+	s.closeMaintenanceDB(g)
+	s.closeGroupDone(id, g)
+	delete(s.m, id)
+	s.observeGroup(id, 0, len(s.m))
+}
+
+// lingerTimer resets the linger timer for the TeardownLinger policy and returns the timer channel,
+// or returns nil for other policies.
+func (s *Store) lingerTimer(t *time.Timer) <-chan time.Time {
+	settings := s.settings()
+	if settings.teardownPolicy != TeardownLinger {
+		return nil
+	}
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(settings.teardownLinger)
+	return t.C
+}
@@ -0,0 +1,122 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ReadHandle is a read grant (see AcquireRead), which only has query accessors, so that the type system prevents writes through read grants.
+// Unlike a *Hold, a ReadHandle does not expose the shared database session, so statements can only be run using the query methods.
+// Use the handle Context (or a context bound to it, see Hold.BindContext) as the context for queries so that running queries are cancelled if the grant is force released.
+type ReadHandle interface {
+	ID() interface{}
+	Tag() string
+	Context() context.Context
+	Done() <-chan struct{}
+	Err() error
+	Release()
+
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+	QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// WriteHandle is an exclusive RW grant (see AcquireWrite), which has the query accessors of a ReadHandle and also the accessors which can write
+type WriteHandle interface {
+	ReadHandle
+
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	BeginTxx(opts *sql.TxOptions) (*sqlx.Tx, error)
+
+	// Hold returns the underlying Hold, for features which are only available using a *Hold (e.g. Hold.Conn or Hold.AfterRelease)
+	Hold() *Hold
+}
+
+// AcquireRead waits for shared access to the shared database session for the specified id (see ReadHold), and returns a ReadHandle which cannot write through the grant.
+// AcquireRead does not enforce read only access on the database itself (see Capabilities ReadOnly); it is an API-level guard against writes through read grants.
+func (s *Store) AcquireRead(id interface{}, ctx context.Context, tag string) (ReadHandle, error) {
+	h, err := s.ReadHold(id, ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	return readHandle{h: h}, nil
+}
+
+// AcquireWrite waits for exclusive access to the shared database session for the specified id (see RWHold), and returns a WriteHandle
+func (s *Store) AcquireWrite(id interface{}, ctx context.Context, tag string) (WriteHandle, error) {
+	h, err := s.RWHold(id, ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	return writeHandle{readHandle{h: h}}, nil
+}
+
+// readHandle implements ReadHandle using a Hold
+type readHandle struct {
+	h *Hold
+}
+
+func (r readHandle) ID() interface{}          { return r.h.ID() }
+func (r readHandle) Tag() string              { return r.h.Tag() }
+func (r readHandle) Context() context.Context { return r.h.Context() }
+func (r readHandle) Done() <-chan struct{}    { return r.h.Done() }
+func (r readHandle) Err() error               { return r.h.Err() }
+func (r readHandle) Release()                 { r.h.Release() }
+
+// db returns the database session of the Hold, and counts the query (see QueryBudget)
+func (r readHandle) db() *sqlx.DB {
+	db := r.h.DB()
+	r.h.countQuery()
+	return db
+}
+
+func (r readHandle) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return r.db().QueryContext(ctx, query, args...)
+}
+
+func (r readHandle) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return r.db().QueryRowContext(ctx, query, args...)
+}
+
+func (r readHandle) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	return r.db().QueryxContext(ctx, query, args...)
+}
+
+func (r readHandle) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	return r.db().QueryRowxContext(ctx, query, args...)
+}
+
+func (r readHandle) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return r.db().SelectContext(ctx, dest, query, args...)
+}
+
+func (r readHandle) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return r.db().GetContext(ctx, dest, query, args...)
+}
+
+// writeHandle implements WriteHandle using a RW Hold
+type writeHandle struct {
+	readHandle
+}
+
+func (w writeHandle) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return w.db().ExecContext(ctx, query, args...)
+}
+
+func (w writeHandle) NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error) {
+	return w.db().NamedExecContext(ctx, query, arg)
+}
+
+func (w writeHandle) BeginTxx(opts *sql.TxOptions) (*sqlx.Tx, error) {
+	return w.h.BeginTxx(opts)
+}
+
+func (w writeHandle) Hold() *Hold {
+	return w.h
+}
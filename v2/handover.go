@@ -0,0 +1,203 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// handover is the state of a Store which is being (or has been) handed over to a successor Store (see Handover)
+type handover struct {
+
+	// done is closed when the handover is complete (or abandoned, in which case successor is nil), and successor is set before done is closed
+	done      chan struct{}
+	successor *Store
+
+	// dbs are the shared database sessions kept for the successor when the groups are deleted, and their Connector cleanup functions
+	dbs map[interface{}]handoverDB
+}
+
+type handoverDB struct {
+	db      *sqlx.DB
+	cleanup func()
+}
+
+// Handover hands the ids of the Store over to a successor Store (e.g. a Store rebuilt after a configuration change), so that callers of the Store see minimal disruption.
+// Handover freezes the Store, so that new requests wait for the handover to complete and are then forwarded to the successor
+// (i.e. callers can keep making requests using the Store, and receive Holds granted by the successor).
+// Handover then waits for the waiting requests and the holds granted by the Store to be released, and deletes the groups of the Store.
+// The shared database session for each id is transferred to the successor (as the shared database session of a new group for the id, which is deleted according to the successor TeardownPolicy) if the successor uses the same driver, data source name, statement timeout, and lock timeout for the id
+// and does not already have a group for the id, and is otherwise closed.
+// The data source names set using Reconnect and the databases set using AdoptDB (for ids without a group) are also transferred, unless the successor already has them for the id.
+//
+// The successor should use the same Keyer as the Store. ReadPassthrough reads are not forwarded, and do not delay the handover.
+// Stop the Store (which no longer has any groups) once the callers of the Store have been updated to use the successor.
+// If ctx is done before the holds are released, Handover unfreezes the Store and returns the ctx error.
+func (s *Store) Handover(ctx context.Context, successor *Store) error {
+	if successor == nil || successor == s {
+		return fmt.Errorf("handover error: invalid successor")
+	}
+	ho := &handover{
+		done: make(chan struct{}),
+		dbs:  make(map[interface{}]handoverDB),
+	}
+	s.Lock()
+	if !s.handover.CompareAndSwap(nil, ho) {
+		s.Unlock()
+		return fmt.Errorf("handover error: handover already started")
+	}
+	s.Unlock()
+
+	// Wait for the requests which are already waiting to be granted, and then for every hold to be released (see Evict).
+	// Repeat until there are no groups, as requests made just before the Store was frozen may create new groups.
+	var err error
+	for err == nil {
+		err = s.waitNoRequests(ctx)
+		if err != nil {
+			break
+		}
+		s.Lock()
+		ids := make([]interface{}, 0, len(s.m))
+		for id, g := range s.m {
+			g.handover = true
+			ids = append(ids, id)
+		}
+		s.Unlock()
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			err = s.Evict(ctx, id)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		s.Lock()
+		for _, g := range s.m {
+			g.handover = false
+		}
+		s.handover.Store(nil)
+		dbs := ho.dbs
+		ho.dbs = nil
+		s.Unlock()
+		for id, hdb := range dbs {
+			s.transferDB(id, hdb, s)
+		}
+		close(ho.done)
+		return fmt.Errorf("handover error: %w", err)
+	}
+
+	// Transfer the id registrations and shared database sessions
+	s.Lock()
+	dataSourceNames := s.dataSourceNames
+	s.dataSourceNames = nil
+	adopted := s.adopted
+	s.adopted = nil
+	dbs := ho.dbs
+	ho.dbs = nil
+	s.Unlock()
+
+	successor.Lock()
+	for id, dataSourceName := range dataSourceNames {
+		if _, ok := successor.dataSourceNames[id]; !ok {
+			successor.setDataSourceName(id, dataSourceName)
+		}
+	}
+	successor.Unlock()
+	for id, db := range adopted {
+		if successor.AdoptDB(id, db) != nil {
+			db.Close()
+		}
+	}
+	for id, hdb := range dbs {
+		s.transferDB(id, hdb, successor)
+	}
+
+	ho.successor = successor
+	close(ho.done)
+	return nil
+}
+
+// waitNoRequests waits until no requests are waiting for access to any id
+func (s *Store) waitNoRequests(ctx context.Context) error {
+	return waitUntil(ctx, func() bool { return s.stats.requests.Load() == 0 })
+}
+
+// transferDB installs a shared database session kept for the handover as the shared database session of a new group for the id in the successor (see installHandoverDB),
+// if the successor uses the same database settings for the id, and otherwise closes the database session.
+// transferDB also returns database sessions to the Store itself if the handover is abandoned.
+func (s *Store) transferDB(id interface{}, hdb handoverDB, successor *Store) {
+	current, next := s.settings(), successor.settings()
+	same := successor == s ||
+		(current.driverName == next.driverName &&
+			s.dataSourceName(id) == successor.dataSourceName(id) &&
+			equalTimeouts(s.StatementTimeoutFor(id), successor.StatementTimeoutFor(id)) &&
+			equalTimeouts(current.lockTimeout, next.lockTimeout))
+	if !same {
+		hdb.db.Close()
+		s.closedDB(hdb.db)
+		if hdb.cleanup != nil {
+			hdb.cleanup()
+		}
+		return
+	}
+
+	// The successor now closes the database session
+	if successor != s {
+		s.resources.Lock()
+		_, opened := s.resources.openDBs[hdb.db]
+		s.resources.Unlock()
+		if opened {
+			s.movedDB(successor, hdb.db)
+		}
+	}
+	if !successor.installHandoverDB(id, hdb) {
+		hdb.db.Close()
+		successor.closedDB(hdb.db)
+		if hdb.cleanup != nil {
+			hdb.cleanup()
+		}
+	}
+}
+
+// installHandoverDB adds a group for an id which uses a shared database session handed over by a predecessor Store (see Handover),
+// so that the session is used, and deleted according to the TeardownPolicy, in the same way as the sessions connected by the Store.
+// installHandoverDB returns false if the Store already has a group or an adopted database for the id, or if the Store has been stopped.
+func (s *Store) installHandoverDB(id interface{}, hdb handoverDB) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	_, ok := s.m[id]
+	_, adopted := s.adopted[id]
+	if ok || adopted {
+		return false
+	}
+	if hdb.cleanup != nil {
+		if s.cleanups == nil {
+			s.cleanups = make(map[*sqlx.DB]func())
+		}
+		s.cleanups[hdb.db] = hdb.cleanup
+	}
+	if _, err := s.addGroup(id, "", Metadata{}, hdb.db); err != nil {
+		delete(s.cleanups, hdb.db)
+		return false
+	}
+	return true
+}
+
+// handoverSuccessor waits while the Store is being handed over (see Handover), and then returns the successor Store (or nil if the Store has not been handed over)
+func (s *Store) handoverSuccessor(ctx context.Context) (successor *Store, err error) {
+	ho := s.handover.Load()
+	if ho == nil {
+		return nil, nil
+	}
+	select {
+	case <-ho.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return ho.successor, nil
+}
@@ -0,0 +1,301 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Hold is a granted database access request for an id.
+// The Hold is released when Release() is called, when the request context is done (see RequireRequestEnd), when the unlockTimeout expires, or when the Store context is cancelled.
+// After the Store context is cancelled, the shared database session is closed once statements that have already started have finished.
+type Hold struct {
+	s *Store
+
+	id           interface{}
+	accessType   string
+	tag          string
+	metadata     Metadata
+	parentCtx    context.Context
+	owner        *holdOwner
+	storeCtx     context.Context
+	ctx          context.Context
+	cancel       context.CancelFunc
+	forceRelease context.CancelCauseFunc
+	hooksDone    chan struct{}
+	db           *sqlx.DB
+	timeout      *time.Duration
+	requestedAt  time.Time
+	grantedAt    time.Time
+	stack        string
+	replica      string
+
+	mu           sync.Mutex
+	released     bool
+	afterRelease []func(ctx context.Context) error
+	onRelease    []func()
+	queries      int
+}
+
+// RWHold returns a Hold with a shared copy of a database session for the specified id.
+// RWHold acts like Lock() for a RWMutex for the specified id (see RWGetDBx).
+func (s *Store) RWHold(id interface{}, ctx context.Context, tag string) (h *Hold, err error) {
+	return s.waitGetDB(id, "rw", ctx, tag, nil)
+}
+
+// RWHoldWithTimeout returns a Hold with a new database session for the specified id with a custom session timeout.
+// RWHoldWithTimeout acts like Lock() for a RWMutex for the specified id (see RWGetDBxWithTimeout).
+func (s *Store) RWHoldWithTimeout(id interface{}, ctx context.Context, tag string, statementTimeout *time.Duration) (h *Hold, err error) {
+	return s.waitGetDB(id, "rwseparate", ctx, tag, statementTimeout)
+}
+
+// ReadHold returns a Hold with a shared copy of a database session for the specified id.
+// ReadHold acts like RLock() for a RWMutex for the specified id (see ReadGetDBx).
+func (s *Store) ReadHold(id interface{}, ctx context.Context, tag string) (h *Hold, err error) {
+	return s.waitGetDB(id, "read", ctx, tag, nil)
+}
+
+// ID returns the id of the Hold
+func (h *Hold) ID() interface{} {
+	return h.id
+}
+
+// Tag returns the tag of the Hold
+func (h *Hold) Tag() string {
+	return h.tag
+}
+
+// Mode returns the access mode of the Hold
+func (h *Hold) Mode() AccessMode {
+	return AccessMode(h.accessType)
+}
+
+// DB returns the database session (*sqlx.DB) of the Hold.
+// Use Context (or BindContext) as the context for queries so that running queries are cancelled if the Hold is force released.
+func (h *Hold) DB() *sqlx.DB {
+	h.checkReleased("DB")
+	return h.db
+}
+
+// BindContext returns a copy of ctx which is also cancelled when the Hold is released.
+// Queries run using the returned context are cancelled by the database driver (e.g. using a postgres cancel request) if the Hold is force released
+// (i.e. when the unlockTimeout expires or the Store context is cancelled), so that the database is not left running orphaned queries.
+// The returned context carries a token for the Hold (see HoldFromContext).
+// Call the returned cancel function when the queries are finished.
+func (h *Hold) BindContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(withHold(ctx, h))
+	stop := context.AfterFunc(h.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Context returns a context that is cancelled when the Hold is released, and which carries a token for the Hold (see HoldFromContext)
+func (h *Hold) Context() context.Context {
+	return h.ctx
+}
+
+// Done returns a channel that is closed when the Hold is released, when the unlockTimeout expires, or when the Store context is cancelled
+func (h *Hold) Done() <-chan struct{} {
+	return h.ctx.Done()
+}
+
+// Err returns nil until Done is closed, and then returns ErrStoreClosed if the Store context was cancelled or otherwise the Hold context error
+// (an error wrapping ErrUnlockTimeout and context.DeadlineExceeded if the unlockTimeout expired, or context.DeadlineExceeded if the owner's context deadline expired)
+func (h *Hold) Err() error {
+	if h.ctx.Err() == nil {
+		return nil
+	}
+	return waitError(h.storeCtx, h.ctx)
+}
+
+// StreamHold returns a Hold with a shared copy of a database session for the specified id for a long streaming read (e.g. COPY or a large export).
+// StreamHold acts like RLock() for a RWMutex for the specified id (see ReadHold),
+// except that the Hold is exempt from the unlockTimeout and is instead released after the Store StreamMaxDuration (if set).
+// Stream holds are counted separately in Stats.
+func (s *Store) StreamHold(id interface{}, ctx context.Context, tag string) (h *Hold, err error) {
+	return s.waitGetDB(id, "stream", ctx, tag, nil)
+}
+
+// Release releases the Hold.  Release can be called more than once.
+// Release does nothing after the Hold has been transferred to another owner (see Transfer).
+func (h *Hold) Release() {
+	h.checkReleased("Release")
+	h.release(0)
+}
+
+// release releases the Hold if generation is the number of times that the Hold has been transferred
+func (h *Hold) release(generation int) {
+	h.mu.Lock()
+	if h.owner != nil {
+		h.owner.mu.Lock()
+		current := h.owner.generation
+		h.owner.mu.Unlock()
+		if generation != current {
+			h.mu.Unlock()
+			return
+		}
+	}
+	if h.ctx.Err() == nil {
+		h.released = true
+	}
+	h.mu.Unlock()
+
+	h.cancel()
+}
+
+// AfterRelease registers fn to be called after a RW Hold is successfully released
+// (i.e. when Release() is called before the unlockTimeout expires and before the Store context is cancelled).
+// Callbacks for an id are called in the order that they were registered, one at a time, and are retried using the Store AfterReleaseRetries and AfterReleaseRetryDelay settings.
+// Callbacks are discarded if the Hold is not successfully released.
+// AfterRelease returns an error for read Holds and for Holds that have already been released.
+func (h *Hold) AfterRelease(fn func(ctx context.Context) error) error {
+	switch h.accessType {
+	case "rw", "rwseparate":
+	default:
+		return fmt.Errorf("after release error: not a rw hold: %s", h.tag)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ctx.Err() != nil {
+		return fmt.Errorf("after release error: hold already released: %s", h.tag)
+	}
+	h.afterRelease = append(h.afterRelease, fn)
+	return nil
+}
+
+// addOnRelease registers fn to be called when the Hold is released (however it is released), before the next request for the id is granted.
+// addOnRelease returns false (and does not register fn) if the Hold has already been released.
+func (h *Hold) addOnRelease(fn func()) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ctx.Err() != nil {
+		return false
+	}
+	h.onRelease = append(h.onRelease, fn)
+	return true
+}
+
+// releasedOK returns true if Release() was called before the Hold was otherwise cancelled
+func (h *Hold) releasedOK() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.released && h.storeCtx.Err() == nil
+}
+
+// Conn returns a connection from the shared database session of the Hold which is pinned to the Hold until the Hold is released.
+// If statementTimeout is not nil, the statement timeout for the connection is set to statementTimeout until the Hold is released,
+// and is then restored to the statement timeout for the id (see StatementTimeoutFor, or no timeout) before the connection is returned to the pool.
+// If statementTimeout is nil, the statement timeout for the connection is set to the statement timeout for the Hold tag (see TagStatementTimeouts) if any,
+// or otherwise to the adapted statement timeout for the id if the Store AdaptiveStatementTimeout is set.
+// Conn can be used, for example, to allow a single slow report to run using a shared hold rather than a RWGetDBWithTimeout session.
+// If the Hold is force released (i.e. when the unlockTimeout expires or the Store context is cancelled) and the database supports cancelling queries (see Capabilities),
+// any statement still running on the connection is cancelled on the database server (e.g. using pg_cancel_backend, sent using the maintenance session for the id if connected, see MaintenanceConn) before the connection is returned to the pool.
+// If the Store InheritDeadline setting is true and the request context deadline is sooner than statementTimeout (or the Store StatementTimeout if statementTimeout is nil),
+// the statement timeout for the connection is set to the time remaining until the deadline.
+// Do not close the returned connection.
+// Conn returns an error if statementTimeout is not nil and the database does not support statement timeouts (see RegisterDriver).
+// If the Store TransactionPooling setting is true, Conn does not set statement timeouts or cancel running statements, and returns an error wrapping ErrTransactionPooling if statementTimeout is not nil.
+func (h *Hold) Conn(statementTimeout *time.Duration) (conn *sqlx.Conn, err error) {
+	h.checkReleased("Conn")
+	settings := h.s.settings()
+	spec, _ := LookupDriver(settings.driverName)
+
+	// Session statement timeouts are not kept by transaction pooling proxies, and the backend of the connection may change between statements
+	if h.s.TransactionPooling {
+		if statementTimeout != nil {
+			return nil, fmt.Errorf("conn error: %w", transactionPoolingError("connection statement timeouts (use Hold.BeginTxx)"))
+		}
+		conn, err = h.db.Connx(h.ctx)
+		if err != nil {
+			return nil, err
+		}
+		h.s.spawn("conn", func() {
+			<-h.ctx.Done()
+			conn.Close()
+		})
+		return conn, nil
+	}
+
+	// Set the statement timeout for the tag (see TagStatementTimeouts) or the adapted statement timeout for the id (see AdaptiveStatementTimeout) on the connection
+	if statementTimeout == nil {
+		statementTimeout = h.timeout
+	}
+	if statementTimeout == nil && h.s.AdaptiveStatementTimeout != nil {
+		statementTimeout = h.s.StatementTimeoutFor(h.id)
+	}
+	if ownerCtx := h.ownerCtx(); ownerCtx != nil {
+		timeout := statementTimeout
+		if timeout == nil {
+			timeout = settings.statementTimeout
+		}
+		if inherited := h.s.inheritedTimeout(ownerCtx, timeout); inherited != timeout {
+			statementTimeout = inherited
+		}
+	}
+	if statementTimeout != nil && spec.SetStatementTimeout == nil {
+		return nil, fmt.Errorf("conn error: statementTimeout for database type not implemented: %s", settings.driverName)
+	}
+
+	conn, err = h.db.Connx(h.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if statementTimeout != nil {
+		err = spec.SetStatementTimeout(h.ctx, h.s.labelExecer(h.id, conn), *statementTimeout)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// Get the database server id of the connection so that running statements can be cancelled if the Hold is force released
+	// (except on read replicas, as statements are cancelled using the maintenance connection to the primary)
+	var backendID int64
+	cancelQueries := spec.Capabilities.CancelQueries && h.replica == ""
+	if cancelQueries {
+		backendID, err = spec.BackendID(h.ctx, conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	// Cancel running statements, restore the statement timeout, and return the connection to the pool when the Hold is released
+	h.s.spawn("conn", func() {
+		<-h.ctx.Done()
+		if cancelQueries && !h.releasedOK() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := spec.CancelBackend(ctx, h.s.maintenanceDBFor(h.id, h.db), backendID)
+			cancel()
+			if err != nil {
+				fmt.Println("dbLocker conn cancel error:", err.Error())
+			}
+		}
+		if statementTimeout != nil {
+			var restore time.Duration
+			if restoreTimeout := h.s.StatementTimeoutFor(h.id); restoreTimeout != nil {
+				restore = *restoreTimeout
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := spec.SetStatementTimeout(ctx, h.s.labelExecer(h.id, conn), restore)
+			cancel()
+
+			// Discard the connection rather than returning it to the pool with the wrong statement timeout
+			if err != nil {
+				fmt.Println("dbLocker conn error:", err.Error())
+				conn.Raw(func(driverConn interface{}) error {
+					return driver.ErrBadConn
+				})
+			}
+		}
+		conn.Close()
+	})
+	return conn, nil
+}
@@ -0,0 +1,50 @@
+package dblocker
+
+import (
+	"context"
+)
+
+type holdKey struct{}
+
+// holdToken identifies a Hold in a context, and links to the token of the Hold (if any) under which the Hold was requested
+type holdToken struct {
+	h      *Hold
+	parent *holdToken
+}
+
+// withHold returns a copy of ctx which carries a token for the Hold, keeping the tokens of the holds already in ctx
+func withHold(ctx context.Context, h *Hold) context.Context {
+	parent, _ := ctx.Value(holdKey{}).(*holdToken)
+	return context.WithValue(ctx, holdKey{}, &holdToken{h: h, parent: parent})
+}
+
+// heldInContext returns the innermost Hold in ctx which has not been released and for which match returns true
+func heldInContext(ctx context.Context, match func(h *Hold) bool) (h *Hold, ok bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	token, _ := ctx.Value(holdKey{}).(*holdToken)
+	for ; token != nil; token = token.parent {
+		if token.h.ctx.Err() == nil && match(token.h) {
+			return token.h, true
+		}
+	}
+	return nil, false
+}
+
+// HoldFromContext returns the innermost Hold of the Store carried by ctx which has not been released, and false if ctx is not under a Hold of the Store.
+// The contexts of holds (see Hold Context and BindContext) carry a token for the Hold, and holds requested using those contexts (or contexts derived from them) also carry the tokens of the enclosing holds,
+// so that deeper layers can discover whether they are already running under a Hold (e.g. to avoid requesting a Hold for an id which is already held, which would wait forever).
+func (s *Store) HoldFromContext(ctx context.Context) (h *Hold, ok bool) {
+	return heldInContext(ctx, func(h *Hold) bool {
+		return h.s == s
+	})
+}
+
+// HoldForID returns the innermost Hold of the Store for the specified id carried by ctx which has not been released, and false if ctx is not under a Hold of the Store for the id (see HoldFromContext)
+func (s *Store) HoldForID(ctx context.Context, id interface{}) (h *Hold, ok bool) {
+	id = s.lockKey(id)
+	return heldInContext(ctx, func(h *Hold) bool {
+		return h.s == s && h.id == id
+	})
+}
@@ -0,0 +1,90 @@
+package dblocker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// HoldSet is a set of Holds acquired as part of one logical operation (e.g. a workflow that writes to several ids), which are released together.
+// The zero HoldSet is ready to use, and a HoldSet is safe for concurrent use.
+type HoldSet struct {
+	mu    sync.Mutex
+	holds []*Hold
+}
+
+// Add adds a Hold to the set, and returns the Hold
+func (hs *HoldSet) Add(h *Hold) *Hold {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.holds = append(hs.holds, h)
+	return h
+}
+
+// Holds returns the Holds in the set, in the order that they were added
+func (hs *HoldSet) Holds() []*Hold {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return append([]*Hold(nil), hs.holds...)
+}
+
+// Release releases the Holds in the set in reverse order, and removes them from the set.
+// Release returns the errors (joined using errors.Join) of the Holds which had already been force released
+// (e.g. by the unlockTimeout or when the Store context was cancelled), as the operation may not have had access to those ids for its whole duration.
+// Holds already released by Release() and Holds transferred to another owner (see Hold.Transfer) are not released again and are not errors.
+func (hs *HoldSet) Release() error {
+	hs.mu.Lock()
+	holds := hs.holds
+	hs.holds = nil
+	hs.mu.Unlock()
+
+	var errs []error
+	for i := len(holds) - 1; i >= 0; i-- {
+		err := holds[i].releaseChecked()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReleaseAll releases every active Hold for the Store with the specified tag (e.g. the holds of a cancelled batch job),
+// including Holds which have been transferred to another owner (see Hold.Transfer).
+// ReleaseAll returns the number of Holds released.
+func (s *Store) ReleaseAll(tag string) int {
+	var holds []*Hold
+	s.queues.Lock()
+	for _, q := range s.queues.m {
+		for h := range q.holds {
+			if h.tag == tag && h.ctx.Err() == nil {
+				holds = append(holds, h)
+			}
+		}
+	}
+	s.queues.Unlock()
+
+	for _, h := range holds {
+		generation := 0
+		if h.owner != nil {
+			h.owner.mu.Lock()
+			generation = h.owner.generation
+			h.owner.mu.Unlock()
+		}
+		h.release(generation)
+	}
+	return len(holds)
+}
+
+// releaseChecked releases the Hold (without reporting misuse if the Hold has already been released),
+// and returns an error if the Hold had already been force released
+func (h *Hold) releaseChecked() error {
+	h.mu.Lock()
+	done := h.ctx.Err() != nil
+	released := h.released
+	h.mu.Unlock()
+	if done && !released {
+		return fmt.Errorf("hold for id %v (tag %q) released before the operation finished: %w", h.id, h.tag, h.Err())
+	}
+	h.release(0)
+	return nil
+}
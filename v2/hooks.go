@@ -0,0 +1,139 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRejected is wrapped by the errors returned for requests rejected by the OnAcquireRequested hook
+var ErrRejected = errors.New("dblocker: request rejected")
+
+// AcquireRequest describes a database access request passed to the OnAcquireRequested hook.
+// The hook can change the Tag (e.g. to add a tenant prefix), the Metadata (e.g. to add attributes), and the Priority (see WithPriority) used for the request.
+// The Metadata Attributes map is shared with the request context, so replace the map (rather than modifying it) to change the attributes.
+type AcquireRequest struct {
+	ID       interface{}
+	Mode     AccessMode
+	Tag      string
+	Metadata Metadata
+	Priority int
+}
+
+// acquireRequested calls the OnAcquireRequested hook (if set) for a request, and returns the request context, tag, and metadata modified by the hook
+func (s *Store) acquireRequested(ctx context.Context, id interface{}, mode AccessMode, tag string, metadata Metadata) (context.Context, string, Metadata, error) {
+	if s.Hooks.OnAcquireRequested == nil {
+		return ctx, tag, metadata, nil
+	}
+	priority := priorityFromContext(ctx)
+	r := &AcquireRequest{
+		ID:       id,
+		Mode:     mode,
+		Tag:      tag,
+		Metadata: metadata,
+		Priority: priority,
+	}
+	err := s.Hooks.OnAcquireRequested(ctx, r)
+	if err != nil {
+		return ctx, tag, metadata, fmt.Errorf("%w: %w", ErrRejected, err)
+	}
+	if r.Priority != priority {
+		ctx = WithPriority(ctx, r.Priority)
+	}
+	ctx = WithMetadata(ctx, r.Metadata)
+	return ctx, r.Tag, r.Metadata, nil
+}
+
+// Hooks are optional functions called by the Store during the lifecycle of database access requests.
+// Hooks are called from goroutines started by the Store, so must be safe for concurrent use and should return quickly.
+type Hooks struct {
+
+	// OnAcquireRequested is called when a database access request is made (before the request is authorized and before it waits for access),
+	// and can reject the request by returning an error (which is returned to the caller wrapped in an error wrapping ErrRejected),
+	// or modify the Tag, Metadata, and Priority of the request (see AcquireRequest).
+	// OnAcquireRequested is called by the goroutine making the request, and can be used to implement policy enforcement points such as maintenance freezes and quota checks.
+	// OnAcquireRequested is not called for ReadPassthrough reads.
+	OnAcquireRequested func(ctx context.Context, r *AcquireRequest) error
+
+	// OnWriteReleased is called whenever a RWGetDB, RWGetDBx, RWGetDBWithTimeout, or RWGetDBxWithTimeout hold is released
+	// (i.e. when the returned cancel() function is called, the unlockTimeout expires, or the Store context is cancelled).
+	// heldFor is the time between the hold being granted and being released.
+	// OnWriteReleased is called before the next request for the id is granted, so must not make requests for the same id.
+	// OnWriteReleased can be used, for example, to invalidate application caches keyed by id.
+	OnWriteReleased func(id interface{}, tag string, heldFor time.Duration)
+
+	// OnReleased is called whenever any hold is released, with an Event describing the request (including its Metadata).
+	// If the Store Sampler is set, OnReleased is only called for sampled Events (see Sampler).
+	OnReleased func(ev Event)
+
+	// OnMaxHoldDuration is called when a hold is force released after the Store MaxHoldDuration, with an Event describing the hold (see OutcomeMaxHoldDuration).
+	// OnMaxHoldDuration is always called, even if the Event is not sampled (see Sampler).
+	OnMaxHoldDuration func(ev Event)
+
+	// OnConnectionLost is called once each time that the shared database session for an id is found to have lost its connection to the database (see ObserveError),
+	// before the holds using the session are force released and the session is reconnected. OnConnectionLost can be used to alert that work may need to be retried.
+	OnConnectionLost func(id interface{}, err error)
+
+	// OnAfterReleaseError is called when a callback registered with Hold.AfterRelease still returns an error after all retries.
+	OnAfterReleaseError func(id interface{}, tag string, err error)
+
+	// OnInvariantViolation is called in Strict mode when a scheduler invariant is violated, with an error wrapping ErrInvariantViolation.
+	// OnInvariantViolation may be called while the Store is locked, so must not call Store functions.
+	OnInvariantViolation func(err error)
+
+	// OnReplicasEvicted is called when every read replica has been evicted from read routing (see the Store Replicas and ReplicaMaxLag settings), with the status of each replica,
+	// after which read holds use the shared database session for the id (the primary) until a replica is restored.
+	OnReplicasEvicted func(statuses []ReplicaStatus)
+
+	// OnStop is called once when the Store is stopped by Stop, after the shared database sessions are closed (or after the Stop context is done),
+	// with a Report summarising the use of the Store since it was started.
+	OnStop func(r Report)
+}
+
+// watchRelease waits for a Hold to be released and then invalidates cached read results and calls the relevant hooks and AfterRelease callbacks.
+// For RW holds, cached read results are invalidated and OnWriteReleased is called before the group grants the next request for the id.
+func (s *Store) watchRelease(h *Hold) {
+	<-h.ctx.Done()
+	heldFor := time.Since(h.grantedAt)
+
+	h.mu.Lock()
+	onRelease := h.onRelease
+	h.mu.Unlock()
+	for _, fn := range onRelease {
+		fn()
+	}
+
+	switch h.accessType {
+	case "rw", "rwseparate":
+		if s.Cache != nil {
+			s.Cache.Invalidate(h.id)
+		}
+		if s.Hooks.OnWriteReleased != nil {
+			s.Hooks.OnWriteReleased(h.id, h.tag, heldFor)
+		}
+	default:
+	}
+	close(h.hooksDone)
+
+	ev, sampled := s.recordRelease(h)
+	if sampled && s.Hooks.OnReleased != nil {
+		s.Hooks.OnReleased(ev)
+	}
+	if ev.Outcome == OutcomeMaxHoldDuration {
+		s.reportMaxHold(h, ev)
+	}
+
+	// Close the new database session of RWGetDBWithTimeout holds
+	if h.accessType == "rwseparate" {
+		s.closeDB(h.db)
+	}
+
+	switch h.accessType {
+	case "rw", "rwseparate":
+		if h.releasedOK() {
+			s.enqueueAfterRelease(h)
+		}
+	default:
+	}
+}
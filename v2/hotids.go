@@ -0,0 +1,219 @@
+package dblocker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHotIDCount is the number of recently active ids saved to the Store HotIDs if the Store HotIDCount is zero
+const DefaultHotIDCount = 100
+
+// hotIDWarmConcurrency is the number of ids connected at the same time by WarmHotIDs
+const hotIDWarmConcurrency = 4
+
+// HotIDStore saves the ids that were recently active when a Store is stopped, and loads them when the next Store is started (see WarmHotIDs),
+// so that the shared database sessions for the most active ids can be connected before requests arrive (e.g. after a deploy).
+// Ids are encoded using the Store IDCodec, and are ordered with the most recently active id first.
+type HotIDStore interface {
+	LoadHotIDs(ctx context.Context) (encodedIDs []string, err error)
+	SaveHotIDs(ctx context.Context, encodedIDs []string) error
+}
+
+// HotIDFile returns a HotIDStore which saves the ids to the named file, with one encoded id on each line.
+// The file is replaced atomically when the ids are saved, and a missing file loads no ids.
+func HotIDFile(name string) HotIDStore {
+	return hotIDFile{name: name}
+}
+
+type hotIDFile struct {
+	name string
+}
+
+// LoadHotIDs reads the encoded ids from the file
+func (f hotIDFile) LoadHotIDs(ctx context.Context) (encodedIDs []string, err error) {
+	file, err := os.Open(f.name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			encodedIDs = append(encodedIDs, line)
+		}
+	}
+	return encodedIDs, scanner.Err()
+}
+
+// SaveHotIDs writes the encoded ids to a temporary file, and then renames the temporary file to the file
+func (f hotIDFile) SaveHotIDs(ctx context.Context, encodedIDs []string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.name), filepath.Base(f.name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, encodedID := range encodedIDs {
+		w.WriteString(encodedID)
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.name)
+}
+
+// hotIDs are the times that recently active ids were last granted access, which are only recorded if the Store HotIDs is set
+type hotIDs struct {
+	sync.Mutex
+
+	m map[interface{}]time.Time
+}
+
+// hotIDCount returns the number of recently active ids saved to the Store HotIDs
+func (s *Store) hotIDCount() int {
+	if s.HotIDCount > 0 {
+		return s.HotIDCount
+	}
+	return DefaultHotIDCount
+}
+
+// recordHotID records that access to an id was granted if the Store HotIDs is set.
+// The least recently active ids are forgotten once twice the HotIDCount ids have been recorded.
+func (s *Store) recordHotID(id interface{}) {
+	if s.HotIDs == nil {
+		return
+	}
+	now := time.Now()
+
+	s.hotIDs.Lock()
+	defer s.hotIDs.Unlock()
+
+	if s.hotIDs.m == nil {
+		s.hotIDs.m = make(map[interface{}]time.Time)
+	}
+	s.hotIDs.m[id] = now
+	if count := s.hotIDCount(); len(s.hotIDs.m) >= 2*count {
+		for _, id := range s.hotIDsLocked()[count:] {
+			delete(s.hotIDs.m, id)
+		}
+	}
+}
+
+// hotIDsLocked returns the recorded ids, most recently active first.
+// The hotIDs must be locked when hotIDsLocked is called.
+func (s *Store) hotIDsLocked() []interface{} {
+	ids := make([]interface{}, 0, len(s.hotIDs.m))
+	for id := range s.hotIDs.m {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b interface{}) int {
+		return s.hotIDs.m[b].Compare(s.hotIDs.m[a])
+	})
+	return ids
+}
+
+// SaveHotIDs saves the HotIDCount most recently active ids to the Store HotIDs (see HotIDStore).
+// SaveHotIDs is called by Stop when the Store HotIDs is set, and can also be called periodically (e.g. in case the process is killed).
+// Ids which cannot be encoded using the Store IDCodec are skipped.
+func (s *Store) SaveHotIDs(ctx context.Context) error {
+	if s.HotIDs == nil {
+		return fmt.Errorf("hot ids error: no HotIDStore")
+	}
+	s.hotIDs.Lock()
+	ids := s.hotIDsLocked()
+	s.hotIDs.Unlock()
+	if count := s.hotIDCount(); len(ids) > count {
+		ids = ids[:count]
+	}
+
+	encodedIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		encodedID, err := s.EncodeID(id)
+		if err != nil {
+			continue
+		}
+		encodedIDs = append(encodedIDs, encodedID)
+	}
+	err := s.HotIDs.SaveHotIDs(ctx, encodedIDs)
+	if err != nil {
+		return fmt.Errorf("hot ids error: %w", err)
+	}
+	return nil
+}
+
+// WarmHotIDs loads the ids saved by the previous Store from the Store HotIDs (see HotIDStore), and connects the shared database session for each id
+// (a few ids at a time, most recently active first) using a read hold with the "warm" tag, so that the first requests for the most active ids do not all connect at the same time.
+// The shared database sessions are then closed according to the Store TeardownPolicy, so WarmHotIDs is most useful with the TeardownLinger and TeardownNever policies.
+// WarmHotIDs returns the number of ids warmed, and the errors for ids which could not be decoded or connected (joined, see errors.Join).
+// WarmHotIDs stops when ctx is done.
+func (s *Store) WarmHotIDs(ctx context.Context) (warmed int, err error) {
+	if s.HotIDs == nil {
+		return 0, fmt.Errorf("hot ids error: no HotIDStore")
+	}
+	encodedIDs, err := s.HotIDs.LoadHotIDs(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("hot ids error: %w", err)
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, hotIDWarmConcurrency)
+	for _, encodedID := range encodedIDs {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			id, err := s.DecodeID(encodedID)
+			if err == nil {
+				var h *Hold
+				h, err = s.ReadHold(id, ctx, "warm")
+				if err == nil {
+					h.Release()
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("hot ids error: %s: %w", encodedID, err))
+				return
+			}
+			warmed++
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		errs = append(errs, ctx.Err())
+	}
+	return warmed, errors.Join(errs...)
+}
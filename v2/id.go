@@ -0,0 +1,88 @@
+package dblocker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ID is a composite id made from a list of parts (e.g. IDOf("org", 12, "db", 3)), which can be used as the id for any Store request.
+// IDs with the same parts are equal (and so refer to the same lock), and integer parts are equal regardless of their integer type.
+// IDs are printed readably in stats and logs (e.g. org/12/db/3), with string parts quoted where required to keep the printed form unique.
+type ID struct {
+	key string
+}
+
+// IDOf returns the composite ID for the parts.
+// Parts are usually strings and integers, and other values are formatted using their type and fmt "%v" formatting.
+func IDOf(parts ...interface{}) ID {
+	var b strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		b.WriteString(idPart(part))
+	}
+	return ID{key: b.String()}
+}
+
+// String returns the readable form of the ID (e.g. org/12/db/3)
+func (id ID) String() string {
+	return id.key
+}
+
+// MarshalText encodes the ID using its readable form
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.key), nil
+}
+
+// idPart formats a single part of a composite ID
+func idPart(part interface{}) string {
+	switch v := part.(type) {
+	case string:
+		if idPartNeedsQuote(v) {
+			return strconv.Quote(v)
+		}
+		return v
+	case int:
+		return strconv.FormatInt(int64(v), 10)
+	case int8:
+		return strconv.FormatInt(int64(v), 10)
+	case int16:
+		return strconv.FormatInt(int64(v), 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case ID:
+		return "(" + v.key + ")"
+	default:
+		return fmt.Sprintf("%T(%v)", part, part)
+	}
+}
+
+// idPartNeedsQuote returns true if a string part must be quoted so that it can not be confused with a separator, another type of part, or another string
+func idPartNeedsQuote(v string) bool {
+	if v == "" || v == "true" || v == "false" {
+		return true
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return true
+	}
+	if _, err := strconv.ParseUint(v, 10, 64); err == nil {
+		return true
+	}
+	return strings.ContainsAny(v, "/\"()\\") || strings.IndexFunc(v, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0
+}
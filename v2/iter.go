@@ -0,0 +1,155 @@
+package dblocker
+
+import (
+	"iter"
+	"time"
+)
+
+// eventsChunkSize is the number of Events copied from the recent events buffer at a time by Events
+const eventsChunkSize = 64
+
+// GroupInfo describes an id with a group (i.e. with a shared database session or waiting to connect one)
+type GroupInfo struct {
+	ID interface{}
+
+	// Connected is true if the shared database session for the id is connected
+	Connected bool
+
+	// Waiting is the number of requests waiting for access to the database for the id, and Holds is the number of granted Holds for the id
+	Waiting int
+	Holds   int
+}
+
+// WaiterInfo describes a request waiting for access to the database for an id
+type WaiterInfo struct {
+	ID   interface{}
+	Tag  string
+	Mode AccessMode
+
+	// Position is the position of the request in the queue for the id (1 means that the request is next), and WaitingSince is when the request started waiting
+	Position     int
+	WaitingSince time.Time
+}
+
+// Groups returns an iterator over the ids with a group, in no particular order.
+// Only the ids are copied when iteration starts, and the GroupInfo for each id is read when the id is reached, so Groups can be used with a large number of ids.
+// Ids whose group is deleted before they are reached are skipped. The Store is not locked while the loop body runs, so the loop body can make requests using the Store.
+func (s *Store) Groups() iter.Seq[GroupInfo] {
+	return func(yield func(GroupInfo) bool) {
+		s.Lock()
+		ids := make([]interface{}, 0, len(s.m))
+		for id := range s.m {
+			ids = append(ids, id)
+		}
+		s.Unlock()
+
+		for _, id := range ids {
+			s.Lock()
+			g, ok := s.m[id]
+			connected := ok && g.DB != nil
+			s.Unlock()
+			if !ok {
+				continue
+			}
+			info := GroupInfo{ID: id, Connected: connected}
+			s.queues.Lock()
+			if q, ok := s.queues.m[id]; ok {
+				info.Waiting = len(q.waiters)
+				info.Holds = len(q.holds)
+			}
+			s.queues.Unlock()
+			if !yield(info) {
+				return
+			}
+		}
+	}
+}
+
+// Waiters returns an iterator over the requests waiting for access to the database, in queue order for each id (and in no particular order across ids).
+// The queue for each id is copied when the id is reached, so requests granted before their id is reached are skipped.
+// Waiters composes with Filter, for example to find the requests with a tag that have been waiting for more than a minute.
+func (s *Store) Waiters() iter.Seq[WaiterInfo] {
+	return func(yield func(WaiterInfo) bool) {
+		s.queues.Lock()
+		ids := make([]interface{}, 0, len(s.queues.m))
+		for id := range s.queues.m {
+			ids = append(ids, id)
+		}
+		s.queues.Unlock()
+
+		var infos []WaiterInfo
+		for _, id := range ids {
+			infos = infos[:0]
+			s.queues.Lock()
+			if q, ok := s.queues.m[id]; ok {
+				for i, w := range q.waiters {
+					infos = append(infos, WaiterInfo{
+						ID:           id,
+						Tag:          w.tag,
+						Mode:         w.mode,
+						Position:     i + 1,
+						WaitingSince: w.since,
+					})
+				}
+			}
+			s.queues.Unlock()
+			for _, info := range infos {
+				if !yield(info) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Events returns an iterator over the recent completed database access requests (see RecentEvents), oldest first.
+// Events are copied from the recent events buffer a few at a time, so the whole buffer is not copied.
+// Only the Events recorded before iteration starts are included, and Events overwritten in the buffer before they are reached are skipped.
+func (s *Store) Events() iter.Seq[Event] {
+	return func(yield func(Event) bool) {
+		e := &s.events
+		e.Lock()
+		end := e.recorded
+		next := end - uint64(e.filled())
+		e.Unlock()
+
+		chunk := make([]Event, 0, eventsChunkSize)
+		for next < end {
+			chunk = chunk[:0]
+			e.Lock()
+			if oldest := e.recorded - uint64(e.filled()); next < oldest {
+				next = oldest
+			}
+			for ; next < end && len(chunk) < eventsChunkSize; next++ {
+				back := int(e.recorded - next)
+				chunk = append(chunk, e.buf[(e.next-back+len(e.buf))%len(e.buf)])
+			}
+			e.Unlock()
+			for _, ev := range chunk {
+				if !yield(ev) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// filled returns the number of Events in the buffer.
+// The events must be locked when filled is called.
+func (e *events) filled() int {
+	if e.full {
+		return len(e.buf)
+	}
+	return e.next
+}
+
+// Filter returns an iterator over the values of seq for which keep returns true (e.g. to filter the Store Waiters by tag or by wait time)
+func Filter[T any](seq iter.Seq[T], keep func(T) bool) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for v := range seq {
+			if keep(v) && !yield(v) {
+				return
+			}
+		}
+	}
+}
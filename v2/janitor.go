@@ -0,0 +1,142 @@
+package dblocker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// JanitorReport describes the inconsistent groups found and repaired by a janitor pass (see CheckGroups)
+type JanitorReport struct {
+
+	// OrphanedGroups are the ids of groups which were still in the Store after the group goroutine had exited, and which were removed so that new requests for the ids start new groups
+	OrphanedGroups []interface{}
+
+	// RequestCounts are the ids of groups whose request count was negative, or was positive for two janitor passes while no requests for the id were waiting or granted,
+	// and whose request count was reset so that the groups can be deleted when unused (see TeardownPolicy)
+	RequestCounts []interface{}
+}
+
+// empty returns true if no groups were repaired
+func (r JanitorReport) empty() bool {
+	return len(r.OrphanedGroups) == 0 && len(r.RequestCounts) == 0
+}
+
+// janitor is the state kept between janitor passes
+type janitor struct {
+	sync.Mutex
+
+	// suspects are the request counts of groups which had requests counted while no requests were waiting or granted in the last pass
+	suspects map[*Group]int64
+}
+
+// CheckGroups validates the groups of the Store against the group goroutines and the waiting and granted requests, and repairs inconsistent groups
+// (e.g. groups left in the Store, or request counts which drifted, when creating a group races a failing connection attempt and a cancelled request).
+// Each repair is reported as an invariant violation (see Hooks OnInvariantViolation) and counted in Stats.
+// The requests counted by a repaired group are removed from the request counts (see Stats Requests), and are not removed again if they are later released.
+// Request counts are only reset if they are unexplained for two consecutive passes, so that requests which are between the queue and the group are not mistaken for leaks.
+// CheckGroups is called periodically by RunJanitor, and can also be called directly (e.g. from an admin endpoint).
+func (s *Store) CheckGroups() JanitorReport {
+	s.janitor.Lock()
+	defer s.janitor.Unlock()
+
+	// Count the waiting and granted requests for each id
+	active := make(map[interface{}]int)
+	s.queues.Lock()
+	for id, q := range s.queues.m {
+		active[id] = len(q.waiters) + len(q.holds)
+	}
+	s.queues.Unlock()
+
+	var report JanitorReport
+	var unused []*Group
+	suspects := make(map[*Group]int64)
+
+	s.Lock()
+	for id, g := range s.m {
+
+		// Remove groups whose goroutine has exited, and notify the requests waiting for the group so that they retry with a new group
+		select {
+		case <-g.exited:
+			s.invariantViolation(id, "group goroutine has exited but the group was not deleted")
+			select {
+			case <-g.done:
+			default:
+				close(g.done)
+			}
+			delete(s.m, id)
+			s.writeOffRequests(g)
+			s.stats.repairedGroups.Add(1)
+			s.observeGroup(id, 0, len(s.m))
+			report.OrphanedGroups = append(report.OrphanedGroups, id)
+			continue
+		default:
+		}
+
+		switch {
+		case g.requestCount < 0:
+			s.invariantViolation(id, "request count is negative: %d", g.requestCount)
+		case g.requestCount > 0 && g.DB != nil && active[id] == 0 && s.passthroughs == 0:
+			if s.janitor.suspects[g] != g.requestCount {
+				suspects[g] = g.requestCount
+				continue
+			}
+			s.invariantViolation(id, "request count is %d but no requests are waiting or granted", g.requestCount)
+		default:
+			continue
+		}
+		s.writeOffRequests(g)
+		s.stats.repairedRequestCounts.Add(1)
+		s.observeGroup(id, 0, len(s.m))
+		report.RequestCounts = append(report.RequestCounts, id)
+		unused = append(unused, g)
+	}
+	s.Unlock()
+	s.janitor.suspects = suspects
+
+	// Notify the repaired groups so that they can be deleted if they are unused
+	for _, g := range unused {
+		select {
+		case g.passthroughDoneCh <- struct{}{}:
+		default:
+		}
+	}
+	return report
+}
+
+// writeOffRequests removes the requests counted by a Group from the request counts, and starts a new request count epoch so that releaseGroup does not decrement the request counts again when those requests are released.
+// The Store must be locked when writeOffRequests is called.
+func (s *Store) writeOffRequests(g *Group) {
+	s.stats.requests.Add(-g.requestCount)
+	g.requestCount = 0
+	g.epoch++
+}
+
+// RunJanitor calls CheckGroups every interval (or every minute if interval is not positive) until ctx or the Store context is done,
+// and calls onRepair (if not nil) with the report of each pass which repaired any groups.
+func (s *Store) RunJanitor(ctx context.Context, interval time.Duration, onRepair func(report JanitorReport)) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	storeCtx := s.storeCtx()
+	ticker := time.NewTicker(interval)
+	s.spawn("janitor", func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-storeCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			report := s.CheckGroups()
+			if onRepair != nil && !report.empty() {
+				onRepair(report)
+			}
+		}
+	})
+}
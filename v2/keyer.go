@@ -0,0 +1,16 @@
+package dblocker
+
+// Key is the id used for requests when the Store Keyer is set (see Keyer)
+type Key string
+
+// lockKey returns the id used for the lock and Store state of an id, which is the Key returned by the Store Keyer if the Keyer is set.
+// Keys are returned unchanged, so lockKey can be called more than once for the same id.
+func (s *Store) lockKey(id interface{}) interface{} {
+	if s.Keyer == nil {
+		return id
+	}
+	if key, ok := id.(Key); ok {
+		return key
+	}
+	return Key(s.Keyer(id))
+}
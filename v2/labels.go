@@ -0,0 +1,64 @@
+package dblocker
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+// ShardLabel returns a LabelMapper (see the Store LabelMapper setting) which buckets ids into n shards using a hash of the id formatted using fmt "%v",
+// returning labels from "shard-0" to "shard-<n-1>"
+func ShardLabel(n int) func(id interface{}) string {
+	if n < 1 {
+		n = 1
+	}
+	return func(id interface{}) string {
+		h := fnv.New32a()
+		fmt.Fprint(h, id)
+		return fmt.Sprintf("shard-%d", h.Sum32()%uint32(n))
+	}
+}
+
+// metricLabels sums the waiting requests for the ids with each label when the Store LabelMapper is set
+type metricLabels struct {
+	sync.Mutex
+	waiting map[interface{}]int64
+	totals  map[string]int64
+}
+
+// metricsID returns the id (or the LabelMapper label for the id) sent to the Store MetricsSink
+func (s *Store) metricsID(id interface{}) interface{} {
+	if s.LabelMapper == nil {
+		return id
+	}
+	return s.LabelMapper(id)
+}
+
+// setWaiting sends the number of waiting requests for an id to the Store MetricsSink,
+// or the total number of waiting requests for the ids with the same label if the Store LabelMapper is set
+func (s *Store) setWaiting(id interface{}, waiting int64) {
+	if s.LabelMapper == nil {
+		s.MetricsSink.SetWaiting(id, int(waiting))
+		return
+	}
+	label := s.LabelMapper(id)
+
+	s.labels.Lock()
+	defer s.labels.Unlock()
+	if s.labels.waiting == nil {
+		s.labels.waiting = make(map[interface{}]int64)
+		s.labels.totals = make(map[string]int64)
+	}
+	total := s.labels.totals[label] + waiting - s.labels.waiting[id]
+	if waiting == 0 {
+		delete(s.labels.waiting, id)
+	} else {
+		s.labels.waiting[id] = waiting
+	}
+	if total == 0 {
+		delete(s.labels.totals, label)
+	} else {
+		s.labels.totals[label] = total
+	}
+	s.MetricsSink.SetWaiting(label, int(total))
+}
@@ -0,0 +1,139 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// storeRun is a single run of the Store between Start and Stop
+type storeRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// groups are the group goroutines started during the run
+	groups sync.WaitGroup
+
+	// usage counts the requests during the run (see Report)
+	usage runUsage
+
+	// replicas are the read replicas connected during the run (see the Store Replicas setting)
+	replicas replicaSet
+}
+
+// closedCtx is the Store context when the Store is stopped
+var closedCtx = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// Start starts the Store using a context derived from ctx.
+// Stores created by New (and the other constructors) with a non-nil context are already started, and Stores created with a nil context must be started before making any database access requests.
+// A stopped Store can be started again, keeping its Hooks and other settings.
+// Start returns an error if the Store is already started.
+func (s *Store) Start(ctx context.Context) error {
+	if ctx == nil {
+		return fmt.Errorf("start error: nil context")
+	}
+
+	s.ctxMu.Lock()
+	defer s.ctxMu.Unlock()
+
+	if s.run != nil && s.run.ctx.Err() == nil {
+		return fmt.Errorf("start error: store already started")
+	}
+	run := &storeRun{}
+	run.usage.startedAt = time.Now()
+	run.ctx, run.cancel = context.WithCancel(ctx)
+	s.run = run
+	s.Ctx = run.ctx
+	return nil
+}
+
+// Stop cancels the Store context (see ErrStoreClosed), and waits until the shared database sessions for all ids are closed or until ctx is done.
+// Stop first saves the recently active ids to the Store HotIDs (if set, see SaveHotIDs), and then calls Hooks.OnStop (if set) with a Report for the run once stopped.
+// Stop returns nil if the Store is not started.
+func (s *Store) Stop(ctx context.Context) (err error) {
+	s.ctxMu.Lock()
+	run := s.run
+	s.ctxMu.Unlock()
+	if run == nil {
+		return nil
+	}
+	run.usage.Lock()
+	first := run.usage.stoppedAt.IsZero()
+	if first {
+		run.usage.stoppedAt = time.Now()
+	}
+	run.usage.Unlock()
+	if first && s.HotIDs != nil {
+		if err := s.SaveHotIDs(ctx); err != nil {
+			fmt.Println("dbLocker stop error:", err.Error())
+		}
+	}
+
+	// Cancel the run while holding the lock which startRunGroup holds while adding groups, so that no groups are added once the run is cancelled
+	s.ctxMu.Lock()
+	run.cancel()
+	s.ctxMu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		run.groups.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if first && s.Hooks.OnStop != nil {
+		s.Hooks.OnStop(s.runReport(run))
+	}
+	return err
+}
+
+// storeCtx returns the context for the current run of the Store, or a cancelled context if the Store has not been started
+func (s *Store) storeCtx() context.Context {
+	s.ctxMu.RLock()
+	defer s.ctxMu.RUnlock()
+
+	if s.run == nil {
+		return closedCtx
+	}
+	return s.run.ctx
+}
+
+// currentRun returns the current run of the Store, or a cancelled run if the Store has not been started
+func (s *Store) currentRun() *storeRun {
+	s.ctxMu.RLock()
+	defer s.ctxMu.RUnlock()
+
+	if s.run == nil {
+		return &storeRun{ctx: closedCtx, cancel: func() {}}
+	}
+	return s.run
+}
+
+// startRunGroup adds a group goroutine to the current run of the Store (see Stop), and returns ErrStoreClosed instead if the Store has not been started or the run has been cancelled,
+// so that groups are not added to the run after Stop has started waiting for the group goroutines of the run to return
+func (s *Store) startRunGroup() (*storeRun, error) {
+	s.ctxMu.RLock()
+	defer s.ctxMu.RUnlock()
+
+	if s.run == nil || s.run.ctx.Err() != nil {
+		return nil, ErrStoreClosed
+	}
+	s.run.groups.Add(1)
+	return s.run, nil
+}
+
+// waitError returns ErrStoreClosed if the Store context is cancelled, or otherwise the request context error
+func waitError(storeCtx context.Context, ctx context.Context) error {
+	if storeCtx.Err() != nil {
+		return ErrStoreClosed
+	}
+	return context.Cause(ctx)
+}
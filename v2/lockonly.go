@@ -0,0 +1,63 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrLockOnly is returned by statements and transactions on the database handles of lock-only Stores (see the "lockonly" database type)
+var ErrLockOnly = errors.New("dblocker: lock-only store has no database")
+
+func init() {
+
+	// The "lockonly" (or "none") database type does not connect to a database, so that the Store is only a per-id RW lock manager.
+	// Requests wait for and hold access to ids as usual (including the unlockTimeout), and can be used to serialize access to non-SQL resources (e.g. files or caches).
+	// The returned database handles are not nil, but statements and transactions return ErrLockOnly.
+	lockOnly := DriverSpec{
+		Connect: func(ctx context.Context, driverName, dataSourceName string) (db *sqlx.DB, err error) {
+			return sqlx.NewDb(sql.OpenDB(lockOnlyConnector{}), driverName), nil
+		},
+	}
+	RegisterDriver("lockonly", lockOnly)
+	RegisterDriver("none", lockOnly)
+}
+
+// lockOnlyConnector is a database/sql connector for connections which do not connect to a database
+type lockOnlyConnector struct{}
+
+func (c lockOnlyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return lockOnlyConn{}, nil
+}
+
+func (c lockOnlyConnector) Driver() driver.Driver {
+	return lockOnlyDriver{}
+}
+
+type lockOnlyDriver struct{}
+
+func (d lockOnlyDriver) Open(name string) (driver.Conn, error) {
+	return lockOnlyConn{}, nil
+}
+
+// lockOnlyConn is a database/sql connection which returns ErrLockOnly for all statements and transactions, and which can always be pinged
+type lockOnlyConn struct{}
+
+func (c lockOnlyConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, ErrLockOnly
+}
+
+func (c lockOnlyConn) Close() error {
+	return nil
+}
+
+func (c lockOnlyConn) Begin() (driver.Tx, error) {
+	return nil, ErrLockOnly
+}
+
+func (c lockOnlyConn) Ping(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MaintenanceDB returns the maintenance database session for the specified id (see the Store MaintenanceConn setting),
+// and false if the id has no group or the maintenance session has not (yet) been connected.
+// Use the maintenance session for health checks, stats queries (e.g. pg_stat_activity), and cancellation commands,
+// so that these do not wait for (or delay) the holds for the id using the shared database session.
+// Do not close the returned database session, and do not use it to read or write application data, as no access to the id is held.
+func (s *Store) MaintenanceDB(id interface{}) (db *sqlx.DB, ok bool) {
+	id = s.lockKey(id)
+	s.Lock()
+	defer s.Unlock()
+	g, ok := s.m[id]
+	if !ok || g.maintenanceDB == nil {
+		return nil, false
+	}
+	return g.maintenanceDB, true
+}
+
+// maintenanceDBFor returns the maintenance database session for the specified id if connected, or otherwise db
+func (s *Store) maintenanceDBFor(id interface{}, db *sqlx.DB) *sqlx.DB {
+	if maintenanceDB, ok := s.MaintenanceDB(id); ok {
+		return maintenanceDB
+	}
+	return db
+}
+
+// startMaintenanceDB connects the maintenance database session for a group in the background if the Store MaintenanceConn setting is true,
+// so that requests for the id are not delayed while the maintenance session connects.
+// The maintenance session is limited to a single connection, and is not retried if the connection fails.
+func (s *Store) startMaintenanceDB(ctx context.Context, id interface{}, g *Group, r ConnectRequest) {
+	if !s.MaintenanceConn {
+		return
+	}
+	s.Lock()
+	g.maintenanceGeneration++
+	generation := g.maintenanceGeneration
+	s.Unlock()
+
+	s.spawn("maintenance", func() {
+		db, err := s.connectDB(ctx, r)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println("dbLocker maintenance connection error:", err.Error())
+			}
+			return
+		}
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+
+		// Close the session if the group was deleted or reconnected while connecting
+		s.Lock()
+		select {
+		case <-g.done:
+		default:
+			if g.maintenanceGeneration == generation {
+				g.maintenanceDB = db
+				s.Unlock()
+				return
+			}
+		}
+		s.Unlock()
+		s.closeDB(db)
+	})
+}
+
+// closeMaintenanceDB closes the maintenance database session for a group (if any) in the background, and stops any maintenance session that is connecting.
+// The Store must be locked when closeMaintenanceDB is called.
+func (s *Store) closeMaintenanceDB(g *Group) {
+	g.maintenanceGeneration++
+	db := g.maintenanceDB
+	g.maintenanceDB = nil
+	if db != nil {
+		s.spawn("cleanup", func() { s.closeDB(db) })
+	}
+}
@@ -0,0 +1,125 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MapProgress describes the progress of a MapIDs call after an id has been processed
+type MapProgress struct {
+
+	// ID is the id that was processed, and Err is the error for the id (nil if fn succeeded)
+	ID  interface{}
+	Err error
+
+	// Done is the number of ids processed (including failed ids), Failed is the number of failed ids, and Total is the number of ids
+	Done   int
+	Failed int
+	Total  int
+}
+
+type mapProgressKey struct{}
+
+// WithMapProgress returns a context which reports the progress of MapIDs calls made using the context to onProgress after each id is processed.
+// onProgress is called from one goroutine at a time, in the order that the ids are processed.
+func WithMapProgress(ctx context.Context, onProgress func(p MapProgress)) context.Context {
+	return context.WithValue(ctx, mapProgressKey{}, onProgress)
+}
+
+// MapError is returned by MapIDs when any id fails, and lists the failed ids and their errors in the order of the ids
+type MapError struct {
+	IDs    []interface{}
+	Errors []error
+	Total  int
+}
+
+func (e *MapError) Error() string {
+	return fmt.Sprintf("map error: %d of %d ids failed (first id %v: %v)", len(e.IDs), e.Total, e.IDs[0], e.Errors[0])
+}
+
+// Unwrap returns the errors of the failed ids, so that errors.Is and errors.As check each error
+func (e *MapError) Unwrap() []error {
+	return e.Errors
+}
+
+// MapIDs calls fn for each of the ids, with up to parallelism (at least 1) calls running concurrently, each while holding access to its id using the access mode
+// (AccessRW, AccessRWSeparate, AccessRead, or AccessStream), and waits for the calls to return.
+// Each call is made in the same way as GoWithRW, so fn is called with a context which is cancelled if the hold is force released and with the database session of the hold.
+// Ids which have not been started when ctx is done fail with the ctx error.
+// The requests use the Metadata of ctx (if any, see WithMetadata) as the tag, and progress is reported to the function set using WithMapProgress (if any).
+// MapIDs returns a *MapError listing the errors for each failed id (including errors acquiring the holds), or nil if fn succeeded for every id.
+func (s *Store) MapIDs(ctx context.Context, ids []interface{}, mode AccessMode, parallelism int, fn func(ctx context.Context, id interface{}, db *sqlx.DB) error) error {
+	var request func(id interface{}, ctx context.Context, tag string) (*Hold, error)
+	switch mode {
+	case AccessRW:
+		request = s.RWHold
+	case AccessRWSeparate:
+		request = func(id interface{}, ctx context.Context, tag string) (*Hold, error) {
+			return s.RWHoldWithTimeout(id, ctx, tag, nil)
+		}
+	case AccessRead:
+		request = s.ReadHold
+	case AccessStream:
+		request = s.StreamHold
+	default:
+		return fmt.Errorf("map error: unknown access mode: %s", mode)
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	onProgress, _ := ctx.Value(mapProgressKey{}).(func(p MapProgress))
+
+	errs := make([]error, len(ids))
+	var mu sync.Mutex
+	progress := MapProgress{Total: len(ids)}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < parallelism && worker < len(ids); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				id := ids[i]
+				err := ctx.Err()
+				if err == nil {
+					err = s.runWithHold(id, ctx, "", request, func(ctx context.Context, db *sqlx.DB) error {
+						return fn(ctx, id, db)
+					})
+				}
+
+				mu.Lock()
+				errs[i] = err
+				progress.ID = id
+				progress.Err = err
+				progress.Done++
+				if err != nil {
+					progress.Failed++
+				}
+				if onProgress != nil {
+					onProgress(progress)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range ids {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	mapErr := &MapError{Total: len(ids)}
+	for i, err := range errs {
+		if err != nil {
+			mapErr.IDs = append(mapErr.IDs, ids[i])
+			mapErr.Errors = append(mapErr.Errors, err)
+		}
+	}
+	if len(mapErr.IDs) > 0 {
+		return mapErr
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrMaxHoldDuration is the cause of the Hold context (and of the error returned by Hold.Err) when a Hold is force released after the Store MaxHoldDuration.
+// Errors wrapping ErrMaxHoldDuration also wrap context.DeadlineExceeded.
+var ErrMaxHoldDuration = errors.New("dblocker: max hold duration exceeded")
+
+// errMaxHoldDuration is the cause of the Hold context when the MaxHoldDuration expires
+var errMaxHoldDuration = fmt.Errorf("%w: %w", ErrMaxHoldDuration, context.DeadlineExceeded)
+
+// maxHoldDuration returns the MaxHoldDuration for an id (see MaxHoldDurationFor), where zero means no cap
+func (s *Store) maxHoldDuration(id interface{}) time.Duration {
+	if s.MaxHoldDurationFor != nil {
+		if maxHold := s.MaxHoldDurationFor(id); maxHold != 0 {
+			return maxHold
+		}
+	}
+	return s.MaxHoldDuration
+}
+
+// withMaxHold returns a context which is cancelled with the errMaxHoldDuration cause once maxHold has passed after start is called
+// (i.e. the cap is measured from when the Hold is granted, not from when the request was made).
+// stop releases the context, and must be called when the Hold context is done.
+func withMaxHold(parent context.Context, maxHold time.Duration) (ctx context.Context, start func(), stop func()) {
+	if maxHold <= 0 {
+		return parent, func() {}, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	var mu sync.Mutex
+	var timer *time.Timer
+	stopped := false
+	start = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !stopped {
+			timer = time.AfterFunc(maxHold, func() { cancel(errMaxHoldDuration) })
+		}
+	}
+	stop = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		if timer != nil {
+			timer.Stop()
+		}
+		cancel(nil)
+	}
+	return ctx, start, stop
+}
+
+// reportMaxHold reports a Hold force released after the MaxHoldDuration (see OutcomeMaxHoldDuration),
+// and recycles the shared database session for the id if the Store RecycleOnMaxHold setting is true
+func (s *Store) reportMaxHold(h *Hold, ev Event) {
+	fmt.Printf("dbLocker max hold duration error: hold for id %v (tag %q, %s) force released after %v\n", h.id, h.tag, h.accessType, ev.Hold)
+	if stack := h.ownerStack(); stack != "" {
+		fmt.Println(stack)
+	}
+	if s.Hooks.OnMaxHoldDuration != nil {
+		s.Hooks.OnMaxHoldDuration(ev)
+	}
+	if !s.RecycleOnMaxHold || h.accessType == "rwseparate" {
+		return
+	}
+
+	// Reconnect using the same data source name once the other holds for the id are released, so that the leaked holder can no longer use the shared session
+	storeCtx := s.storeCtx()
+	s.Lock()
+	dataSourceName := s.dataSourceNames[h.id]
+	s.Unlock()
+	s.spawn("recycle", func() {
+		err := s.Reconnect(storeCtx, h.id, dataSourceName)
+		if err != nil && storeCtx.Err() == nil {
+			fmt.Println("dbLocker max hold duration recycle error:", err.Error())
+		}
+	})
+}
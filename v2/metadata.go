@@ -0,0 +1,47 @@
+package dblocker
+
+import (
+	"context"
+	"strings"
+)
+
+// Metadata is structured information about a database access request.
+// Metadata is added to a request using WithMetadata, and is available from the Hold and recent Events for the request.
+type Metadata struct {
+	Component  string
+	Operation  string
+	RequestID  string
+	Principal  string
+	Attributes map[string]string
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx carrying the Metadata for database access requests made using the returned context
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// MetadataFromContext returns the Metadata carried by ctx
+func MetadataFromContext(ctx context.Context) (md Metadata, ok bool) {
+	md, ok = ctx.Value(metadataKey{}).(Metadata)
+	return md, ok
+}
+
+// String returns the Metadata component and operation (e.g. "billing.export"), which is used as the tag for requests made with an empty tag
+func (md Metadata) String() string {
+	parts := make([]string, 0, 2)
+	for _, part := range []string{md.Component, md.Operation} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Metadata returns the Metadata of the request for the Hold
+func (h *Hold) Metadata() Metadata {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.metadata
+}
@@ -0,0 +1,65 @@
+package dblocker
+
+import (
+	_ "embed"
+	"time"
+)
+
+// MetricsSink receives metrics from the Store, for example to export to Prometheus.
+// MetricsSink functions are called from Store goroutines, so must be safe for concurrent use and should return quickly.
+// The id passed to MetricsSink functions is the label for the id if the Store LabelMapper is set.
+//
+// The dashboard returned by GrafanaDashboard expects the metrics to be exported to Prometheus as:
+//   - dblocker_wait_seconds: histogram of ObserveWait wait times, with tag, mode, and outcome labels
+//   - dblocker_hold_seconds: histogram of ObserveHold hold times, with tag, mode, and outcome labels
+//   - dblocker_waiting_requests: gauge of SetWaiting counts, with an id label
+//   - dblocker_groups: gauge of SetGroups counts
+type MetricsSink interface {
+
+	// ObserveWait is called with the time that each request waited to be granted (or to fail)
+	ObserveWait(id interface{}, tag string, mode AccessMode, wait time.Duration, outcome Outcome)
+
+	// ObserveHold is called with the time that each granted request was held for
+	ObserveHold(id interface{}, tag string, mode AccessMode, hold time.Duration, outcome Outcome)
+
+	// SetWaiting is called with the number of requests waiting for (or being granted) access to an id whenever it changes
+	SetWaiting(id interface{}, waiting int)
+
+	// SetGroups is called with the number of ids with shared database sessions whenever it changes
+	SetGroups(groups int)
+}
+
+//go:embed dashboards/dblocker.json
+var grafanaDashboard []byte
+
+// GrafanaDashboard returns a Grafana dashboard definition (JSON) for the Prometheus metrics described in MetricsSink
+func GrafanaDashboard() []byte {
+	return append([]byte(nil), grafanaDashboard...)
+}
+
+// observeWait counts a request for the Report and WaitDistributions and sends the wait time to the Store MetricsSink
+func (s *Store) observeWait(id interface{}, accessType string, tag string, wait time.Duration, outcome Outcome) {
+	s.countWait(outcome)
+	s.recordWaitDistribution(tag, AccessMode(accessType), wait, outcome)
+	if s.MetricsSink != nil {
+		s.MetricsSink.ObserveWait(s.metricsID(id), tag, AccessMode(accessType), wait, outcome)
+	}
+}
+
+// observeHold counts a released hold for the Report and sends the hold time to the Store MetricsSink
+func (s *Store) observeHold(h *Hold, hold time.Duration, outcome Outcome) {
+	s.countHold(outcome)
+	if s.MetricsSink != nil {
+		s.MetricsSink.ObserveHold(s.metricsID(h.id), h.tag, AccessMode(h.accessType), hold, outcome)
+	}
+}
+
+// observeGroup records the number of groups for Stats and the Report and sends the request count for an id and the number of groups to the Store MetricsSink
+func (s *Store) observeGroup(id interface{}, waiting int64, groups int) {
+	s.stats.groups.Store(int64(groups))
+	s.countGroups(groups)
+	if s.MetricsSink != nil {
+		s.setWaiting(id, waiting)
+		s.MetricsSink.SetGroups(groups)
+	}
+}
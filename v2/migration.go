@@ -0,0 +1,187 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultMigrationLease is the lease duration used by Migrate if the MigrationOptions Lease is zero
+const DefaultMigrationLease = time.Minute
+
+// ErrLeaseExpired is the cause of the Migration context when the migration lease expires because Heartbeat was not called within the lease duration.
+// Errors wrapping ErrLeaseExpired also wrap context.DeadlineExceeded.
+var ErrLeaseExpired = errors.New("dblocker: migration lease expired")
+
+// errLeaseExpired is the cause of the lease context when the lease expires
+var errLeaseExpired = fmt.Errorf("%w: %w", ErrLeaseExpired, context.DeadlineExceeded)
+
+type noUnlockTimeoutKey struct{}
+
+// withoutUnlockTimeout returns a copy of ctx for requests which are exempt from the unlockTimeout (e.g. Migrate requests, which are released when their lease expires instead)
+func withoutUnlockTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noUnlockTimeoutKey{}, true)
+}
+
+// exemptFromUnlockTimeout returns true for requests made using a context returned by withoutUnlockTimeout
+func exemptFromUnlockTimeout(ctx context.Context) bool {
+	exempt, _ := ctx.Value(noUnlockTimeoutKey{}).(bool)
+	return exempt
+}
+
+// MigrationOptions are the options for a long running schema change made using Migrate
+type MigrationOptions struct {
+
+	// StatementTimeout is the statement timeout for the migration database session, where zero means no statement timeout (if the database supports statement timeouts)
+	StatementTimeout time.Duration
+
+	// Lease is the time that the migration keeps exclusive access to the id without calling Heartbeat (default DefaultMigrationLease).
+	// The migration hold is exempt from the unlockTimeout, and is instead released when the lease expires, so that a migration which hangs or whose process stalls does not block the id forever.
+	Lease time.Duration
+
+	// OnProgress is optionally called with the progress reported by each call to Heartbeat
+	OnProgress func(p MigrationProgress)
+
+	// Cleanup is optionally called with the migration error if the migration fails (including when the lease expires), while holding exclusive access to the id,
+	// to undo a partly applied schema change (e.g. dropping a half built table or index).
+	// If the migration hold was released before the migration failed, exclusive access to the id is requested again (using the Migrate context) before calling Cleanup.
+	Cleanup func(ctx context.Context, db *sqlx.DB, err error) error
+}
+
+// MigrationProgress is the progress of a migration reported by Heartbeat
+type MigrationProgress struct {
+	ID  interface{}
+	Tag string
+
+	// Step describes the current step of the migration (e.g. "backfill users"), and Percent is the percentage of the migration completed (0 to 100)
+	Step    string
+	Percent float64
+
+	// Elapsed is the time since the migration hold was granted
+	Elapsed time.Duration
+}
+
+// Migration is the exclusive access to an id held by a migration function run using Migrate
+type Migration struct {
+	h        *Hold
+	leaseCtx context.Context
+	lease    time.Duration
+	options  MigrationOptions
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// Migrate runs a long running schema change (e.g. DDL on a tenant database) for the specified id, while holding exclusive (RW) access to the id.
+// The migration uses a new database session with the MigrationOptions StatementTimeout (as for RWGetDBWithTimeout), so the shared database session for the id keeps its statement timeout.
+// The migration hold is exempt from the unlockTimeout, and is instead renewed by each call to Migration.Heartbeat and released when the lease expires (see MigrationOptions Lease),
+// when ctx is done, or when fn returns. The Store MaxHoldDuration still applies.
+// If fn returns an error, or if the migration hold is released before fn returns, the MigrationOptions Cleanup function is called, and Migrate returns the error
+// (joined with the Cleanup error, if any).
+func (s *Store) Migrate(id interface{}, ctx context.Context, tag string, options MigrationOptions, fn func(ctx context.Context, m *Migration) error) (err error) {
+	if ctx == nil {
+		return s.misuse("migration request with a nil context (id %v, tag %q)", id, tag)
+	}
+	lease := options.Lease
+	if lease <= 0 {
+		lease = DefaultMigrationLease
+	}
+	var statementTimeout *time.Duration
+	if caps, _ := DriverCapabilities(s.settings().driverName); caps.StatementTimeout {
+		statementTimeout = &options.StatementTimeout
+	}
+
+	// The lease starts when the migration hold is granted
+	leaseCtx, cancelLease := context.WithCancelCause(withoutUnlockTimeout(ctx))
+	defer cancelLease(nil)
+	h, err := s.waitGetDB(id, "rwseparate", leaseCtx, tag, statementTimeout)
+	if err != nil {
+		return err
+	}
+	m := &Migration{
+		h:        h,
+		leaseCtx: leaseCtx,
+		lease:    lease,
+		options:  options,
+	}
+	m.mu.Lock()
+	m.timer = time.AfterFunc(lease, func() { cancelLease(errLeaseExpired) })
+	m.mu.Unlock()
+	defer m.timer.Stop()
+
+	holdCtx, cancel := h.BindContext(leaseCtx)
+	err = fn(holdCtx, m)
+	if err == nil && holdCtx.Err() != nil {
+		err = fmt.Errorf("migration hold for id %v (tag %q) released before the migration finished: %w", h.ID(), h.Tag(), m.err())
+	}
+	if err == nil || options.Cleanup == nil {
+		cancel()
+		h.Release()
+		return err
+	}
+
+	// Undo the failed migration while holding exclusive access to the id
+	if holdCtx.Err() == nil {
+		cleanupErr := options.Cleanup(holdCtx, h.DB(), err)
+		cancel()
+		h.Release()
+		return errors.Join(err, cleanupErr)
+	}
+	cancel()
+	h.Release()
+	cleanupHold, holdErr := s.waitGetDB(id, "rwseparate", ctx, tag, statementTimeout)
+	if holdErr != nil {
+		return errors.Join(err, fmt.Errorf("migration cleanup error: %w", holdErr))
+	}
+	defer cleanupHold.Release()
+	cleanupCtx, cleanupCancel := cleanupHold.BindContext(ctx)
+	defer cleanupCancel()
+	return errors.Join(err, options.Cleanup(cleanupCtx, cleanupHold.DB(), err))
+}
+
+// DB returns the migration database session
+func (m *Migration) DB() *sqlx.DB {
+	return m.h.DB()
+}
+
+// Hold returns the Hold of the migration
+func (m *Migration) Hold() *Hold {
+	return m.h
+}
+
+// Heartbeat renews the migration lease (see MigrationOptions Lease), and reports the progress of the migration to the MigrationOptions OnProgress function.
+// Heartbeat returns an error if the migration hold has already been released (e.g. because the lease expired), in which case the migration should stop.
+func (m *Migration) Heartbeat(step string, percent float64) error {
+	if m.leaseCtx.Err() != nil || m.h.ctx.Err() != nil {
+		return fmt.Errorf("migration heartbeat error: %w", m.err())
+	}
+	m.mu.Lock()
+	m.timer.Reset(m.lease)
+	m.mu.Unlock()
+
+	if m.options.OnProgress != nil {
+		m.options.OnProgress(MigrationProgress{
+			ID:      m.h.ID(),
+			Tag:     m.h.Tag(),
+			Step:    step,
+			Percent: percent,
+			Elapsed: time.Since(m.h.grantedAt),
+		})
+	}
+	return nil
+}
+
+// err returns the reason that the migration hold was released, preferring the lease context cause (e.g. ErrLeaseExpired)
+func (m *Migration) err() error {
+	if err := context.Cause(m.leaseCtx); err != nil {
+		return err
+	}
+	if err := m.h.Err(); err != nil {
+		return err
+	}
+	return context.Canceled
+}
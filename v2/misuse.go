@@ -0,0 +1,32 @@
+package dblocker
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMisuse is returned (wrapped with details) when the Store is used incorrectly (e.g. a request with a nil context).
+// When the Store PanicOnMisuse setting is true, misuse panics with the error instead.
+var ErrMisuse = errors.New("dblocker misuse")
+
+// misuse returns an error wrapping ErrMisuse, or panics with the error if the Store PanicOnMisuse setting is true
+func (s *Store) misuse(format string, a ...interface{}) error {
+	err := fmt.Errorf("%w: %s", ErrMisuse, fmt.Sprintf(format, a...))
+	if s.PanicOnMisuse {
+		panic(err)
+	}
+	return err
+}
+
+// checkReleased reports (using misuse) the use of a Hold after Release() has been called
+func (h *Hold) checkReleased(use string) {
+	if !h.s.PanicOnMisuse {
+		return
+	}
+	h.mu.Lock()
+	released := h.released
+	h.mu.Unlock()
+	if released {
+		h.s.misuse("%s after the hold was released (id %v, tag %q)", use, h.id, h.tag)
+	}
+}
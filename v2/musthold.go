@@ -0,0 +1,72 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrNotHeld is returned (wrapped with details) by MustRWHeld and MustReadHeld when they are called outside an appropriate hold
+var ErrNotHeld = errors.New("dblocker: hold not held")
+
+// MustRWHeld returns nil if ctx carries a RW hold of the Store (see RWHold and RWHoldWithTimeout) for the specified id which has not been released (see HoldFromContext),
+// and otherwise returns an error wrapping ErrNotHeld, so that repository-layer functions can declare and enforce that they are called under a RW hold for an id.
+// MustRWHeld panics with the error instead if the Store is in Strict mode, including when ctx does not carry any hold.
+func (s *Store) MustRWHeld(ctx context.Context, id interface{}) error {
+	return s.mustHeld(ctx, id, true)
+}
+
+// MustReadHeld returns nil if ctx carries a hold of the Store of any mode for the specified id which has not been released (RW holds also exclude writers),
+// and otherwise returns an error wrapping ErrNotHeld (or panics with the error if the Store is in Strict mode, see MustRWHeld)
+func (s *Store) MustReadHeld(ctx context.Context, id interface{}) error {
+	return s.mustHeld(ctx, id, false)
+}
+
+// MustRWHeld returns nil if ctx carries a RW hold of any Store for the specified id which has not been released, and otherwise returns an error wrapping ErrNotHeld.
+// MustRWHeld only panics if ctx carries a hold of a Store in Strict mode, so use (*Store).MustRWHeld to also panic when ctx does not carry any hold.
+func MustRWHeld(ctx context.Context, id interface{}) error {
+	return mustHeld(ctx, nil, id, true)
+}
+
+// MustReadHeld returns nil if ctx carries a hold of any Store and mode for the specified id which has not been released,
+// and otherwise returns an error wrapping ErrNotHeld (or panics with the error, see MustRWHeld)
+func MustReadHeld(ctx context.Context, id interface{}) error {
+	return mustHeld(ctx, nil, id, false)
+}
+
+// mustHeld checks that ctx carries a hold of the Store for the id which is exclusive if rw is true, and panics if the check fails and the Store is in Strict mode
+func (s *Store) mustHeld(ctx context.Context, id interface{}, rw bool) error {
+	err := mustHeld(ctx, s, id, rw)
+	if err != nil && s.Strict {
+		panic(err)
+	}
+	return err
+}
+
+// mustHeld checks that ctx carries a hold for the id which is exclusive if rw is true, where holds of any Store are checked if s is nil.
+// If s is nil, mustHeld panics if the check fails and ctx carries a hold of a Store in Strict mode (i.e. when the caller is running under a Strict Store).
+func mustHeld(ctx context.Context, s *Store, id interface{}, rw bool) error {
+	_, ok := heldInContext(ctx, func(h *Hold) bool {
+		return (s == nil || h.s == s) && h.id == h.s.lockKey(id) && (!rw || !h.Mode().isRead())
+	})
+	if ok {
+		return nil
+	}
+
+	required := "read"
+	if rw {
+		required = "RW"
+	}
+	err := fmt.Errorf("%w: %s hold required for id %v", ErrNotHeld, required, id)
+	if h, held := heldInContext(ctx, func(h *Hold) bool { return (s == nil || h.s == s) && h.id == h.s.lockKey(id) }); held {
+		err = fmt.Errorf("%w: %s hold required for id %v (%s hold held, tag %q)", ErrNotHeld, required, id, h.Mode(), h.tag)
+	}
+
+	// Panic if the caller is running under a Strict Store
+	if s == nil {
+		if _, strict := heldInContext(ctx, func(h *Hold) bool { return h.s.Strict }); strict {
+			panic(err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Notification is a postgres NOTIFY message received on the LISTEN channel for an id
+type Notification struct {
+	ID      interface{}
+	Channel string
+	Payload string
+	BePid   int
+}
+
+type subscriber struct {
+	sync.Mutex
+
+	ctx    context.Context
+	ch     chan Notification
+	closed bool
+}
+
+// Notifications returns a channel which receives postgres NOTIFY messages sent on the LISTEN channel for the specified id.
+// Store.ListenChannel must be set and the Store must use the postgres driver (and import the github.com/calmdocs/dblocker/drivers/postgres package, which sets the DriverSpec Listen function).
+// The LISTEN connection for an id shares the lifecycle of the shared database session for that id
+// (i.e. notifications are only received while there are requests for the id).
+// The returned channel is closed when ctx is cancelled.
+// Notifications are authorized by the Store Authorizer (if set) as a read request for the id with an empty tag, and otherwise Notifications returns an error wrapping ErrUnauthorized.
+func (s *Store) Notifications(id interface{}, ctx context.Context) (<-chan Notification, error) {
+	id = s.lockKey(id)
+	if s.ListenChannel == nil {
+		return nil, fmt.Errorf("notifications error: ListenChannel not set")
+	}
+	if driverName := s.settings().driverName; !listenSupported(driverName) {
+		return nil, fmt.Errorf("notifications error: LISTEN for database type not implemented: %s%s", driverName, driverImportHint(driverName))
+	}
+	if s.TransactionPooling {
+		return nil, fmt.Errorf("notifications error: %w", transactionPoolingError("LISTEN notifications"))
+	}
+	err := s.authorize(ctx, id, AccessRead, "")
+	if err != nil {
+		return nil, err
+	}
+
+	storeCtx := s.storeCtx()
+	sub := &subscriber{
+		ctx: ctx,
+		ch:  make(chan Notification, 16),
+	}
+
+	s.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[interface{}]map[*subscriber]struct{})
+	}
+	if s.subscribers[id] == nil {
+		s.subscribers[id] = make(map[*subscriber]struct{})
+	}
+	s.subscribers[id][sub] = struct{}{}
+	s.Unlock()
+
+	// Unsubscribe and close the channel when done
+	s.spawn("subscriber", func() {
+		select {
+		case <-ctx.Done():
+		case <-storeCtx.Done():
+		}
+
+		s.Lock()
+		delete(s.subscribers[id], sub)
+		if len(s.subscribers[id]) == 0 {
+			delete(s.subscribers, id)
+		}
+		s.Unlock()
+
+		sub.Lock()
+		sub.closed = true
+		close(sub.ch)
+		sub.Unlock()
+	})
+
+	return sub.ch, nil
+}
+
+// startListen starts the LISTEN connection for an id (see listen), and can be replaced in tests
+var startListen = (*Store).listen
+
+// listenSupported returns true if the DriverSpec for the database type has a Listen function
+func listenSupported(driverName string) bool {
+	spec, _ := LookupDriver(driverName)
+	return spec.Listen != nil
+}
+
+// listen starts a LISTEN connection for the specified id using the DriverSpec Listen function, and forwards notifications to subscribers.
+// The LISTEN connection is closed when the returned cancel() function is called.
+func (s *Store) listen(storeCtx context.Context, id interface{}) (cancel context.CancelFunc) {
+	ctx, cancel := context.WithCancel(storeCtx)
+
+	spec, _ := LookupDriver(s.settings().driverName)
+	dataSourceName, err := s.labelDataSourceName(id, s.settings().driverName, s.dataSourceName(id))
+	if err != nil {
+		fmt.Println("dbLocker listen error:", err.Error())
+		dataSourceName = s.dataSourceName(id)
+	}
+	channel := s.ListenChannel(id)
+	s.spawn("listener", func() {
+		err := spec.Listen(ctx, dataSourceName, channel, func(n Notification) {
+			n.ID = id
+			s.notify(n)
+		}, func(err error) {
+			if s.settings().debug {
+				fmt.Println("dbLocker listen error:", err.Error())
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			fmt.Println("dbLocker listen error:", err.Error())
+		}
+	})
+	return cancel
+}
+
+// notify sends a notification to all subscribers for the notification id
+func (s *Store) notify(n Notification) {
+	s.Lock()
+	subs := make([]*subscriber, 0, len(s.subscribers[n.ID]))
+	for sub := range s.subscribers[n.ID] {
+		subs = append(subs, sub)
+	}
+	s.Unlock()
+
+	for _, sub := range subs {
+		sub.Lock()
+		if !sub.closed {
+			select {
+			case sub.ch <- n:
+			case <-sub.ctx.Done():
+			case <-s.storeCtx().Done():
+			}
+		}
+		sub.Unlock()
+	}
+}
@@ -0,0 +1,100 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type outboxItem struct {
+	tag string
+	fn  func(ctx context.Context) error
+}
+
+// outbox is the queue of AfterRelease callbacks for an id
+type outbox struct {
+	items   []outboxItem
+	running bool
+}
+
+// enqueueAfterRelease adds the AfterRelease callbacks of a successfully released Hold to the outbox for the Hold id
+func (s *Store) enqueueAfterRelease(h *Hold) {
+	h.mu.Lock()
+	fns := h.afterRelease
+	h.afterRelease = nil
+	h.mu.Unlock()
+	if len(fns) == 0 {
+		return
+	}
+
+	s.Lock()
+	if s.outboxes == nil {
+		s.outboxes = make(map[interface{}]*outbox)
+	}
+	o, ok := s.outboxes[h.id]
+	if !ok {
+		o = &outbox{}
+		s.outboxes[h.id] = o
+	}
+	for _, fn := range fns {
+		o.items = append(o.items, outboxItem{tag: h.tag, fn: fn})
+	}
+	start := !o.running
+	o.running = true
+	s.Unlock()
+
+	if start {
+		s.spawn("outbox", func() { s.runOutbox(h.id, o) })
+	}
+}
+
+// runOutbox runs the AfterRelease callbacks for an id in order until the outbox is empty
+func (s *Store) runOutbox(id interface{}, o *outbox) {
+	for {
+		s.Lock()
+		if len(o.items) == 0 {
+			o.running = false
+			delete(s.outboxes, id)
+			s.Unlock()
+			return
+		}
+		item := o.items[0]
+		o.items = o.items[1:]
+		s.Unlock()
+
+		err := s.runAfterRelease(item)
+		if err != nil {
+			fmt.Println("dbLocker after release error:", err.Error())
+			if s.Hooks.OnAfterReleaseError != nil {
+				s.Hooks.OnAfterReleaseError(id, item.tag, err)
+			}
+		}
+	}
+}
+
+// runAfterRelease calls an AfterRelease callback, retrying with exponential backoff if it returns an error
+func (s *Store) runAfterRelease(item outboxItem) (err error) {
+	settings := s.settings()
+	retries := settings.afterReleaseRetries
+	delay := settings.afterReleaseRetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+
+	storeCtx := s.storeCtx()
+	for attempt := 0; ; attempt++ {
+		err = item.fn(storeCtx)
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		retryDelay := time.NewTimer(delay)
+		select {
+		case <-storeCtx.Done():
+			retryDelay.Stop()
+			return ErrStoreClosed
+		case <-retryDelay.C:
+		}
+		delay *= 2
+	}
+}
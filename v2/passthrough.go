@@ -0,0 +1,81 @@
+package dblocker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// passthroughDB returns the shared database session for the specified id without waiting for access (see ReadPassthrough).
+// The shared database session is not closed (and the group for the id is not deleted) until the returned release function is called,
+// except by Evict or Reconnect, or when the Store context is cancelled.
+func (s *Store) passthroughDB(id interface{}, ctx context.Context, tag string) (release func(), db *sqlx.DB, err error) {
+	if ctx == nil {
+		return nil, nil, s.misuse("read request with a nil context (id %v, tag %q)", id, tag)
+	}
+	storeCtx := s.storeCtx()
+	if storeCtx.Err() != nil {
+		return nil, nil, ErrStoreClosed
+	}
+	tag = s.requestTag(ctx, tag)
+	err = s.checkTag("read", id, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Check that the request is authorized
+	err = s.authorize(ctx, id, AccessRead, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Get the shared database session, retrying with a new Group if the Group is deleted before the database is received
+	metadata, _ := MetadataFromContext(ctx)
+	for {
+		g, epoch, err := s.getGroup(id, tag, metadata)
+		if err != nil {
+			return nil, nil, err
+		}
+		select {
+		case db = <-g.dbCh:
+			s.Lock()
+			s.passthroughs++
+			s.Unlock()
+
+			var once sync.Once
+			release = func() {
+				once.Do(func() { s.releasePassthrough(id, g, epoch) })
+			}
+			return release, db, nil
+		case <-g.done:
+			s.releaseGroup(id, g, epoch)
+			if storeCtx.Err() != nil {
+				return nil, nil, ErrStoreClosed
+			}
+			if g.err != nil {
+				return nil, nil, g.err
+			}
+		case <-storeCtx.Done():
+			s.releaseGroup(id, g, epoch)
+			return nil, nil, ErrStoreClosed
+		case <-ctx.Done():
+			s.releaseGroup(id, g, epoch)
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// releasePassthrough decrements the Group request count for a passthrough read,
+// and notifies the group so that the group can be deleted if it is unused (see TeardownPolicy)
+func (s *Store) releasePassthrough(id interface{}, g *Group, epoch int) {
+	s.Lock()
+	s.passthroughs--
+	s.Unlock()
+	s.releaseUnscheduled(id, g, epoch)
+}
+
+// isPassthrough returns true if read requests for the id and tag bypass locking
+func (s *Store) isPassthrough(id interface{}, tag string) bool {
+	return s.ReadPassthrough != nil && s.ReadPassthrough(id, tag)
+}
@@ -0,0 +1,79 @@
+package dblocker
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrTransactionPooling is returned for features which require session state when the Store TransactionPooling setting is true
+// (e.g. LISTEN notifications, session level statement timeouts for Hold connections, or the SessionResetDiscard policy)
+var ErrTransactionPooling = errors.New("dblocker: not supported with transaction pooling")
+
+// transactionPoolingError returns an error wrapping ErrTransactionPooling for a feature which requires session state
+func transactionPoolingError(feature string) error {
+	return fmt.Errorf("%w: %s", ErrTransactionPooling, feature)
+}
+
+// validateTransactionPooling returns an error if the Store settings require session state which is not kept when the Store TransactionPooling setting is true.
+// validateTransactionPooling is called before connecting each database session, and the error is a fatal connection error (see ErrFatalConnect).
+func (s *Store) validateTransactionPooling(driverName string) error {
+	if !s.TransactionPooling {
+		return nil
+	}
+	spec, _ := LookupDriver(driverName)
+	switch {
+	case spec.SetLocalTimeouts == nil && (s.settings().statementTimeout != nil || s.settings().lockTimeout != nil):
+		return FatalConnectError(transactionPoolingError(fmt.Sprintf("statement and lock timeouts require transaction timeouts, which are not implemented for database type %s", driverName)))
+	case s.ListenChannel != nil:
+		return FatalConnectError(transactionPoolingError("LISTEN notifications (ListenChannel)"))
+	case s.SessionReset == SessionResetDiscard:
+		return FatalConnectError(transactionPoolingError("the SessionResetDiscard policy"))
+	}
+	return nil
+}
+
+// BeginTxx begins a transaction using the shared database session of the Hold, which is rolled back if the Hold is released before the transaction is committed.
+// If the Store TransactionPooling setting is true, the statement timeout for the Hold tag (see TagStatementTimeouts) or for the id (see StatementTimeoutFor and InheritDeadline) and the Store LockTimeout are set for the transaction only
+// (e.g. using SET LOCAL), as session settings are not kept between transactions by transaction pooling proxies.
+// Use BeginTxx rather than Hold.Conn for statements which require a statement or lock timeout when the Store TransactionPooling setting is true.
+// The session variables returned by the Store SessionVariables function (if set) are set for the transaction only (e.g. using set_config), and BeginTxx returns an error if they cannot be set.
+func (h *Hold) BeginTxx(opts *sql.TxOptions) (tx *sqlx.Tx, err error) {
+	h.checkReleased("BeginTxx")
+	tx, err = h.db.BeginTxx(h.ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = h.prepareTx(tx)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+// setLocalTimeouts sets the statement timeout for the Hold and the Store LockTimeout for a transaction only (e.g. using SET LOCAL) if the Store TransactionPooling setting is true (see BeginTxx)
+func (h *Hold) setLocalTimeouts(tx sqlx.ExecerContext) error {
+	if !h.s.TransactionPooling {
+		return nil
+	}
+
+	statementTimeout := h.timeout
+	if statementTimeout == nil {
+		statementTimeout = h.s.StatementTimeoutFor(h.id)
+	}
+	if ownerCtx := h.ownerCtx(); ownerCtx != nil {
+		statementTimeout = h.s.inheritedTimeout(ownerCtx, statementTimeout)
+	}
+	lockTimeout := h.s.settings().lockTimeout
+	if statementTimeout == nil && lockTimeout == nil {
+		return nil
+	}
+	spec, _ := LookupDriver(h.s.settings().driverName)
+	if spec.SetLocalTimeouts == nil {
+		return transactionPoolingError("transaction timeouts")
+	}
+	return spec.SetLocalTimeouts(h.ctx, h.s.labelExecer(h.id, tx), statementTimeout, lockTimeout)
+}
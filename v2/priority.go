@@ -0,0 +1,128 @@
+package dblocker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context which sets the priority of requests made using the context (default 0, and higher priorities are granted first).
+// Priorities are only used by the SchedulerPriority scheduler policy (see the Store Scheduler setting).
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// priorityFromContext returns the priority set using WithPriority
+func priorityFromContext(ctx context.Context) int {
+	priority, _ := ctx.Value(priorityKey{}).(int)
+	return priority
+}
+
+// priorityLock is a groupLock which grants waiting requests in order of priority, where the priority of a waiting request increases by one for each aging interval waited.
+// Requests with the same priority are granted in the order that they were made.
+// Requests are not granted ahead of a waiting request with a higher priority, so that a waiting RW request is not starved by overlapping reads with a lower priority.
+type priorityLock struct {
+	mu sync.Mutex
+
+	// aging is the wait after which a waiting request gains one priority level (zero disables aging)
+	aging time.Duration
+
+	cur     int64
+	seq     uint64
+	waiters []*priorityWaiter
+}
+
+type priorityWaiter struct {
+	n        int64
+	priority int
+	since    time.Time
+	seq      uint64
+	ready    chan struct{}
+}
+
+// effectivePriority returns the priority of a waiting request including the priority gained by aging
+func (l *priorityLock) effectivePriority(w *priorityWaiter, now time.Time) int {
+	if l.aging <= 0 {
+		return w.priority
+	}
+	return w.priority + int(now.Sub(w.since)/l.aging)
+}
+
+func (l *priorityLock) lock(ctx context.Context, read bool) error {
+	n := semaphoreWeight(read)
+
+	l.mu.Lock()
+	if semaphoreSize-l.cur >= n && len(l.waiters) == 0 {
+		l.cur += n
+		l.mu.Unlock()
+		return nil
+	}
+	l.seq++
+	w := &priorityWaiter{
+		n:        n,
+		priority: priorityFromContext(ctx),
+		since:    time.Now(),
+		seq:      l.seq,
+		ready:    make(chan struct{}),
+	}
+	l.waiters = append(l.waiters, w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+
+		// The request was granted after ctx was done
+		case <-w.ready:
+			l.cur -= n
+		default:
+			for i, other := range l.waiters {
+				if other == w {
+					l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+
+		// Later requests may be waiting for this request
+		l.notifyLocked()
+		return ctx.Err()
+	}
+}
+
+func (l *priorityLock) unlock(read bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cur -= semaphoreWeight(read)
+	l.notifyLocked()
+}
+
+// notifyLocked grants the waiting request with the highest priority while it fits in the semaphore.
+// The priorityLock must be locked when notifyLocked is called.
+func (l *priorityLock) notifyLocked() {
+	now := time.Now()
+	for len(l.waiters) > 0 {
+		next := 0
+		nextPriority := l.effectivePriority(l.waiters[0], now)
+		for i, w := range l.waiters[1:] {
+			priority := l.effectivePriority(w, now)
+			if priority > nextPriority || (priority == nextPriority && w.seq < l.waiters[next].seq) {
+				next, nextPriority = i+1, priority
+			}
+		}
+		w := l.waiters[next]
+		if semaphoreSize-l.cur < w.n {
+			return
+		}
+		l.cur += w.n
+		l.waiters = append(l.waiters[:next], l.waiters[next+1:]...)
+		close(w.ready)
+	}
+}
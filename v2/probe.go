@@ -0,0 +1,106 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ErrUnreachable is wrapped by the errors returned for requests which fail because the database host for an id without a shared database session could not be reached (see the Store ProbeTimeout)
+var ErrUnreachable = errors.New("dblocker: database unreachable")
+
+// probe checks that the database host for an id is reachable (using a TCP dial bounded by the Store ProbeTimeout) before the group for the id is added,
+// so that requests for unreachable databases fail quickly rather than waiting while the group retries connecting.
+// Ids which already have a group (or an adopted database), and database types without a DriverSpec Address function, are not probed.
+func (s *Store) probe(ctx context.Context, id interface{}) error {
+	timeout := s.ProbeTimeout
+	if timeout <= 0 {
+		return nil
+	}
+	s.Lock()
+	_, ok := s.m[id]
+	_, adopted := s.adopted[id]
+	s.Unlock()
+	if ok || adopted {
+		return nil
+	}
+	spec, _ := LookupDriver(s.settings().driverName)
+	if spec.Address == nil {
+		return nil
+	}
+	network, address, err := spec.Address(s.dataSourceName(id))
+	if err != nil || address == "" {
+		return nil
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(probeCtx, network, address)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: id %v: %w", ErrUnreachable, id, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// postgresAddress returns the address of the first host in a postgres URL or key=value data source name, or "" for unix sockets and multiple hosts
+func postgresAddress(dataSourceName string) (network, address string, err error) {
+	host, port := "localhost", "5432"
+	if strings.HasPrefix(dataSourceName, "postgres://") || strings.HasPrefix(dataSourceName, "postgresql://") {
+		u, err := url.Parse(dataSourceName)
+		if err != nil {
+			return "", "", err
+		}
+		if u.Hostname() != "" {
+			host = u.Hostname()
+		}
+		if u.Port() != "" {
+			port = u.Port()
+		}
+		if u.Query().Get("host") != "" {
+			host = u.Query().Get("host")
+		}
+	} else {
+		params, err := parsePostgresKeyValues(dataSourceName)
+		if err != nil {
+			return "", "", err
+		}
+		for _, param := range params {
+			switch param[0] {
+			case "host":
+				host = param[1]
+			case "port":
+				port = param[1]
+			}
+		}
+	}
+	if strings.HasPrefix(host, "/") || strings.Contains(host, ",") || strings.Contains(port, ",") {
+		return "", "", nil
+	}
+	return "tcp", net.JoinHostPort(host, port), nil
+}
+
+var mysqlAddressRegexp = regexp.MustCompile(`@(tcp|tcp4|tcp6|unix)\(([^)]*)\)`)
+
+// mysqlAddress returns the address in a go-sql-driver/mysql data source name (e.g. "user:password@tcp(host:3306)/db"), using the driver default address if none is set
+func mysqlAddress(dataSourceName string) (network, address string, err error) {
+	match := mysqlAddressRegexp.FindStringSubmatch(dataSourceName)
+	if match == nil || match[2] == "" {
+		return "tcp", "127.0.0.1:3306", nil
+	}
+	network, address = match[1], match[2]
+	if network != "unix" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "3306")
+		}
+	}
+	return network, address, nil
+}
@@ -0,0 +1,155 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PingStrategy controls when the shared database session for an id is checked using Ping
+type PingStrategy int
+
+const (
+
+	// PingOnConnect only pings the database when the shared database session is connected (default)
+	PingOnConnect PingStrategy = iota
+
+	// PingBeforeGrant also pings the shared database session before granting each request, and fails the request if the ping fails.
+	// PingBeforeGrant adds a round trip to each request, but detects stale connections (e.g. after long idle periods) before they are used.
+	PingBeforeGrant
+)
+
+// Profile is a set of default settings for a database driver and workload
+type Profile struct {
+
+	// UnlockTimeout is the timeout for waiting for access to the database (nil for no timeout)
+	UnlockTimeout *time.Duration
+
+	// StatementTimeout is the statement timeout for database sessions (nil for no timeout, and must be nil where the database does not support statement timeouts)
+	StatementTimeout *time.Duration
+
+	// Connection pool settings for each database session (zero values use the database/sql defaults)
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// LockTimeout is the timeout for waiting for database locks (nil for the database default, and must be nil where the database does not support lock timeouts)
+	LockTimeout *time.Duration
+
+	// Ping controls when the shared database session is checked using Ping
+	Ping PingStrategy
+
+	// ReconnectDelay is the delay between failed attempts to connect to the database
+	ReconnectDelay time.Duration
+}
+
+// ProfileOLTP returns a Profile for short interactive requests using the specified driver.
+// sqlite uses a single connection and short unlock and lock timeouts, libSQL uses a small connection pool and short unlock timeouts,
+// and postgres and mysql use short unlock, statement, and lock timeouts.
+func ProfileOLTP(driverName string) Profile {
+	switch driverName {
+	case "sqlite3", "sqlcipher":
+		return Profile{
+			UnlockTimeout:  durationPtr(10 * time.Second),
+			LockTimeout:    durationPtr(5 * time.Second),
+			MaxOpenConns:   1,
+			ReconnectDelay: 500 * time.Millisecond,
+		}
+	case "libsql":
+		return Profile{
+			UnlockTimeout:  durationPtr(10 * time.Second),
+			MaxOpenConns:   4,
+			ReconnectDelay: 2 * time.Second,
+		}
+	case "postgres", "mysql":
+		return Profile{
+			UnlockTimeout:    durationPtr(30 * time.Second),
+			StatementTimeout: durationPtr(30 * time.Second),
+			LockTimeout:      durationPtr(5 * time.Second),
+			MaxOpenConns:     10,
+			MaxIdleConns:     2,
+			ConnMaxLifetime:  30 * time.Minute,
+			ConnMaxIdleTime:  5 * time.Minute,
+			ReconnectDelay:   2 * time.Second,
+		}
+	default:
+		return Profile{
+			UnlockTimeout:  durationPtr(30 * time.Second),
+			ReconnectDelay: 2 * time.Second,
+		}
+	}
+}
+
+// ProfileBatch returns a Profile for long running batch and analytics requests using the specified driver.
+// sqlite uses a single connection and a long lock timeout, and postgres and mysql use long unlock, statement, and lock timeouts.
+// Remote connections are pinged before each request is granted, as batch requests are often made after long idle periods.
+func ProfileBatch(driverName string) Profile {
+	switch driverName {
+	case "sqlite3", "sqlcipher":
+		return Profile{
+			UnlockTimeout:  durationPtr(30 * time.Minute),
+			LockTimeout:    durationPtr(time.Minute),
+			MaxOpenConns:   1,
+			ReconnectDelay: 2 * time.Second,
+		}
+	case "libsql":
+		return Profile{
+			UnlockTimeout:  durationPtr(30 * time.Minute),
+			MaxOpenConns:   2,
+			Ping:           PingBeforeGrant,
+			ReconnectDelay: 10 * time.Second,
+		}
+	case "postgres", "mysql":
+		return Profile{
+			UnlockTimeout:    durationPtr(2 * time.Hour),
+			StatementTimeout: durationPtr(time.Hour),
+			LockTimeout:      durationPtr(5 * time.Minute),
+			MaxOpenConns:     4,
+			MaxIdleConns:     1,
+			ConnMaxLifetime:  2 * time.Hour,
+			Ping:             PingBeforeGrant,
+			ReconnectDelay:   10 * time.Second,
+		}
+	default:
+		return Profile{
+			UnlockTimeout:  durationPtr(30 * time.Minute),
+			ReconnectDelay: 10 * time.Second,
+		}
+	}
+}
+
+// NewWithProfile creates a new dblocker Store
+// using the default connectDBFunc; and
+// with the timeouts, connection pool settings, ping strategy, and reconnect delay of the profile (e.g. ProfileOLTP(driverName) or ProfileBatch(driverName)).
+// NewWithProfile returns an error if the profile has a lock timeout and the database does not support lock timeouts (see DriverCapabilities).
+func NewWithProfile(
+	ctx context.Context,
+	driverName string,
+	dataSourceName string,
+	profile Profile,
+	debug bool,
+) (s *Store, err error) {
+	if profile.LockTimeout != nil {
+		caps, _ := DriverCapabilities(driverName)
+		if !caps.LockTimeout {
+			return nil, fmt.Errorf("connectDB error: lockTimeout for database type not implemented: %s", driverName)
+		}
+	}
+	s, err = NewWithUnlockAndStatementTimeouts(ctx, driverName, dataSourceName, profile.UnlockTimeout, profile.StatementTimeout, debug)
+	if err != nil {
+		return nil, err
+	}
+	s.LockTimeout = profile.LockTimeout
+	s.Ping = profile.Ping
+	s.MaxOpenConns = profile.MaxOpenConns
+	s.MaxIdleConns = profile.MaxIdleConns
+	s.ConnMaxLifetime = profile.ConnMaxLifetime
+	s.ConnMaxIdleTime = profile.ConnMaxIdleTime
+	s.ReconnectDelay = profile.ReconnectDelay
+	return s, nil
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
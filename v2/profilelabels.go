@@ -0,0 +1,28 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"runtime/pprof"
+)
+
+// profileLabels returns the pprof labels of the Hold (see the Store ProfileLabels setting)
+func (h *Hold) profileLabels() pprof.LabelSet {
+	return pprof.Labels("dblocker_id", fmt.Sprint(h.id), "dblocker_tag", h.tag, "dblocker_mode", h.accessType)
+}
+
+// labelOwner sets the pprof labels of the Hold (added to the labels of ctx) for the current goroutine, which is the goroutine of the owner of the Hold,
+// if the Store ProfileLabels setting is true
+func (h *Hold) labelOwner(ctx context.Context) {
+	if !h.s.ProfileLabels {
+		return
+	}
+	pprof.SetGoroutineLabels(pprof.WithLabels(ctx, h.profileLabels()))
+}
+
+// ownerStack returns the stack of the goroutine of the owner of the Hold which was captured in debug mode (see BlockerThreshold and Hold.Transfer)
+func (h *Hold) ownerStack() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stack
+}
@@ -0,0 +1,37 @@
+package dblocker
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrQueryBudget is the error of a Hold which was released because it exceeded the Store QueryBudget (see QueryBudgetRelease)
+var ErrQueryBudget = errors.New("dblocker: hold query budget exceeded")
+
+// countQuery counts a query made using the Hold in debug mode, and warns (or releases the Hold if the Store QueryBudgetRelease setting is true) when the Hold first exceeds the Store QueryBudget
+func (h *Hold) countQuery() {
+	if !h.s.settings().debug {
+		return
+	}
+
+	h.mu.Lock()
+	h.queries++
+	queries := h.queries
+	h.mu.Unlock()
+
+	budget := h.s.QueryBudget
+	if budget <= 0 || queries != budget+1 {
+		return
+	}
+	fmt.Println("dbLocker query budget warning:", fmt.Sprintf("%s hold for id %v (tag %q) made more than %d queries", h.accessType, h.id, h.tag, budget))
+	if h.s.QueryBudgetRelease && h.owner != nil {
+		h.owner.cancel(fmt.Errorf("%w: more than %d queries", ErrQueryBudget, budget))
+	}
+}
+
+// Queries returns the number of queries made using the Hold (see ObserveQuery), which are only counted in debug mode
+func (h *Hold) Queries() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.queries
+}
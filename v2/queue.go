@@ -0,0 +1,156 @@
+package dblocker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Progress describes a request that is waiting for access to the database for an id
+type Progress struct {
+	ID  interface{}
+	Tag string
+
+	// Position is the position of the request in the queue for the id (1 means that the request is next)
+	Position int
+
+	// Waiting is the number of requests waiting for access to the database for the id
+	Waiting int
+
+	// EstimatedWait is the estimated time until the request is granted access, based on recent hold times for the id (zero if unknown)
+	EstimatedWait time.Duration
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context which reports the Progress of requests made using the context to onProgress while they wait for access to the database.
+// onProgress is called when the request starts waiting and whenever its position in the queue changes, from a goroutine started by the Store.
+// WithProgress can be used, for example, to show users that their export is 3rd in line.
+func WithProgress(ctx context.Context, onProgress func(p Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, onProgress)
+}
+
+// progressFromContext returns the onProgress function set using WithProgress
+func progressFromContext(ctx context.Context) (onProgress func(p Progress), ok bool) {
+	onProgress, ok = ctx.Value(progressKey{}).(func(p Progress))
+	return onProgress, ok && onProgress != nil
+}
+
+type queues struct {
+	sync.Mutex
+
+	m         map[interface{}]*queue
+	holdStats map[interface{}]*holdStats
+}
+
+// queue is the ordered list of requests waiting for access to the database for an id, and the current holds for the id
+type queue struct {
+	waiters []*waiter
+	holds   map[*Hold]struct{}
+}
+
+type waiter struct {
+	tag   string
+	mode  AccessMode
+	since time.Time
+
+	// changed receives a value when the position of the waiter changes, and done is closed when the waiter leaves the queue
+	changed chan struct{}
+	done    chan struct{}
+}
+
+// enqueue adds a request to the end of the queue for the specified id
+func (s *Store) enqueue(id interface{}, tag string, mode AccessMode) *waiter {
+	w := &waiter{
+		tag:     tag,
+		mode:    mode,
+		since:   time.Now(),
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	q := s.queueFor(id)
+	q.waiters = append(q.waiters, w)
+	return w
+}
+
+// queueFor returns the queue for the specified id, adding it if required.
+// The queues must be locked when queueFor is called.
+func (s *Store) queueFor(id interface{}) *queue {
+	if s.queues.m == nil {
+		s.queues.m = make(map[interface{}]*queue)
+	}
+	q, ok := s.queues.m[id]
+	if !ok {
+		q = &queue{}
+		s.queues.m[id] = q
+	}
+	return q
+}
+
+// dequeue removes a request from the queue for the specified id, and notifies the requests behind it that their position has changed
+func (s *Store) dequeue(id interface{}, w *waiter) {
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	close(w.done)
+	q := s.queues.m[id]
+	for i, qw := range q.waiters {
+		if qw != w {
+			continue
+		}
+		q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+		for _, behind := range q.waiters[i:] {
+			select {
+			case behind.changed <- struct{}{}:
+			default:
+			}
+		}
+		break
+	}
+	if len(q.waiters) == 0 && len(q.holds) == 0 {
+		delete(s.queues.m, id)
+	}
+}
+
+// progress returns the Progress of a waiting request
+func (s *Store) progress(id interface{}, w *waiter) Progress {
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	p := Progress{
+		ID:  id,
+		Tag: w.tag,
+	}
+	q, ok := s.queues.m[id]
+	if !ok {
+		return p
+	}
+	p.Waiting = len(q.waiters)
+	for i, qw := range q.waiters {
+		if qw == w {
+			p.Position = i + 1
+			p.EstimatedWait = s.estimateWait(id, q, q.waiters[:i], w.mode)
+			break
+		}
+	}
+	return p
+}
+
+// watchProgress calls onProgress when a request starts waiting and whenever its position in the queue changes, until the request leaves the queue
+func (s *Store) watchProgress(ctx context.Context, id interface{}, w *waiter, onProgress func(p Progress)) {
+	for {
+		onProgress(s.progress(id, w))
+
+		select {
+		case <-w.changed:
+		case <-w.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
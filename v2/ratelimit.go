@@ -0,0 +1,110 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned for RW requests that exceed the Store WriteRateLimit when FailFast is set
+var ErrRateLimited = errors.New("dblocker: rw request rate limit exceeded")
+
+// RateLimit is a token bucket rate limit
+type RateLimit struct {
+
+	// Rate is the number of requests permitted per second, and Burst is the maximum number of requests permitted at once
+	Rate  float64
+	Burst int
+
+	// FailFast returns ErrRateLimited for requests that exceed the rate limit instead of waiting
+	FailFast bool
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type rateLimiter struct {
+	sync.Mutex
+
+	buckets map[interface{}]*tokenBucket
+	swept   time.Time
+}
+
+// waitWriteRateLimit waits until the Store WriteRateLimit permits a RW request for the specified id
+func (s *Store) waitWriteRateLimit(storeCtx context.Context, ctx context.Context, id interface{}) error {
+	limit := s.WriteRateLimit
+	if limit == nil || limit.Rate <= 0 {
+		return nil
+	}
+
+	for {
+		wait := s.takeToken(id, limit)
+		if wait == 0 {
+			return nil
+		}
+		if limit.FailFast {
+			return ErrRateLimited
+		}
+
+		waitDelay := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			waitDelay.Stop()
+			return waitError(storeCtx, ctx)
+		case <-storeCtx.Done():
+			waitDelay.Stop()
+			return ErrStoreClosed
+		case <-waitDelay.C:
+		}
+	}
+}
+
+// takeToken takes a token from the bucket for the specified id,
+// or returns the time until a token is available if the bucket is empty
+func (s *Store) takeToken(id interface{}, limit *RateLimit) (wait time.Duration) {
+	rl := &s.rateLimiter
+	rl.Lock()
+	defer rl.Unlock()
+
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+
+	if rl.buckets == nil {
+		rl.buckets = make(map[interface{}]*tokenBucket)
+	}
+
+	// Remove full buckets, at most once for each time that an empty bucket takes to fill
+	// (so that buckets that have not been used since the previous sweep are full, and the cost of each sweep is spread over the requests since the previous sweep)
+	refill := time.Duration(burst / limit.Rate * float64(time.Second))
+	if len(rl.buckets) > 1024 && now.Sub(rl.swept) >= refill {
+		rl.swept = now
+		for bucketID, b := range rl.buckets {
+			if b.tokens+now.Sub(b.updated).Seconds()*limit.Rate >= burst {
+				delete(rl.buckets, bucketID)
+			}
+		}
+	}
+
+	b, ok := rl.buckets[id]
+	if !ok {
+		b = &tokenBucket{tokens: burst, updated: now}
+		rl.buckets[id] = b
+	}
+	b.tokens += now.Sub(b.updated).Seconds() * limit.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
@@ -0,0 +1,34 @@
+package dblocker
+
+import (
+	"errors"
+)
+
+// ErrReadOnlyStore is returned for RW requests (including RWHoldWithTimeout, Migrate, and write requests made using OpenFile) made while the Store is read-only (see SetReadOnly)
+var ErrReadOnlyStore = errors.New("dblocker: store is read-only")
+
+// SetReadOnly sets whether the Store is read-only, for example while the database is a disaster recovery replica or during maintenance.
+// While the Store is read-only, RW requests fail immediately with ErrReadOnlyStore (see OutcomeReadOnly), and read and stream requests continue as usual.
+// RW holds which were granted (or were already waiting) before SetReadOnly is called are not released.
+// SetReadOnly can be called at any time (and the Config ReadOnly setting can be changed using Reload), and the current mode is reported by ReadOnly and Stats.
+func (s *Store) SetReadOnly(readOnly bool) {
+	s.readOnly.Store(readOnly)
+}
+
+// ReadOnly returns true if the Store is read-only (see SetReadOnly)
+func (s *Store) ReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+// checkReadOnly returns ErrReadOnlyStore for RW requests while the Store is read-only
+func (s *Store) checkReadOnly(accessType string) error {
+	if !s.readOnly.Load() {
+		return nil
+	}
+	switch accessType {
+	case "rw", "rwseparate":
+		return ErrReadOnlyStore
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type reconnectRequest struct {
+	ctx            context.Context
+	dataSourceName string
+
+	// done receives nil when connected, or a fatal connection error
+	done chan error
+}
+
+// Reconnect waits for all holds for the specified id to be released, closes the shared database session for the id,
+// and connects a new shared database session for the id using newDataSourceName.
+// Requests for the id wait until the new shared database session is connected.
+// newDataSourceName is also used for all later database sessions for the id (including RWGetDBWithTimeout sessions),
+// and an empty newDataSourceName reverts to the Store DataSourceName.
+// Reconnect returns an error if ctx is done before the holds for the id are released or before the new shared database session is connected
+// (in which case requests for the id retry connecting using newDataSourceName), or if the new shared database session fails with a fatal connection error (see ErrFatalConnect).
+// The LISTEN connection for the id (see Notifications) is restarted using newDataSourceName.
+// Reconnect does not wait for ReadPassthrough reads for the id to be released.
+// If the group for the id is still retrying to connect using the previous data source name (e.g. because the previous host is down), the next attempt uses newDataSourceName.
+func (s *Store) Reconnect(ctx context.Context, id interface{}, newDataSourceName string) error {
+	id = s.lockKey(id)
+
+	storeCtx := s.storeCtx()
+	s.Lock()
+	g, ok := s.m[id]
+	if !ok {
+		s.setDataSourceName(id, newDataSourceName)
+		s.Unlock()
+		return nil
+	}
+	s.Unlock()
+
+	// Wait for the requests granted by the group lock to be released
+	if g.lock != nil {
+		err := g.lock.lock(ctx, false)
+		if err != nil {
+			return err
+		}
+		defer g.lock.unlock(false)
+	}
+
+	r := reconnectRequest{
+		ctx:            ctx,
+		dataSourceName: newDataSourceName,
+		done:           make(chan error, 1),
+	}
+	select {
+	case g.reconnectCh <- r:
+	case <-g.done:
+		s.Lock()
+		s.setDataSourceName(id, newDataSourceName)
+		s.Unlock()
+		return nil
+	case <-storeCtx.Done():
+		return ErrStoreClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-r.done:
+		return err
+	case <-storeCtx.Done():
+		return ErrStoreClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connectGroupAndWait connects the shared database session for a new group, retrying after the reconnectDelay until connected, until ctx is done, or until a fatal connection error (see connectDBAndWait).
+// Each attempt uses the current data source name for the id, so Reconnect requests received while retrying (e.g. because the previous host is down) set the data source name used by the next attempt,
+// which is made immediately, and are answered once the group is connected or fails.
+func (s *Store) connectGroupAndWait(ctx context.Context, id interface{}, g *Group, r *ConnectRequest, reconnectDelay time.Duration) (db *sqlx.DB, err error) {
+	idleDuration := reconnectDelay
+	if idleDuration <= 0 {
+		idleDuration = 2 * time.Second
+	}
+	idleDelay := time.NewTimer(idleDuration)
+	defer idleDelay.Stop()
+
+	var reconnects []reconnectRequest
+	defer func() {
+		for _, reconnect := range reconnects {
+			reconnect.done <- err
+		}
+	}()
+	for {
+		r.Attempt++
+		r.DataSourceName = s.dataSourceName(id)
+		db, err = s.connectGroupDB(ctx, *r)
+		if err == nil {
+			return db, nil
+		}
+
+		fmt.Println("dbLocker connect error:", err.Error())
+
+		// Do not retry fatal errors
+		if fatalErr := s.fatalConnectError(err); fatalErr != nil {
+			return nil, fatalErr
+		}
+
+		idleDelay.Reset(idleDuration)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-idleDelay.C:
+		case reconnect := <-g.reconnectCh:
+			s.Lock()
+			s.setDataSourceName(id, reconnect.dataSourceName)
+			s.Unlock()
+			reconnects = append(reconnects, reconnect)
+		}
+	}
+}
+
+// dataSourceName returns the data source name for the specified id
+func (s *Store) dataSourceName(id interface{}) string {
+	s.Lock()
+	defer s.Unlock()
+
+	dataSourceName, ok := s.dataSourceNames[id]
+	if !ok {
+		return s.DataSourceName
+	}
+	return dataSourceName
+}
+
+// setDataSourceName sets the data source name for the specified id.
+// The Store must be locked when setDataSourceName is called.
+func (s *Store) setDataSourceName(id interface{}, dataSourceName string) {
+	if dataSourceName == "" {
+		delete(s.dataSourceNames, id)
+		return
+	}
+	if s.dataSourceNames == nil {
+		s.dataSourceNames = make(map[interface{}]string)
+	}
+	s.dataSourceNames[id] = dataSourceName
+}
@@ -0,0 +1,213 @@
+package dblocker
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// ReloadReport lists the settings changed by Reload.
+// Applied settings took effect immediately, and Drained settings took effect after the shared database sessions for all ids were drained and closed.
+type ReloadReport struct {
+	Applied []string
+	Drained []string
+}
+
+// Reload applies a changed Config to a running Store.
+// Changes to the driver_name, data_source_name, statement_timeout, and lock_timeout settings require the shared database sessions for all ids to be drained and closed (see Evict),
+// and all other changes are applied immediately (including to the connection pools of existing database sessions).
+// Reload returns an error if the Config is invalid, or if ctx is done before the shared database sessions are drained.
+func (s *Store) Reload(ctx context.Context, cfg Config) (report ReloadReport, err error) {
+	err = cfg.Validate()
+	if err != nil {
+		return report, err
+	}
+
+	// Settings that use the New defaults if not set
+	current := s.settings()
+	unlockTimeout := current.unlockTimeout
+	if cfg.UnlockTimeout != nil {
+		unlockTimeout = cfg.UnlockTimeout.timeout()
+	}
+	statementTimeout := current.statementTimeout
+	if cfg.StatementTimeout != nil {
+		statementTimeout = cfg.StatementTimeout.timeout()
+	}
+	lockTimeout := current.lockTimeout
+	if cfg.LockTimeout != nil {
+		lockTimeout = cfg.LockTimeout.timeout()
+	}
+
+	s.Lock()
+	s.settingsMu.Lock()
+	applied := func(name string, changed bool) {
+		if changed {
+			report.Applied = append(report.Applied, name)
+		}
+	}
+	drained := func(name string, changed bool) {
+		if changed {
+			report.Drained = append(report.Drained, name)
+		}
+	}
+
+	drained("driver_name", s.DriverName != cfg.DriverName)
+	drained("data_source_name", s.DataSourceName != cfg.DataSourceName)
+	drained("statement_timeout", !equalTimeouts(s.StatementTimeout, statementTimeout))
+	s.DriverName = cfg.DriverName
+	s.DataSourceName = cfg.DataSourceName
+	drained("lock_timeout", !equalTimeouts(s.LockTimeout, lockTimeout))
+	s.StatementTimeout = statementTimeout
+	s.LockTimeout = lockTimeout
+
+	applied("debug", s.debug != cfg.Debug)
+	applied("unlock_timeout", !equalTimeouts(s.UnlockTimeout, unlockTimeout))
+	applied("max_open_conns", s.MaxOpenConns != cfg.MaxOpenConns)
+	applied("max_idle_conns", s.MaxIdleConns != cfg.MaxIdleConns)
+	applied("conn_max_lifetime", s.ConnMaxLifetime != time.Duration(cfg.ConnMaxLifetime))
+	applied("conn_max_idle_time", s.ConnMaxIdleTime != time.Duration(cfg.ConnMaxIdleTime))
+	applied("reconnect_delay", s.ReconnectDelay != time.Duration(cfg.ReconnectDelay))
+	applied("connect_timeout", s.ConnectTimeout != time.Duration(cfg.ConnectTimeout))
+	applied("teardown_policy", s.TeardownPolicy != cfg.TeardownPolicy)
+	applied("teardown_linger", s.TeardownLinger != time.Duration(cfg.TeardownLinger))
+	applied("cache_ttl", s.CacheTTL != time.Duration(cfg.CacheTTL))
+	applied("after_release_retries", s.AfterReleaseRetries != cfg.AfterReleaseRetries)
+	applied("after_release_retry_delay", s.AfterReleaseRetryDelay != time.Duration(cfg.AfterReleaseRetryDelay))
+	applied("stream_max_duration", s.StreamMaxDuration != time.Duration(cfg.StreamMaxDuration))
+	applied("read_only", s.ReadOnly() != cfg.ReadOnly)
+	s.debug = cfg.Debug
+	s.UnlockTimeout = unlockTimeout
+	s.MaxOpenConns = cfg.MaxOpenConns
+	s.MaxIdleConns = cfg.MaxIdleConns
+	s.ConnMaxLifetime = time.Duration(cfg.ConnMaxLifetime)
+	s.ConnMaxIdleTime = time.Duration(cfg.ConnMaxIdleTime)
+	s.ReconnectDelay = time.Duration(cfg.ReconnectDelay)
+	s.ConnectTimeout = time.Duration(cfg.ConnectTimeout)
+	s.TeardownPolicy = cfg.TeardownPolicy
+	s.TeardownLinger = time.Duration(cfg.TeardownLinger)
+	s.CacheTTL = time.Duration(cfg.CacheTTL)
+	s.AfterReleaseRetries = cfg.AfterReleaseRetries
+	s.AfterReleaseRetryDelay = time.Duration(cfg.AfterReleaseRetryDelay)
+	s.StreamMaxDuration = time.Duration(cfg.StreamMaxDuration)
+	s.SetReadOnly(cfg.ReadOnly)
+	s.settingsMu.Unlock()
+
+	// Apply connection pool settings to existing database sessions
+	ids := make([]interface{}, 0, len(s.m))
+	for id, g := range s.m {
+		ids = append(ids, id)
+		if g.DB != nil {
+			s.applyPoolSettings(g.DB)
+		}
+	}
+	s.Unlock()
+
+	// Drain and close existing database sessions
+	if len(report.Drained) > 0 {
+		for _, id := range ids {
+			err = s.Evict(ctx, id)
+			if err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// WatchConfigFile checks the modification time of a JSON Config file every interval (or every 5 seconds if interval is not positive) until ctx is done,
+// and calls Reload (and then onReload, if not nil) whenever the file changes.
+func (s *Store) WatchConfigFile(ctx context.Context, path string, interval time.Duration, onReload func(report ReloadReport, err error)) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	var modTime time.Time
+	info, err := os.Stat(path)
+	if err == nil {
+		modTime = info.ModTime()
+	}
+
+	storeCtx := s.storeCtx()
+	ticker := time.NewTicker(interval)
+	s.spawn("reload", func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-storeCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+
+			var report ReloadReport
+			cfg, err := LoadConfigFile(path)
+			if err == nil {
+				report, err = s.Reload(ctx, cfg)
+			}
+			if onReload != nil {
+				onReload(report, err)
+			}
+		}
+	})
+}
+
+// settings is a snapshot of the Store settings that can be changed by Reload while the Store is running
+type settings struct {
+	driverName             string
+	statementTimeout       *time.Duration
+	lockTimeout            *time.Duration
+	unlockTimeout          *time.Duration
+	debug                  bool
+	maxOpenConns           int
+	maxIdleConns           int
+	connMaxLifetime        time.Duration
+	connMaxIdleTime        time.Duration
+	teardownPolicy         TeardownPolicy
+	teardownLinger         time.Duration
+	cacheTTL               time.Duration
+	afterReleaseRetries    int
+	afterReleaseRetryDelay time.Duration
+	streamMaxDuration      time.Duration
+	connectTimeout         time.Duration
+}
+
+// settings returns a snapshot of the Store settings that can be changed by Reload.
+// Code that runs while the Store is running uses settings (or holds the Store lock) rather than reading these Store fields directly.
+func (s *Store) settings() settings {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+
+	return settings{
+		driverName:             s.DriverName,
+		statementTimeout:       s.StatementTimeout,
+		lockTimeout:            s.LockTimeout,
+		unlockTimeout:          s.UnlockTimeout,
+		debug:                  s.debug,
+		maxOpenConns:           s.MaxOpenConns,
+		maxIdleConns:           s.MaxIdleConns,
+		connMaxLifetime:        s.ConnMaxLifetime,
+		connMaxIdleTime:        s.ConnMaxIdleTime,
+		teardownPolicy:         s.TeardownPolicy,
+		teardownLinger:         s.TeardownLinger,
+		cacheTTL:               s.CacheTTL,
+		afterReleaseRetries:    s.AfterReleaseRetries,
+		afterReleaseRetryDelay: s.AfterReleaseRetryDelay,
+		streamMaxDuration:      s.StreamMaxDuration,
+		connectTimeout:         s.ConnectTimeout,
+	}
+}
+
+func equalTimeouts(a, b *time.Duration) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
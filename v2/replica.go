@@ -0,0 +1,266 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Replica is a read replica of the database, to which read holds are routed (see the Store Replicas setting)
+type Replica struct {
+
+	// Name identifies the replica in ReplicaStatus, Hold.Replica, metrics, and hooks
+	Name string
+
+	// DataSourceName is the data source name of the replica, which is connected using the Store DriverName and Connector (see ConnectRequest Replica)
+	DataSourceName string
+}
+
+// ReplicaStatus describes the replication lag of a read replica, and whether read holds are routed to the replica
+type ReplicaStatus struct {
+	Name string
+
+	// Connected is true if the replica database is connected
+	Connected bool
+
+	// Lag is the replication lag measured at CheckedAt (zero if the database does not support measuring the replication lag, see Capabilities ReplicaLag)
+	Lag       time.Duration
+	CheckedAt time.Time
+
+	// Evicted is true if read holds are not routed to the replica, because the replica is not connected, because its replication lag could not be measured,
+	// or because its replication lag exceeds the Store ReplicaMaxLag
+	Evicted bool
+
+	// LastError is the error from the last attempt to connect to the replica or to measure its replication lag (nil if the last attempt succeeded)
+	LastError error
+}
+
+// ReplicaMetricsSink is optionally implemented by the Store MetricsSink to receive read replica metrics (see the Store Replicas setting),
+// for example exported to Prometheus as a dblocker_replica_lag_seconds gauge and a dblocker_replica_evicted gauge with a replica label
+type ReplicaMetricsSink interface {
+
+	// SetReplicaLag is called with the replication lag of a replica, and whether the replica is evicted from read routing, each time the replica is checked
+	SetReplicaLag(replica string, lag time.Duration, evicted bool)
+}
+
+// replicaSet is the read replicas of a run of the Store, which are connected and checked by the replicas goroutine of the run (see monitorReplicas)
+type replicaSet struct {
+	once sync.Once
+
+	// ready is closed once the replicas have been checked for the first time
+	ready chan struct{}
+
+	mu         sync.Mutex
+	replicas   []*replicaState
+	next       int
+	allEvicted bool
+}
+
+// replicaState is a read replica and its database
+type replicaState struct {
+	Replica
+	db       *sqlx.DB
+	attempts int
+	status   ReplicaStatus
+}
+
+// replicaSet returns the read replicas of the run, starting the replicas goroutine of the run if it has not been started,
+// and waiting until the replicas have been checked for the first time or ctx is done (in which case replicaSet returns nil)
+func (s *Store) replicaSet(ctx context.Context, run *storeRun) *replicaSet {
+	set := &run.replicas
+	set.once.Do(func() {
+		set.ready = make(chan struct{})
+		for _, r := range s.Replicas {
+			set.replicas = append(set.replicas, &replicaState{Replica: r, status: ReplicaStatus{Name: r.Name, Evicted: true}})
+		}
+		s.spawn("replicas", func() { s.monitorReplicas(run, set) })
+	})
+	select {
+	case <-set.ready:
+		return set
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// monitorReplicas checks the read replicas every ReplicaLagInterval (default 1 second) until the run is stopped, and then closes the replica databases
+func (s *Store) monitorReplicas(run *storeRun, set *replicaSet) {
+	defer func() {
+		set.mu.Lock()
+		defer set.mu.Unlock()
+		for _, r := range set.replicas {
+			if r.db != nil {
+				s.closeDB(r.db)
+				r.db = nil
+			}
+		}
+	}()
+
+	interval := s.ReplicaLagInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.checkReplicas(run.ctx, set, interval)
+	close(set.ready)
+	for {
+		select {
+		case <-run.ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkReplicas(run.ctx, set, interval)
+		}
+	}
+}
+
+// checkReplicas connects the read replicas which are not connected and measures the replication lag of each replica,
+// evicts the replicas which are not connected or whose lag exceeds the Store ReplicaMaxLag from read routing,
+// and calls Hooks.OnReplicasEvicted when every replica has been evicted (so that read holds are routed to the primary)
+func (s *Store) checkReplicas(ctx context.Context, set *replicaSet, timeout time.Duration) {
+	settings := s.settings()
+	spec, _ := LookupDriver(settings.driverName)
+	for _, r := range set.replicas {
+		set.mu.Lock()
+		db := r.db
+		set.mu.Unlock()
+
+		var err error
+		if db == nil {
+			r.attempts++
+			db, err = s.connectDB(ctx, ConnectRequest{
+				DriverName:       settings.driverName,
+				DataSourceName:   r.DataSourceName,
+				StatementTimeout: settings.statementTimeout,
+				Attempt:          r.attempts,
+				Replica:          r.Name,
+				Logger:           connectLogger,
+			})
+			if err != nil && ctx.Err() == nil {
+				fmt.Printf("dbLocker replica %s connect error: %s\n", r.Name, err.Error())
+			}
+		}
+
+		var lag time.Duration
+		if err == nil && spec.ReplicaLag != nil {
+			lagCtx, cancel := context.WithTimeout(ctx, timeout)
+			lag, err = spec.ReplicaLag(lagCtx, db)
+			cancel()
+		}
+		if err == nil && s.ReplicaMaxLag > 0 && spec.ReplicaLag == nil {
+			err = fmt.Errorf("replica lag error: replica lag for database type not implemented: %s", settings.driverName)
+		}
+
+		status := ReplicaStatus{
+			Name:      r.Name,
+			Connected: db != nil,
+			Lag:       lag,
+			CheckedAt: time.Now(),
+			Evicted:   err != nil || (s.ReplicaMaxLag > 0 && lag > s.ReplicaMaxLag),
+			LastError: err,
+		}
+		set.mu.Lock()
+		r.db = db
+		if db != nil {
+			r.attempts = 0
+		}
+		r.status = status
+		set.mu.Unlock()
+		if sink, ok := s.MetricsSink.(ReplicaMetricsSink); ok {
+			sink.SetReplicaLag(r.Name, lag, status.Evicted)
+		}
+	}
+
+	// Call the hook when the last replica is evicted
+	set.mu.Lock()
+	allEvicted := true
+	for _, r := range set.replicas {
+		allEvicted = allEvicted && r.status.Evicted
+	}
+	evicted := allEvicted && !set.allEvicted
+	set.allEvicted = allEvicted
+	statuses := set.statuses()
+	set.mu.Unlock()
+	if evicted && s.Hooks.OnReplicasEvicted != nil {
+		s.Hooks.OnReplicasEvicted(statuses)
+	}
+}
+
+// statuses returns the status of each replica.
+// The replicaSet must be locked when statuses is called.
+func (set *replicaSet) statuses() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(set.replicas))
+	for _, r := range set.replicas {
+		statuses = append(statuses, r.status)
+	}
+	return statuses
+}
+
+// routeRead returns the database of the next read replica which has not been evicted (in turn) for a read hold, and the name of the replica,
+// or returns the shared database session for the id (the primary) and "" if the Store has no Replicas or every replica has been evicted.
+// If the read hold presents a ConsistencyToken, only replicas which have replayed the write position of the token are used.
+func (s *Store) routeRead(ctx context.Context, parentCtx context.Context, primary *sqlx.DB) (db *sqlx.DB, replica string) {
+	if len(s.Replicas) == 0 {
+		return primary, ""
+	}
+	token, _ := ConsistencyTokenFromContext(parentCtx)
+	type candidate struct {
+		name string
+		db   *sqlx.DB
+		next int
+	}
+	var candidates []candidate
+	if set := s.replicaSet(ctx, s.currentRun()); set != nil {
+		set.mu.Lock()
+		for i := 0; i < len(set.replicas); i++ {
+			next := (set.next + i) % len(set.replicas)
+			r := set.replicas[next]
+			if !r.status.Evicted && r.db != nil {
+				candidates = append(candidates, candidate{name: r.Name, db: r.db, next: (next + 1) % len(set.replicas)})
+			}
+		}
+		if len(candidates) > 0 && token == "" {
+			set.next = candidates[0].next
+		}
+		set.mu.Unlock()
+	}
+
+	// Check that the replica has replayed the write position of the ConsistencyToken without holding the replicaSet lock
+	if token != "" {
+		var caughtUp []candidate
+		for _, c := range candidates {
+			if s.replayed(ctx, c.name, c.db, token) {
+				caughtUp = []candidate{c}
+				break
+			}
+		}
+		if len(candidates) > 0 && len(caughtUp) == 0 {
+			s.stats.consistencyFallbacks.Add(1)
+		}
+		candidates = caughtUp
+	}
+	if len(candidates) == 0 {
+		s.stats.primaryReads.Add(1)
+		return primary, ""
+	}
+	s.stats.replicaReads.Add(1)
+	return candidates[0].db, candidates[0].name
+}
+
+// ReplicaStatuses returns the status of each read replica (see the Store Replicas setting), in the order of the Store Replicas.
+// ReplicaStatuses returns no statuses until the first read hold has been requested since the Store was started.
+func (s *Store) ReplicaStatuses() []ReplicaStatus {
+	set := &s.currentRun().replicas
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return set.statuses()
+}
+
+// Replica returns the name of the read replica that the Hold was routed to, or "" if the Hold uses the shared database session for the id (see the Store Replicas setting)
+func (h *Hold) Replica() string {
+	return h.replica
+}
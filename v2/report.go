@@ -0,0 +1,113 @@
+package dblocker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reportTopContended is the maximum number of ids included in Report.TopContended
+const reportTopContended = 10
+
+// Report summarises the use of the Store during a single run (i.e. between Start and Stop, see Hooks.OnStop and Store.Report).
+// Report can be used, for example, by batch jobs that create a Store for each run and log a one-shot usage report when the Store is stopped.
+type Report struct {
+
+	// StartedAt is when the Store was started, and StoppedAt is when Stop was called (or zero if the Store has not been stopped)
+	StartedAt time.Time
+	StoppedAt time.Time
+
+	// Acquisitions is the number of requests that were granted access to the database
+	Acquisitions int64
+
+	// WaitTimeouts is the number of requests that timed out before being granted access (see OutcomeWaitTimeout),
+	// UnlockTimeouts is the number of holds that were released by the unlockTimeout (see OutcomeUnlockTimeout),
+	// and MaxHoldReleases is the number of holds that were force released after the MaxHoldDuration (see OutcomeMaxHoldDuration)
+	WaitTimeouts    int64
+	UnlockTimeouts  int64
+	MaxHoldReleases int64
+
+	// Failed is the number of requests that failed before being granted access for any other reason (e.g. cancelled, shed, unauthorized, or store closed)
+	Failed int64
+
+	// MaxGroups is the largest number of ids with shared database sessions at the same time
+	MaxGroups int
+
+	// TopContended are the ids with the most total wait time (see TopContended), and are only included if the Store ContentionWindow is set
+	TopContended []Contention
+}
+
+// runUsage counts the requests for a single run of the Store.
+// The counters are updated atomically so that counting does not delay database access requests.
+type runUsage struct {
+	sync.Mutex
+
+	startedAt time.Time
+	stoppedAt time.Time
+
+	acquisitions   atomic.Int64
+	waitTimeouts   atomic.Int64
+	unlockTimeouts atomic.Int64
+	maxHolds       atomic.Int64
+	failed         atomic.Int64
+	maxGroups      atomic.Int64
+}
+
+// Report returns a summary of the use of the Store since it was last started (see Report)
+func (s *Store) Report() Report {
+	return s.runReport(s.currentRun())
+}
+
+// runReport returns a summary of the use of the Store during a run
+func (s *Store) runReport(run *storeRun) Report {
+	u := &run.usage
+	u.Lock()
+	r := Report{
+		StartedAt: u.startedAt,
+		StoppedAt: u.stoppedAt,
+	}
+	u.Unlock()
+
+	r.Acquisitions = u.acquisitions.Load()
+	r.WaitTimeouts = u.waitTimeouts.Load()
+	r.UnlockTimeouts = u.unlockTimeouts.Load()
+	r.MaxHoldReleases = u.maxHolds.Load()
+	r.Failed = u.failed.Load()
+	r.MaxGroups = int(u.maxGroups.Load())
+	r.TopContended = s.TopContended(reportTopContended)
+	return r
+}
+
+// countWait counts a request wait outcome for the Report
+func (s *Store) countWait(outcome Outcome) {
+	u := &s.currentRun().usage
+	switch outcome {
+	case OutcomeGranted:
+		u.acquisitions.Add(1)
+	case OutcomeWaitTimeout:
+		u.waitTimeouts.Add(1)
+	default:
+		u.failed.Add(1)
+	}
+}
+
+// countHold counts a hold release outcome for the Report
+func (s *Store) countHold(outcome Outcome) {
+	switch outcome {
+	case OutcomeUnlockTimeout:
+		s.currentRun().usage.unlockTimeouts.Add(1)
+	case OutcomeMaxHoldDuration:
+		s.currentRun().usage.maxHolds.Add(1)
+	}
+}
+
+// countGroups records the number of groups for the Report
+func (s *Store) countGroups(groups int) {
+	u := &s.currentRun().usage
+	for {
+		maxGroups := u.maxGroups.Load()
+		if int64(groups) <= maxGroups || u.maxGroups.CompareAndSwap(maxGroups, int64(groups)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package dblocker
+
+import (
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Resources reports the resources currently used by a Store.
+// Resources can be used, for example, to check in tests that a Store has cleaned up after Stop.
+type Resources struct {
+
+	// Goroutines is the number of live goroutines started by the Store, and GoroutinesByKind is the number for each kind of goroutine
+	// ("group", "waiter", "release", "progress", "conn", "cleanup", "subscriber", "listener", "outbox", "batcher", "serializer", "reload", or "ticker")
+	Goroutines       int
+	GoroutinesByKind map[string]int
+
+	// OpenDBs is the number of databases opened by the Store which have not been closed
+	OpenDBs int
+
+	// Groups is the number of ids with a shared database session (each with its own request channels)
+	Groups int
+
+	// Passthroughs is the number of ReadPassthrough reads which have not been released
+	Passthroughs int
+
+	// Holds is the number of holds which have been granted and not yet released, and Waiters is the number of requests waiting to be granted
+	Holds   int
+	Waiters int
+}
+
+type resources struct {
+	sync.Mutex
+
+	goroutines map[string]int
+	openDBs    map[*sqlx.DB]struct{}
+
+	// reservations are the connections reserved from the Store ConnectionBudget by each open database
+	reservations map[*sqlx.DB]budgetReservation
+}
+
+// Resources returns the resources currently used by the Store
+func (s *Store) Resources() Resources {
+	var r Resources
+
+	s.resources.Lock()
+	r.GoroutinesByKind = make(map[string]int, len(s.resources.goroutines))
+	for kind, n := range s.resources.goroutines {
+		r.GoroutinesByKind[kind] = n
+		r.Goroutines += n
+	}
+	r.OpenDBs = len(s.resources.openDBs)
+	s.resources.Unlock()
+
+	s.Lock()
+	r.Groups = len(s.m)
+	r.Passthroughs = s.passthroughs
+	s.Unlock()
+
+	s.queues.Lock()
+	for _, q := range s.queues.m {
+		r.Holds += len(q.holds)
+		r.Waiters += len(q.waiters)
+	}
+	s.queues.Unlock()
+	return r
+}
+
+// spawn runs fn in a new goroutine which is counted in Resources
+func (s *Store) spawn(kind string, fn func()) {
+	s.resources.Lock()
+	if s.resources.goroutines == nil {
+		s.resources.goroutines = make(map[string]int)
+	}
+	s.resources.goroutines[kind]++
+	s.resources.Unlock()
+
+	go func() {
+		defer func() {
+			s.resources.Lock()
+			s.resources.goroutines[kind]--
+			if s.resources.goroutines[kind] == 0 {
+				delete(s.resources.goroutines, kind)
+			}
+			s.resources.Unlock()
+		}()
+		fn()
+	}()
+}
+
+// openedDB records a database opened by the Store
+func (s *Store) openedDB(db *sqlx.DB) {
+	s.resources.Lock()
+	defer s.resources.Unlock()
+
+	if s.resources.openDBs == nil {
+		s.resources.openDBs = make(map[*sqlx.DB]struct{})
+	}
+	s.resources.openDBs[db] = struct{}{}
+}
+
+// closedDB records that a database opened by the Store has been closed, and returns the connections reserved by the database to the Store ConnectionBudget
+func (s *Store) closedDB(db *sqlx.DB) {
+	s.resources.Lock()
+	delete(s.resources.openDBs, db)
+	r := s.resources.reservations[db]
+	delete(s.resources.reservations, db)
+	s.resources.Unlock()
+
+	r.release()
+}
+
+// movedDB records that a database opened by the Store is now closed by the successor Store (see Handover), together with the connections reserved by the database
+func (s *Store) movedDB(successor *Store, db *sqlx.DB) {
+	s.resources.Lock()
+	delete(s.resources.openDBs, db)
+	r := s.resources.reservations[db]
+	delete(s.resources.reservations, db)
+	s.resources.Unlock()
+
+	successor.openedDB(db)
+	successor.reservedDB(db, r)
+}
@@ -0,0 +1,110 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how RWGetDBWithRetry retries requests which fail for transient reasons (see IsRetryable)
+type RetryPolicy struct {
+
+	// AttemptTimeout optionally limits the time that each attempt waits for access (without limiting how long access is held once granted),
+	// so that a request which waits too long is retried rather than waiting until the request context is done
+	AttemptTimeout time.Duration
+
+	// InitialBackoff (default 10ms) is the maximum delay before the first retry, which doubles after each retry up to MaxBackoff (default 1 second).
+	// Each delay is chosen at random between zero and the maximum delay (full jitter), so that requests which failed together do not retry together.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// MaxAttempts optionally limits the number of attempts, where zero means retrying until the request context is done
+	MaxAttempts int
+
+	// Retryable optionally returns true for the errors which are retried (default IsRetryable)
+	Retryable func(err error) bool
+}
+
+// IsRetryable returns true for errors from requests which may succeed if retried:
+// requests which timed out while waiting for access (see RetryPolicy AttemptTimeout and WithWaitBudget), requests shed by wait time SLOs or deadlines (ErrShed and ErrShedDeadline),
+// RW requests which exceeded a FailFast WriteRateLimit (ErrRateLimited), and requests for databases which were unreachable (ErrUnreachable).
+// Requests which are not authorized, rejected, made to a closed or read-only Store, or which failed with a fatal connection error are not retried.
+func IsRetryable(err error) bool {
+	switch {
+	case errors.Is(err, ErrStoreClosed), errors.Is(err, ErrUnauthorized), errors.Is(err, ErrRejected), errors.Is(err, ErrReadOnlyStore),
+		errors.Is(err, ErrTagRequired), errors.Is(err, ErrMisuse), errors.Is(err, ErrFatalConnect):
+		return false
+	case errors.Is(err, ErrShed), errors.Is(err, ErrShedDeadline), errors.Is(err, ErrRateLimited), errors.Is(err, ErrUnreachable):
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrUnlockTimeout)
+}
+
+// RWGetDBWithRetry acts like RWGetDB, and retries requests which fail for transient reasons (see IsRetryable) using the RetryPolicy,
+// waiting a jittered, exponentially increasing delay between attempts, until access is granted or until the ctx deadline.
+// RWGetDBWithRetry returns the error of the last attempt if no attempt succeeds (including when the ctx deadline is sooner than the next retry).
+func (s *Store) RWGetDBWithRetry(id interface{}, ctx context.Context, tag string, policy RetryPolicy) (cancel context.CancelFunc, db *sql.DB, err error) {
+	err = s.retry(ctx, policy, func(attemptCtx context.Context) error {
+		cancel, db, err = s.RWGetDB(id, attemptCtx, tag)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cancel, db, nil
+}
+
+// retry calls attempt until it succeeds, returns an error which is not retried, ctx is done, or the RetryPolicy MaxAttempts is reached
+func (s *Store) retry(ctx context.Context, policy RetryPolicy, attempt func(ctx context.Context) error) error {
+	if ctx == nil {
+		return s.misuse("retry request with a nil context")
+	}
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	backoff := policy.InitialBackoff
+	if backoff <= 0 {
+		backoff = 10 * time.Millisecond
+	}
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Second
+	}
+
+	for attempts := 1; ; attempts++ {
+		attemptCtx := ctx
+		if policy.AttemptTimeout > 0 {
+			attemptCtx = withWaitDeadline(ctx, time.Now().Add(policy.AttemptTimeout))
+		}
+		err := attempt(attemptCtx)
+		if err == nil || ctx.Err() != nil || !retryable(err) || (policy.MaxAttempts > 0 && attempts >= policy.MaxAttempts) {
+			return err
+		}
+
+		// Do not retry if the ctx deadline is sooner than the retry
+		wait := time.Duration(rand.Int63n(int64(backoff)) + 1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return err
+		}
+		backoff = min(2*backoff, maxBackoff)
+
+		delay := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			delay.Stop()
+			return err
+		case <-delay.C:
+		}
+	}
+}
+
+// withWaitDeadline returns a copy of ctx with a wait deadline (see WithWaitBudget), keeping any sooner wait deadline of ctx
+func withWaitDeadline(ctx context.Context, waitDeadline time.Time) context.Context {
+	if current, ok := waitDeadlineFromContext(ctx); ok && current.Before(waitDeadline) {
+		return ctx
+	}
+	return context.WithValue(ctx, waitDeadlineKey{}, waitDeadline)
+}
@@ -0,0 +1,51 @@
+package dblocker
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Sampler selects the completed requests which are recorded in the recent events (see RecentEvents) and passed to the OnReleased hook (see the Store Sampler setting),
+// so that heavy traffic does not overwhelm the observability pipeline while rare slow and failed requests are always recorded.
+// Metrics (see MetricsSink), the Report, and the OnWriteReleased hook are not sampled.
+type Sampler struct {
+
+	// Rate is the fraction (from 0 to 1) of completed requests recorded for tags without a TagRates rule
+	Rate float64
+
+	// TagRates optionally sets the fraction of completed requests recorded for specific tags, replacing the Rate for those tags
+	TagRates map[string]float64
+
+	// SlowWait and SlowHold are the wait and hold times (zero disables) at or above which completed requests are always recorded (i.e. tail-based sampling)
+	SlowWait time.Duration
+	SlowHold time.Duration
+}
+
+// sample returns true if an Event is recorded using the Store Sampler.
+// All Events are recorded if the Sampler is not set, and Events for failed requests and holds released by the unlockTimeout are always recorded.
+func (s *Store) sample(ev Event) bool {
+	sampler := s.Sampler
+	if sampler == nil {
+		return true
+	}
+	switch {
+	case ev.Err != nil, ev.Outcome == OutcomeUnlockTimeout, ev.Outcome == OutcomeMaxHoldDuration, ev.Outcome == OutcomeConnectionLost:
+		return true
+	case sampler.SlowWait > 0 && ev.Wait >= sampler.SlowWait:
+		return true
+	case sampler.SlowHold > 0 && ev.Hold >= sampler.SlowHold:
+		return true
+	}
+	rate, ok := sampler.TagRates[ev.Tag]
+	if !ok {
+		rate = sampler.Rate
+	}
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	default:
+		return rand.Float64() < rate
+	}
+}
@@ -0,0 +1,255 @@
+package dblocker
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// SchedulerPolicy selects how the requests for each id are granted access (see the Store Scheduler setting).
+// BenchmarkSchedulers compares the policies under standard workloads.
+type SchedulerPolicy int
+
+const (
+
+	// SchedulerChannels grants requests using the group goroutine for each id, which receives requests from channels (default).
+	// Waiting RW and read requests are granted in random order.
+	SchedulerChannels SchedulerPolicy = iota
+
+	// SchedulerCond grants requests using a mutex and condition variable for each id.
+	// Waiting RW requests are granted before new read requests, so that RW requests are not starved by overlapping reads.
+	SchedulerCond
+
+	// SchedulerSemaphore grants requests using a weighted semaphore for each id, where read requests have weight 1 and RW requests have the full weight.
+	// Requests are granted in the order that they were made.
+	SchedulerSemaphore
+
+	// SchedulerPriority grants requests using a weighted semaphore for each id in the same way as SchedulerSemaphore,
+	// except that waiting requests are granted in order of priority (see WithPriority), and then in the order that they were made.
+	// The priority of a waiting request increases by one for each Store PriorityAging interval waited,
+	// so that low priority requests (e.g. background jobs) are eventually granted even for ids that always have higher priority requests waiting.
+	SchedulerPriority
+)
+
+// groupLock grants requests for an id when the Store Scheduler is not SchedulerChannels.
+// lock waits until the request can be granted or until ctx is done.
+type groupLock interface {
+	lock(ctx context.Context, read bool) error
+	unlock(read bool)
+}
+
+// newGroupLock returns the groupLock for a new group, or nil if requests are granted by the group goroutine
+func (s *Store) newGroupLock() groupLock {
+	switch s.Scheduler {
+	case SchedulerCond:
+		l := &condLock{}
+		l.cond.L = &l.mu
+		return l
+	case SchedulerSemaphore:
+		return &semaphoreLock{}
+	case SchedulerPriority:
+		aging := s.PriorityAging
+		if aging == 0 {
+			aging = time.Second
+		}
+		return &priorityLock{aging: aging}
+	default:
+		return nil
+	}
+}
+
+// lockGroup gets the group for an id and waits until the request is granted by the group lock and the shared database session for the id is connected,
+// retrying with a new Group if the Group is deleted (e.g. by Evict) before the request is granted.
+// The group lock is unlocked and the Group request count is decremented when ctx is done and released is closed (see watchRelease),
+// so that the group is not deleted while the request is granted.
+func (s *Store) lockGroup(id interface{}, accessType string, tag string, metadata Metadata, storeCtx, waitCtx, ctx context.Context, released chan struct{}) (g *Group, err error) {
+	read := AccessMode(accessType).isRead()
+	for {
+		var epoch int
+		g, epoch, err = s.getGroup(id, tag, metadata)
+		if err != nil {
+			return nil, err
+		}
+		err = g.lock.lock(waitCtx, read)
+		if err != nil {
+			s.releaseGroup(id, g, epoch)
+			return nil, waitError(storeCtx, waitCtx)
+		}
+
+		select {
+		case <-g.dbCh:
+			s.spawn("waiter", func() {
+				<-ctx.Done()
+				<-released
+				g.lock.unlock(read)
+				s.releaseUnscheduled(id, g, epoch)
+			})
+			return g, nil
+		case <-g.done:
+			g.lock.unlock(read)
+			s.releaseGroup(id, g, epoch)
+			if storeCtx.Err() != nil {
+				return nil, ErrStoreClosed
+			}
+			if g.err != nil {
+				return nil, g.err
+			}
+		case <-waitCtx.Done():
+			g.lock.unlock(read)
+			s.releaseGroup(id, g, epoch)
+			return nil, waitError(storeCtx, waitCtx)
+		}
+	}
+}
+
+// releaseUnscheduled decrements the Group request count for a request which was not granted by the group goroutine (see ReadPassthrough and SchedulerPolicy),
+// and notifies the group so that the group can be deleted if it is unused (see TeardownPolicy)
+func (s *Store) releaseUnscheduled(id interface{}, g *Group, epoch int) {
+	s.releaseGroup(id, g, epoch)
+
+	select {
+	case g.passthroughDoneCh <- struct{}{}:
+	default:
+	}
+}
+
+// condLock is a groupLock using a mutex and condition variable, which grants waiting RW requests before new read requests
+type condLock struct {
+	mu   sync.Mutex
+	cond sync.Cond
+
+	writer         bool
+	readers        int
+	writersWaiting int
+}
+
+func (l *condLock) lock(ctx context.Context, read bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Wake the waiting requests when ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	})
+	defer stop()
+
+	if !read {
+		l.writersWaiting++
+		defer func() { l.writersWaiting-- }()
+	}
+	for {
+		switch {
+		case ctx.Err() != nil:
+
+			// Read requests may be waiting for this RW request
+			l.cond.Broadcast()
+			return ctx.Err()
+		case read && !l.writer && l.writersWaiting == 0:
+			l.readers++
+			return nil
+		case !read && !l.writer && l.readers == 0:
+			l.writer = true
+			return nil
+		}
+		l.cond.Wait()
+	}
+}
+
+func (l *condLock) unlock(read bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if read {
+		l.readers--
+	} else {
+		l.writer = false
+	}
+	l.cond.Broadcast()
+}
+
+// semaphoreSize is the weight of a RW request for the semaphoreLock
+const semaphoreSize = 1 << 30
+
+// semaphoreLock is a groupLock using a weighted semaphore, which grants requests in the order that they were made
+type semaphoreLock struct {
+	mu sync.Mutex
+
+	cur     int64
+	waiters list.List
+}
+
+type semaphoreWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// semaphoreWeight returns the semaphore weight of a request
+func semaphoreWeight(read bool) int64 {
+	if read {
+		return 1
+	}
+	return semaphoreSize
+}
+
+func (l *semaphoreLock) lock(ctx context.Context, read bool) error {
+	n := semaphoreWeight(read)
+
+	l.mu.Lock()
+	if semaphoreSize-l.cur >= n && l.waiters.Len() == 0 {
+		l.cur += n
+		l.mu.Unlock()
+		return nil
+	}
+	w := semaphoreWaiter{n: n, ready: make(chan struct{})}
+	elem := l.waiters.PushBack(w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+
+		// The request was granted after ctx was done
+		case <-w.ready:
+			l.cur -= n
+		default:
+			l.waiters.Remove(elem)
+		}
+
+		// Later requests may be waiting for this request
+		l.notifyLocked()
+		return ctx.Err()
+	}
+}
+
+func (l *semaphoreLock) unlock(read bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cur -= semaphoreWeight(read)
+	l.notifyLocked()
+}
+
+// notifyLocked grants waiting requests in order while they fit in the semaphore.
+// The semaphoreLock must be locked when notifyLocked is called.
+func (l *semaphoreLock) notifyLocked() {
+	for {
+		next := l.waiters.Front()
+		if next == nil {
+			return
+		}
+		w := next.Value.(semaphoreWaiter)
+		if semaphoreSize-l.cur < w.n {
+			return
+		}
+		l.cur += w.n
+		l.waiters.Remove(next)
+		close(w.ready)
+	}
+}
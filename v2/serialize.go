@@ -0,0 +1,123 @@
+package dblocker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// serialJob is a job submitted using Serialize
+type serialJob struct {
+	ctx  context.Context
+	tag  string
+	job  func(ctx context.Context, db *sqlx.DB) error
+	done chan error
+}
+
+// serializer is the queue of jobs waiting to be run by the worker for an id
+type serializer struct {
+	jobs []serialJob
+}
+
+type serializers struct {
+	sync.Mutex
+
+	m map[interface{}]*serializer
+}
+
+// Serialize submits a job for the specified id and waits for it to finish.
+// Jobs for an id are run one at a time in submission order by a dedicated worker for the id,
+// which holds a RW hold for the id while it has jobs (rather than acquiring a RW hold for each job).
+// ctx is the job context, and is cancelled if the RW hold is released (e.g. after the unlockTimeout), in which case the worker acquires a new RW hold for later jobs.
+// Serialize returns the error returned by job, or an error if the job is not authorized (see Authorizer) or the RW hold could not be acquired.
+// Each job is authorized using its own ctx when it is submitted, and the worker acquires RW holds using the values (but not the cancellation) of the ctx of the next job.
+// If ctx is done before the job is run, Serialize returns the ctx error and the job is skipped.
+func (s *Store) Serialize(id interface{}, ctx context.Context, tag string, job func(ctx context.Context, db *sqlx.DB) error) error {
+	id = s.lockKey(id)
+	tag = s.requestTag(ctx, tag)
+	err := s.checkTag("rw", id, tag)
+	if err != nil {
+		return err
+	}
+	err = s.authorize(ctx, id, AccessRW, tag)
+	if err != nil {
+		return err
+	}
+
+	j := serialJob{
+		ctx:  ctx,
+		tag:  tag,
+		job:  job,
+		done: make(chan error, 1),
+	}
+
+	s.serializers.Lock()
+	if s.serializers.m == nil {
+		s.serializers.m = make(map[interface{}]*serializer)
+	}
+	sr, ok := s.serializers.m[id]
+	if !ok {
+		sr = &serializer{}
+		s.serializers.m[id] = sr
+		s.spawn("serializer", func() { s.runSerializer(id, sr) })
+	}
+	sr.jobs = append(sr.jobs, j)
+	s.serializers.Unlock()
+
+	select {
+	case err := <-j.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runSerializer runs the jobs for an id until there are no more jobs for the id
+func (s *Store) runSerializer(id interface{}, sr *serializer) {
+	var h *Hold
+	defer func() {
+		if h != nil {
+			h.Release()
+		}
+	}()
+
+	for {
+		s.serializers.Lock()
+		if len(sr.jobs) == 0 {
+			delete(s.serializers.m, id)
+			s.serializers.Unlock()
+			return
+		}
+		j := sr.jobs[0]
+		sr.jobs = sr.jobs[1:]
+		s.serializers.Unlock()
+
+		if j.ctx.Err() != nil {
+			j.done <- j.ctx.Err()
+			continue
+		}
+
+		// Acquire a new RW hold if required
+		if h != nil && h.Err() != nil {
+			h.Release()
+			h = nil
+		}
+		if h == nil {
+			var err error
+			h, err = s.RWHold(id, context.WithoutCancel(j.ctx), j.tag)
+			if err != nil {
+				h = nil
+				j.done <- err
+				continue
+			}
+		}
+
+		// Cancel the job context if the hold is released
+		ctx, cancel := context.WithCancel(j.ctx)
+		stop := context.AfterFunc(h.Context(), cancel)
+		j.done <- j.job(ctx, h.DB())
+		stop()
+		cancel()
+	}
+}
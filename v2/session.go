@@ -0,0 +1,105 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SessionResetPolicy controls how session state (e.g. SET variables, temporary tables, and advisory locks) is reset on the shared database session for an id between RW holds
+type SessionResetPolicy int
+
+const (
+
+	// SessionResetNone does not reset the shared database session (default)
+	SessionResetNone SessionResetPolicy = iota
+
+	// SessionResetDiscard runs the driver ResetSessionSQL (e.g. DISCARD ALL for postgres) on each idle connection of the shared database session before each RW hold is granted.
+	// Connections are recycled (as for SessionResetRecycle) if the driver does not have ResetSessionSQL (see RegisterDriver).
+	SessionResetDiscard
+
+	// SessionResetRecycle closes the idle connections of the shared database session before each RW hold is granted, so that the RW hold uses new connections.
+	// Do not use SessionResetRecycle with in-memory sqlite databases, which are deleted when their last connection is closed.
+	SessionResetRecycle
+)
+
+// String returns the session reset policy name
+func (p SessionResetPolicy) String() string {
+	switch p {
+	case SessionResetNone:
+		return "none"
+	case SessionResetDiscard:
+		return "discard"
+	case SessionResetRecycle:
+		return "recycle"
+	default:
+		return fmt.Sprintf("SessionResetPolicy(%d)", int(p))
+	}
+}
+
+// resetSession resets the session state of the shared database session for an RW hold using the Store SessionReset policy.
+// No other holds for the id are active when resetSession is called.
+func (s *Store) resetSession(ctx context.Context, id interface{}, db *sqlx.DB) error {
+	policy := s.SessionReset
+	if policy == SessionResetNone {
+		return nil
+	}
+	spec, _ := LookupDriver(s.settings().driverName)
+	if policy == SessionResetDiscard && spec.ResetSessionSQL != "" {
+		return s.discardSessions(ctx, id, db, spec)
+	}
+	s.recycleSessions(db)
+	return nil
+}
+
+// discardSessions runs the driver ResetSessionSQL on each idle connection of db,
+// and then sets the statement timeout for the id (see StatementTimeoutFor) and the Store LockTimeout again, as the ResetSessionSQL may also reset them (e.g. DISCARD ALL).
+// The idle connections are all acquired before the ResetSessionSQL is run so that each connection is reset once.
+func (s *Store) discardSessions(ctx context.Context, id interface{}, db *sqlx.DB, spec DriverSpec) error {
+	idle := db.Stats().Idle
+	conns := make([]*sqlx.Conn, 0, idle)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < idle; i++ {
+		conn, err := db.Connx(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+	}
+	statementTimeout := s.StatementTimeoutFor(id)
+	lockTimeout := s.settings().lockTimeout
+	for _, conn := range conns {
+		_, err := conn.ExecContext(ctx, spec.ResetSessionSQL)
+		if err != nil {
+			return err
+		}
+		if statementTimeout != nil && spec.SetStatementTimeout != nil {
+			err = spec.SetStatementTimeout(ctx, s.labelExecer(id, conn), *statementTimeout)
+			if err != nil {
+				return err
+			}
+		}
+		if lockTimeout != nil && spec.SetLockTimeout != nil {
+			err = spec.SetLockTimeout(ctx, s.labelExecer(id, conn), *lockTimeout)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recycleSessions closes the idle connections of db, and then restores the Store MaxIdleConns setting
+func (s *Store) recycleSessions(db *sqlx.DB) {
+	db.SetMaxIdleConns(0)
+	maxIdleConns := s.settings().maxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = 2
+	}
+	db.SetMaxIdleConns(maxIdleConns)
+}
@@ -0,0 +1,62 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// IDVariable returns a SessionVariables function (see the Store SessionVariables setting) which sets the session variable name (e.g. "app.tenant_id") to the id formatted using fmt "%v",
+// so that row-level security policies (e.g. USING (tenant_id = current_setting('app.tenant_id')::bigint)) scope queries to the id locked by the Hold
+func IDVariable(name string) func(ctx context.Context, id interface{}, metadata Metadata) (map[string]string, error) {
+	return func(ctx context.Context, id interface{}, metadata Metadata) (map[string]string, error) {
+		return map[string]string{name: fmt.Sprint(id)}, nil
+	}
+}
+
+// prepareTx sets the transaction timeouts (see setLocalTimeouts) and the session variables (see setLocalVariables) for a transaction begun using the Hold
+func (h *Hold) prepareTx(tx sqlx.ExecerContext) error {
+	err := h.setLocalTimeouts(tx)
+	if err != nil {
+		return err
+	}
+	return h.setLocalVariables(tx)
+}
+
+// setLocalVariables sets the session variables returned by the Store SessionVariables function for a transaction only (e.g. using set_config with is_local true), using the DriverSpec SetLocalVariables function
+func (h *Hold) setLocalVariables(tx sqlx.ExecerContext) error {
+	if h.s.SessionVariables == nil {
+		return nil
+	}
+	variables, err := h.s.SessionVariables(h.ctx, h.id, h.Metadata())
+	if err != nil {
+		return fmt.Errorf("session variables error: %w", err)
+	}
+	if len(variables) == 0 {
+		return nil
+	}
+	driverName := h.s.settings().driverName
+	spec, _ := LookupDriver(driverName)
+	if spec.SetLocalVariables == nil {
+		return fmt.Errorf("session variables error: transaction variables for database type not implemented: %s", driverName)
+	}
+	return spec.SetLocalVariables(h.ctx, h.s.labelExecer(h.id, tx), variables)
+}
+
+// setPostgresLocalVariables sets session variables for the current transaction only using set_config, in name order
+func setPostgresLocalVariables(ctx context.Context, tx sqlx.ExecerContext, variables map[string]string) error {
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err := tx.ExecContext(ctx, "SELECT set_config($1, $2, true);", name, variables[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
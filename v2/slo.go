@@ -0,0 +1,102 @@
+package dblocker
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrShed is returned for requests that are shed because a wait time SLO is being exceeded (see WaitSLOs)
+var ErrShed = errors.New("dblocker: request shed because a wait time SLO is being exceeded")
+
+// ErrShedDeadline is returned for requests that are shed because the request is not expected to be granted before its deadline (see ShedByDeadline)
+var ErrShedDeadline = errors.New("dblocker: request shed because it is not expected to be granted before its deadline")
+
+// waitSampleCount is the maximum number of recent wait times kept for each tag with a wait time SLO
+const waitSampleCount = 128
+
+type waitSample struct {
+	at   time.Time
+	wait time.Duration
+}
+
+// waitSamples are the recent wait times for a tag
+type waitSamples struct {
+	buf  [waitSampleCount]waitSample
+	next int
+	n    int
+}
+
+type slo struct {
+	sync.Mutex
+
+	samples map[string]*waitSamples
+}
+
+// WaitP99 returns the 99th percentile wait time for requests with the specified tag within the Store WaitSLOWindow.
+// Wait times are only recorded for tags in the Store WaitSLOs.
+func (s *Store) WaitP99(tag string) time.Duration {
+	s.slo.Lock()
+	defer s.slo.Unlock()
+
+	ws, ok := s.slo.samples[tag]
+	if !ok {
+		return 0
+	}
+	window := s.WaitSLOWindow
+	if window <= 0 {
+		window = time.Minute
+	}
+	cutoff := time.Now().Add(-window)
+
+	waits := make([]time.Duration, 0, ws.n)
+	for i := 0; i < ws.n; i++ {
+		if ws.buf[i].at.After(cutoff) {
+			waits = append(waits, ws.buf[i].wait)
+		}
+	}
+	if len(waits) == 0 {
+		return 0
+	}
+	sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+	return waits[(len(waits)*99)/100]
+}
+
+// recordWait records the wait time of a request for tags with a wait time SLO
+func (s *Store) recordWait(tag string, wait time.Duration) {
+	if _, ok := s.WaitSLOs[tag]; !ok {
+		return
+	}
+
+	s.slo.Lock()
+	defer s.slo.Unlock()
+
+	if s.slo.samples == nil {
+		s.slo.samples = make(map[string]*waitSamples)
+	}
+	ws, ok := s.slo.samples[tag]
+	if !ok {
+		ws = &waitSamples{}
+		s.slo.samples[tag] = ws
+	}
+	ws.buf[ws.next] = waitSample{at: time.Now(), wait: wait}
+	ws.next = (ws.next + 1) % waitSampleCount
+	if ws.n < waitSampleCount {
+		ws.n++
+	}
+}
+
+// shed returns true if a request with the specified tag should be shed,
+// i.e. if ShedTag returns true for the tag and the p99 wait time of any tag in the Store WaitSLOs exceeds its target.
+func (s *Store) shed(tag string) bool {
+	if s.ShedTag == nil || !s.ShedTag(tag) {
+		return false
+	}
+	for sloTag, target := range s.WaitSLOs {
+		if s.WaitP99(sloTag) > target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package dblocker
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// KeyProvider returns the encryption key for the encrypted sqlite (SQLCipher) database for an id
+type KeyProvider interface {
+	Key(ctx context.Context, id interface{}) (key string, err error)
+}
+
+// KeyProviderFunc is a function that implements KeyProvider
+type KeyProviderFunc func(ctx context.Context, id interface{}) (key string, err error)
+
+// Key returns the encryption key for the id
+func (f KeyProviderFunc) Key(ctx context.Context, id interface{}) (key string, err error) {
+	return f(ctx, id)
+}
+
+// withSQLCipherKey adds the encryption key to a SQLCipher data source name (as the _pragma_key parameter)
+func withSQLCipherKey(dataSourceName string, key string) string {
+	separator := "?"
+	if strings.Contains(dataSourceName, "?") {
+		separator = "&"
+	}
+	return dataSourceName + separator + "_pragma_key=" + url.QueryEscape(key)
+}
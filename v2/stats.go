@@ -0,0 +1,161 @@
+package dblocker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ConnectionStatus describes the attempts to connect the shared database session for an id
+type ConnectionStatus struct {
+	ID interface{}
+
+	// Connected is true if the last connection attempt succeeded
+	Connected bool
+
+	// Attempts is the total number of connection attempts, and Failures is the number of failed attempts since the last successful attempt
+	Attempts int
+	Failures int
+
+	// LastError is the error from the last failed attempt, and FailingSince is the time of the first failed attempt since the last successful attempt
+	LastError    error
+	LastErrorAt  time.Time
+	FailingSince time.Time
+
+	// LastSuccessAt is the time of the last successful attempt
+	LastSuccessAt time.Time
+}
+
+// Stats describes the current state of the Store
+type Stats struct {
+
+	// Groups is the number of ids with a group (i.e. with a shared database session or waiting to connect one)
+	Groups int
+
+	// Requests is the number of database access requests that are waiting or granted
+	Requests int64
+
+	// Streams is the number of granted StreamHold holds
+	Streams int
+
+	// ReadOnly is true if RW requests fail because the Store is read-only (see SetReadOnly)
+	ReadOnly bool
+
+	// ReplicaReads is the number of read holds routed to read replicas, and PrimaryReads is the number of read holds which used the primary because every replica was evicted,
+	// or because no replica had replayed the ConsistencyToken of the hold (ConsistencyFallbacks, see the Store Replicas setting)
+	ReplicaReads         int64
+	PrimaryReads         int64
+	ConsistencyFallbacks int64
+
+	// RepairedGroups is the number of orphaned groups removed, and RepairedRequestCounts is the number of group request counts reset, by the group janitor (see CheckGroups)
+	RepairedGroups        int64
+	RepairedRequestCounts int64
+
+	// Connections are the connection statuses of ids with a group or with failing connection attempts, ordered by the time of the last attempt (most recent first)
+	Connections []ConnectionStatus
+}
+
+// storeStats are the counters and connection statuses returned by Stats.
+// The counters are updated atomically, and connection statuses are replaced rather than modified, so that Stats and ConnectionStatus do not lock the Store
+// (and so frequent scraping of Stats does not delay database access requests).
+type storeStats struct {
+	groups   atomic.Int64
+	requests atomic.Int64
+	streams  atomic.Int64
+
+	replicaReads         atomic.Int64
+	primaryReads         atomic.Int64
+	consistencyFallbacks atomic.Int64
+
+	repairedGroups        atomic.Int64
+	repairedRequestCounts atomic.Int64
+
+	// connections maps ids to *ConnectionStatus, for ids with a group or with failing connection attempts
+	connections sync.Map
+}
+
+// Stats returns a snapshot of the current state of the Store.
+// Stats does not lock the Store, so the counts may be from slightly different times when requests are being made.
+func (s *Store) Stats() Stats {
+	stats := Stats{
+		Groups:   int(s.stats.groups.Load()),
+		Requests: s.stats.requests.Load(),
+		Streams:  int(s.stats.streams.Load()),
+		ReadOnly: s.ReadOnly(),
+
+		ReplicaReads:         s.stats.replicaReads.Load(),
+		PrimaryReads:         s.stats.primaryReads.Load(),
+		ConsistencyFallbacks: s.stats.consistencyFallbacks.Load(),
+
+		RepairedGroups:        s.stats.repairedGroups.Load(),
+		RepairedRequestCounts: s.stats.repairedRequestCounts.Load(),
+	}
+	s.stats.connections.Range(func(key, value interface{}) bool {
+		stats.Connections = append(stats.Connections, *value.(*ConnectionStatus))
+		return true
+	})
+	if stats.Connections == nil {
+		stats.Connections = []ConnectionStatus{}
+	}
+	sort.SliceStable(stats.Connections, func(i, j int) bool {
+		return stats.Connections[i].lastAttemptAt().After(stats.Connections[j].lastAttemptAt())
+	})
+	return stats
+}
+
+// ConnectionStatus returns the connection status for the specified id, and false if the id has no group and no failing connection attempts
+func (s *Store) ConnectionStatus(id interface{}) (status ConnectionStatus, ok bool) {
+	id = s.lockKey(id)
+	value, ok := s.stats.connections.Load(id)
+	if !ok {
+		return status, false
+	}
+	return *value.(*ConnectionStatus), true
+}
+
+// connectGroupDB connects the shared database session for an id and records the connection status
+func (s *Store) connectGroupDB(ctx context.Context, r ConnectRequest) (db *sqlx.DB, err error) {
+	startedAt := time.Now()
+	db, err = s.connectDB(ctx, r)
+	s.publishConnect(r, startedAt, err)
+
+	now := time.Now()
+	s.Lock()
+	defer s.Unlock()
+
+	// Replace the connection status, as Stats may be reading the current status
+	status := ConnectionStatus{ID: r.ID}
+	if value, ok := s.stats.connections.Load(r.ID); ok {
+		status = *value.(*ConnectionStatus)
+	}
+	defer func() { s.stats.connections.Store(r.ID, &status) }()
+
+	status.Attempts++
+	if err != nil {
+		if status.Failures == 0 {
+			status.FailingSince = now
+		}
+		status.Connected = false
+		status.Failures++
+		status.LastError = err
+		status.LastErrorAt = now
+		return nil, err
+	}
+	status.Connected = true
+	status.Failures = 0
+	status.FailingSince = time.Time{}
+	status.LastSuccessAt = now
+	return db, nil
+}
+
+// lastAttemptAt returns the time of the last connection attempt
+func (status ConnectionStatus) lastAttemptAt() time.Time {
+	if status.LastErrorAt.After(status.LastSuccessAt) {
+		return status.LastErrorAt
+	}
+	return status.LastSuccessAt
+}
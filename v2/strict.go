@@ -0,0 +1,64 @@
+package dblocker
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvariantViolation is returned (wrapped with details) when the Store is in Strict mode and a scheduler invariant is violated
+var ErrInvariantViolation = errors.New("dblocker invariant violation")
+
+// invariantViolation reports a scheduler invariant violation in Strict mode by calling the OnInvariantViolation hook (or logging the violation if the hook is not set), and returns the violation error
+func (s *Store) invariantViolation(id interface{}, format string, a ...interface{}) error {
+	err := fmt.Errorf("%w: id %v: %s", ErrInvariantViolation, id, fmt.Sprintf(format, a...))
+	if s.Hooks.OnInvariantViolation != nil {
+		s.Hooks.OnInvariantViolation(err)
+	} else {
+		fmt.Println("dbLocker strict error:", err.Error())
+	}
+	return err
+}
+
+// checkGrant checks, in Strict mode, that a hold is not granted while another hold for the id is active that should exclude it
+// (i.e. that RW holds are not granted while any other hold is active, and that read holds are not granted while a RW hold is active).
+// Released holds may not yet have been removed from the current holds, so only holds that have not been released are checked
+// (and holds which were released while being granted, e.g. when the request context is cancelled, are not checked).
+func (s *Store) checkGrant(h *Hold) error {
+
+	// A hold which was released while being granted is never used, so cannot overlap another hold
+	if !s.Strict || h.ctx.Err() != nil {
+		return nil
+	}
+
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	q, ok := s.queues.m[h.id]
+	if !ok {
+		return nil
+	}
+	isRead := AccessMode(h.accessType).isRead()
+	for other := range q.holds {
+		if other.ctx.Err() != nil {
+			continue
+		}
+		if !isRead || !AccessMode(other.accessType).isRead() {
+			return s.invariantViolation(h.id, "%s hold granted (tag %q) while %s hold active (tag %q)", h.accessType, h.tag, other.accessType, other.tag)
+		}
+	}
+	return nil
+}
+
+// closeGroupDone closes the done channel of a group.
+// In Strict mode, closing the channel more than once is reported as an invariant violation rather than panicking.
+func (s *Store) closeGroupDone(id interface{}, g *Group) {
+	if s.Strict {
+		select {
+		case <-g.done:
+			s.invariantViolation(id, "group done channel closed more than once")
+			return
+		default:
+		}
+	}
+	close(g.done)
+}
@@ -0,0 +1,173 @@
+package dblocker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventKind is the lifecycle stage described by an Event
+type EventKind string
+
+const (
+
+	// EventAcquired means that a hold was granted
+	EventAcquired EventKind = "acquired"
+
+	// EventReleased means that a hold was released (see the Event Outcome), other than by the unlockTimeout
+	EventReleased EventKind = "released"
+
+	// EventTimeout means that a request timed out before the hold was granted (OutcomeWaitTimeout), or that a hold was released when the unlockTimeout expired (OutcomeUnlockTimeout)
+	EventTimeout EventKind = "timeout"
+
+	// EventFailed means that a request failed before the hold was granted for another reason (see the Event Outcome and Err)
+	EventFailed EventKind = "failed"
+
+	// EventConnect means that the Store attempted to connect the shared database session for an id (Err is set if the attempt failed)
+	EventConnect EventKind = "connect"
+
+	// EventEvict means that the shared database session for an id was closed and the group for the id deleted (see TeardownPolicy and Evict)
+	EventEvict EventKind = "evict"
+)
+
+// EventFilter selects the Events delivered to a subscriber (see Subscribe).
+// Empty fields match every Event, and otherwise an Event matches if its ID, Tag, Mode, and Kind are each in the corresponding field.
+// Connect and evict Events have no Mode, so are not delivered to subscribers filtering by Modes.
+type EventFilter struct {
+	IDs   []interface{}
+	Tags  []string
+	Modes []AccessMode
+	Kinds []EventKind
+
+	// Buffer is the size of the subscriber channel (default 256). Events are dropped (rather than delaying requests) while the channel is full.
+	Buffer int
+}
+
+// match returns true if the Event is selected by the filter
+func (f EventFilter) match(ev Event) bool {
+	return matchAny(f.IDs, ev.ID) && matchAny(f.Tags, ev.Tag) && matchAny(f.Modes, ev.Mode) && matchAny(f.Kinds, ev.Kind)
+}
+
+// matchAny returns true if values is empty or contains v
+func matchAny[T comparable](values []T, v T) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+type eventSubscriber struct {
+	filter EventFilter
+	ch     chan Event
+}
+
+// eventSubscribers are the consumers of the Store event stream (see Subscribe)
+type eventSubscribers struct {
+	sync.RWMutex
+
+	m map[*eventSubscriber]struct{}
+
+	// count is the number of subscribers, so that Events are only built while there are subscribers
+	count atomic.Int32
+}
+
+// Subscribe returns a channel which receives the lifecycle Events of the Store (holds acquired and released, timeouts, failed requests, connection attempts, and evictions)
+// which match the filter, for in-process consumers such as custom dashboards and tests which assert on event sequences.
+// Unlike RecentEvents, subscribers receive every matching Event (which are not sampled, and are delivered when debug mode is off), in the order in which they occurred for each id.
+// Events are dropped while the channel is full, so consumers should receive promptly (or use a larger EventFilter Buffer).
+// The channel is closed when cancel is called.
+func (s *Store) Subscribe(filter EventFilter) (events <-chan Event, cancel func()) {
+	size := filter.Buffer
+	if size <= 0 {
+		size = 256
+	}
+	sub := &eventSubscriber{filter: filter, ch: make(chan Event, size)}
+
+	subs := &s.eventSubscribers
+	subs.Lock()
+	if subs.m == nil {
+		subs.m = make(map[*eventSubscriber]struct{})
+	}
+	subs.m[sub] = struct{}{}
+	subs.count.Add(1)
+	subs.Unlock()
+
+	var once sync.Once
+	cancel = func() {
+		once.Do(func() {
+			subs.Lock()
+			delete(subs.m, sub)
+			subs.count.Add(-1)
+			close(sub.ch)
+			subs.Unlock()
+		})
+	}
+	return sub.ch, cancel
+}
+
+// subscribed returns true if there are subscribers to the event stream
+func (s *Store) subscribed() bool {
+	return s.eventSubscribers.count.Load() > 0
+}
+
+// publish delivers an Event to the matching subscribers without blocking
+func (s *Store) publish(ev Event) {
+	if !s.subscribed() {
+		return
+	}
+	subs := &s.eventSubscribers
+	subs.RLock()
+	defer subs.RUnlock()
+
+	for sub := range subs.m {
+		if !sub.filter.match(ev) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+		}
+	}
+}
+
+// publishAcquired publishes an acquired Event for a granted Hold
+func (s *Store) publishAcquired(h *Hold) {
+	if !s.subscribed() {
+		return
+	}
+	s.publish(Event{
+		Kind:        EventAcquired,
+		ID:          h.id,
+		Tag:         h.tag,
+		Metadata:    h.Metadata(),
+		Mode:        AccessMode(h.accessType),
+		RequestedAt: h.requestedAt,
+		Wait:        h.grantedAt.Sub(h.requestedAt),
+		Outcome:     OutcomeGranted,
+	})
+}
+
+// publishConnect publishes a connect Event for an attempt to connect the shared database session for an id
+func (s *Store) publishConnect(r ConnectRequest, startedAt time.Time, err error) {
+	if !s.subscribed() {
+		return
+	}
+	ev := Event{
+		Kind:        EventConnect,
+		ID:          r.ID,
+		Tag:         r.Tag,
+		Metadata:    r.Metadata,
+		RequestedAt: startedAt,
+		Wait:        time.Since(startedAt),
+		Err:         err,
+	}
+	if err != nil {
+		ev.Outcome = OutcomeError
+	}
+	s.publish(ev)
+}
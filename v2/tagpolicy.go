@@ -0,0 +1,30 @@
+package dblocker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrTagRequired is returned (wrapped with details) for requests with an empty tag when the Store RequireTags setting is true
+var ErrTagRequired = errors.New("dblocker: tag required")
+
+// requestTag returns the tag of a request, using the Metadata component and operation of ctx (see WithMetadata) and then the Store DefaultTag if the tag is empty
+func (s *Store) requestTag(ctx context.Context, tag string) string {
+	if tag == "" {
+		metadata, _ := MetadataFromContext(ctx)
+		tag = metadata.String()
+	}
+	if tag == "" {
+		tag = s.DefaultTag
+	}
+	return tag
+}
+
+// checkTag returns an error wrapping ErrTagRequired if the tag is empty and the Store RequireTags setting is true
+func (s *Store) checkTag(accessType string, id interface{}, tag string) error {
+	if tag != "" || !s.RequireTags {
+		return nil
+	}
+	return fmt.Errorf("%w: %s request for id %v", ErrTagRequired, accessType, id)
+}
@@ -0,0 +1,57 @@
+package dblocker
+
+import (
+	"path"
+	"time"
+)
+
+// tagStatementTimeout returns the statement timeout for a tag from the Store TagStatementTimeouts, or nil if no tag or pattern matches the tag.
+// An exact match is used before patterns, and the longest matching pattern is used if more than one pattern matches.
+func (s *Store) tagStatementTimeout(tag string) *time.Duration {
+	if len(s.TagStatementTimeouts) == 0 {
+		return nil
+	}
+	if timeout, ok := s.TagStatementTimeouts[tag]; ok {
+		return &timeout
+	}
+	var match string
+	var timeout time.Duration
+	found := false
+	for pattern, patternTimeout := range s.TagStatementTimeouts {
+		ok, err := path.Match(pattern, tag)
+		if err != nil || !ok {
+			continue
+		}
+		if !found || len(pattern) > len(match) || (len(pattern) == len(match) && pattern < match) {
+			match, timeout, found = pattern, patternTimeout, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &timeout
+}
+
+// routeTagTimeout applies the TagStatementTimeouts statement timeout for the tag of a request (if any) when the database supports statement timeouts.
+// RW requests are routed to a new database session with the statement timeout (as for RWGetDBWithTimeout) unless TransactionPooling is true,
+// RWGetDBWithTimeout requests made with a nil statementTimeout use the statement timeout,
+// and the statement timeout is returned as the statement timeout of the Hold for other requests (see Hold.Conn and Hold.BeginTxx).
+func (s *Store) routeTagTimeout(accessType string, tag string, statementTimeout *time.Duration) (routedAccessType string, routedStatementTimeout *time.Duration, holdTimeout *time.Duration) {
+	timeout := s.tagStatementTimeout(tag)
+	if timeout == nil {
+		return accessType, statementTimeout, nil
+	}
+	caps, _ := DriverCapabilities(s.settings().driverName)
+	switch {
+	case !caps.StatementTimeout:
+		return accessType, statementTimeout, nil
+	case accessType == "rw" && !s.TransactionPooling:
+		return "rwseparate", timeout, nil
+	case accessType == "rwseparate" && statementTimeout == nil:
+		return accessType, timeout, nil
+	case accessType == "rwseparate":
+		return accessType, statementTimeout, nil
+	default:
+		return accessType, statementTimeout, timeout
+	}
+}
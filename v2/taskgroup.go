@@ -0,0 +1,65 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TaskGroup starts functions in new goroutines and collects their errors.
+// TaskGroup is implemented by *errgroup.Group (see golang.org/x/sync/errgroup), so that GoWithRW and GoWithRead can be used with errgroups without this package depending on errgroup.
+type TaskGroup interface {
+	Go(f func() error)
+}
+
+// GoWithRW starts fn in a goroutine of the TaskGroup (e.g. an errgroup.Group) while holding RW access to the specified id (see RWHold),
+// and returns the error from acquiring the hold or from fn to the TaskGroup.
+// fn is called with a context which is cancelled if the hold is force released (see Hold.BindContext) and with the database session of the hold.
+// The hold is released when fn returns (or panics), and when ctx is done (e.g. when another function of an errgroup created using errgroup.WithContext returns an error).
+// If the hold is released before fn returns (e.g. when the unlockTimeout expires or ctx is done), GoWithRW returns an error wrapping the hold error even if fn returns nil,
+// as fn may not have had access to the id for its whole duration.
+func (s *Store) GoWithRW(g TaskGroup, id interface{}, ctx context.Context, tag string, fn func(ctx context.Context, db *sqlx.DB) error) {
+	g.Go(func() error {
+		return s.runWithHold(id, ctx, tag, s.RWHold, fn)
+	})
+}
+
+// GoWithRead starts fn in a goroutine of the TaskGroup (e.g. an errgroup.Group) while holding read access to the specified id (see ReadHold and GoWithRW)
+func (s *Store) GoWithRead(g TaskGroup, id interface{}, ctx context.Context, tag string, fn func(ctx context.Context, db *sqlx.DB) error) {
+	g.Go(func() error {
+		return s.runWithHold(id, ctx, tag, s.ReadHold, fn)
+	})
+}
+
+// runWithHold calls fn while holding access to the specified id, and releases the hold when fn returns
+func (s *Store) runWithHold(
+	id interface{},
+	ctx context.Context,
+	tag string,
+	request func(id interface{}, ctx context.Context, tag string) (*Hold, error),
+	fn func(ctx context.Context, db *sqlx.DB) error,
+) error {
+	h, err := request(id, ctx, tag)
+	if err != nil {
+		return err
+	}
+	defer h.Release()
+
+	holdCtx, cancel := h.BindContext(ctx)
+	defer cancel()
+	err = fn(holdCtx, h.DB())
+	if err != nil {
+		return err
+	}
+
+	// The hold is also released when ctx is done (see bindOwner), which may not yet be reported by h.Err
+	if holdCtx.Err() != nil {
+		err = h.Err()
+		if err == nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("hold for id %v (tag %q) released before fn returned: %w", h.ID(), h.Tag(), err)
+	}
+	return nil
+}
@@ -6,17 +6,17 @@ import (
 	"time"
 )
 
-func (s *Store) ticker(parentCtx context.Context, tag string) context.CancelFunc {
+func (s *Store) ticker(storeCtx context.Context, parentCtx context.Context, tag string) context.CancelFunc {
 	ticker := time.NewTicker(2 * time.Second)
 	ctx, cancel := context.WithCancel(parentCtx)
-	go func() {
+	s.spawn("ticker", func() {
 		defer ticker.Stop()
 
 		startTime := time.Now()
 		count := 0
 		for {
 			select {
-			case <-s.Ctx.Done():
+			case <-storeCtx.Done():
 				return
 			case <-ctx.Done():
 				return
@@ -25,6 +25,6 @@ func (s *Store) ticker(parentCtx context.Context, tag string) context.CancelFunc
 				count++
 			}
 		}
-	}()
+	})
 	return cancel
 }
@@ -0,0 +1,107 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Tiered composes Stores (e.g. an in-process Store and a distributed Store) so that each request must be granted by every Store.
+// Holds are acquired from the Stores in order and released in reverse order.
+// If a Store fails to grant a request, the holds already acquired from the earlier Stores are released.
+type Tiered struct {
+	Stores []*Store
+}
+
+// TieredHold is a granted Tiered request, holding one Hold for each Store
+type TieredHold struct {
+	Holds []*Hold
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewTiered composes the Stores in the order that holds are acquired
+func NewTiered(stores ...*Store) *Tiered {
+	return &Tiered{
+		Stores: stores,
+	}
+}
+
+// RWHold returns a TieredHold with RWHold access to every Store for the specified id
+func (t *Tiered) RWHold(id interface{}, ctx context.Context, tag string) (th *TieredHold, err error) {
+	return t.hold(id, ctx, tag, (*Store).RWHold)
+}
+
+// ReadHold returns a TieredHold with ReadHold access to every Store for the specified id
+func (t *Tiered) ReadHold(id interface{}, ctx context.Context, tag string) (th *TieredHold, err error) {
+	return t.hold(id, ctx, tag, (*Store).ReadHold)
+}
+
+// RWGetDBx returns the shared database session (*sqlx.DB) of the first Store, after exclusive access to the specified id is granted by every Store
+func (t *Tiered) RWGetDBx(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sqlx.DB, err error) {
+	th, err := t.RWHold(id, ctx, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	return th.Release, th.DB(), nil
+}
+
+// ReadGetDBx returns the shared database session (*sqlx.DB) of the first Store, after shared access to the specified id is granted by every Store
+func (t *Tiered) ReadGetDBx(id interface{}, ctx context.Context, tag string) (cancel context.CancelFunc, db *sqlx.DB, err error) {
+	th, err := t.ReadHold(id, ctx, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	return th.Release, th.DB(), nil
+}
+
+func (t *Tiered) hold(id interface{}, ctx context.Context, tag string, holdFunc func(s *Store, id interface{}, ctx context.Context, tag string) (*Hold, error)) (th *TieredHold, err error) {
+	if len(t.Stores) == 0 {
+		return nil, fmt.Errorf("tiered error: no stores")
+	}
+
+	th = &TieredHold{}
+	for i, s := range t.Stores {
+		h, err := holdFunc(s, id, ctx, tag)
+		if err != nil {
+			th.Release()
+			return nil, fmt.Errorf("tiered error: store %d: %w", i, err)
+		}
+		th.Holds = append(th.Holds, h)
+	}
+
+	// Release every hold when any hold is released (e.g. when the unlockTimeout of one Store expires)
+	th.ctx, th.cancel = context.WithCancel(context.Background())
+	for _, h := range th.Holds {
+		go func(h *Hold) {
+			select {
+			case <-h.Context().Done():
+				th.Release()
+			case <-th.ctx.Done():
+			}
+		}(h)
+	}
+	return th, nil
+}
+
+// DB returns the database session (*sqlx.DB) of the first Store
+func (th *TieredHold) DB() *sqlx.DB {
+	return th.Holds[0].DB()
+}
+
+// Context returns a context that is cancelled when the TieredHold is released
+func (th *TieredHold) Context() context.Context {
+	return th.ctx
+}
+
+// Release releases the holds in reverse order.  Release can be called more than once.
+func (th *TieredHold) Release() {
+	for i := len(th.Holds) - 1; i >= 0; i-- {
+		th.Holds[i].Release()
+	}
+	if th.cancel != nil {
+		th.cancel()
+	}
+}
@@ -0,0 +1,96 @@
+package dblocker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// holdOwner binds the context of a Hold to the context of the current owner of the Hold (initially the request context),
+// so that the Hold is released when the current owner's context is done (see Hold.Transfer)
+type holdOwner struct {
+	mu sync.Mutex
+
+	ctx        context.Context
+	stop       func() bool
+	cancel     context.CancelCauseFunc
+	generation int
+
+	// ignoreDeadline is true if the deadline of the owner's context does not release the Hold (see DeadlineUnlockTimeout)
+	ignoreDeadline bool
+}
+
+// bindOwner returns a context which has the values of parentCtx, and which is cancelled (with the parentCtx error as the cause) when the context of the current owner is done
+// (unless ignoreDeadline is true and the deadline of the owner's context expired)
+func bindOwner(parentCtx context.Context, ignoreDeadline bool) (ctx context.Context, o *holdOwner) {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(parentCtx))
+	o = &holdOwner{cancel: cancel, ignoreDeadline: ignoreDeadline}
+	o.bind(parentCtx)
+	return ctx, o
+}
+
+// bind makes ctx the context of the current owner.
+// The holdOwner must be locked (or not yet shared) when bind is called.
+func (o *holdOwner) bind(ctx context.Context) {
+	o.ctx = ctx
+	o.stop = context.AfterFunc(ctx, func() {
+		if o.ignoreDeadline && ctx.Err() == context.DeadlineExceeded {
+			return
+		}
+		o.cancel(context.Cause(ctx))
+	})
+}
+
+// unbind stops watching the context of the current owner once the Hold context is done
+func (o *holdOwner) unbind() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stop()
+}
+
+// Transfer transfers ownership of the Hold to the owner of ctx (e.g. a worker which completes a request started by an HTTP handler),
+// and returns a release function for the new owner.
+// After Transfer, the Hold is released when ctx is done (rather than when the previous owner's context is done),
+// Release() calls by previous owners are ignored, and the returned release function (which can be called more than once) releases the Hold.
+// The Metadata of ctx (if any, see WithMetadata) replaces the Metadata of the Hold, so that release events and hooks are attributed to the new owner,
+// and ctx is used for the InheritDeadline setting.
+// Call Transfer from the goroutine of the new owner, as the stack of the Hold (see Blocker and MaxHoldDuration, in debug mode)
+// and the pprof labels of the Hold (see the Store ProfileLabels setting) are moved to the goroutine which calls Transfer.
+// The unlockTimeout still applies from when the Hold was granted.
+// Transfer returns an error if the Hold has already been released, or if the previous owner's context is already done.
+func (h *Hold) Transfer(ctx context.Context) (release func(), err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.ctx.Err() != nil {
+		return nil, fmt.Errorf("transfer error: hold already released: %s", h.tag)
+	}
+	o := h.owner
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.stop() {
+		return nil, fmt.Errorf("transfer error: owner context done: %s", h.tag)
+	}
+	o.bind(ctx)
+	o.generation++
+	generation := o.generation
+
+	if metadata, ok := MetadataFromContext(ctx); ok {
+		h.metadata = metadata
+	}
+
+	// Attribute the Hold to the goroutine of the new owner
+	h.stack = h.s.captureStack()
+	h.labelOwner(ctx)
+	return func() { h.release(generation) }, nil
+}
+
+// ownerCtx returns the context of the current owner of the Hold
+func (h *Hold) ownerCtx() context.Context {
+	if h.owner == nil {
+		return h.parentCtx
+	}
+	h.owner.mu.Lock()
+	defer h.owner.mu.Unlock()
+	return h.owner.ctx
+}
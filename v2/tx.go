@@ -0,0 +1,62 @@
+package dblocker
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// RWGetTx waits for exclusive access to the shared database session for the specified id (in the same way as RWGetDB), and begins a transaction
+// using a connection from the shared database session which is pinned to the request (see Hold.Conn), for call sites which only accept a *sql.Tx.
+// RWGetTx acts like Lock() for a RWMutex for the specified id until release is called.
+// release(err) commits the transaction if err is nil, or otherwise rolls back the transaction and returns err, and then releases the lock for the id.
+// release returns the commit error if the transaction could not be committed (an error wrapping the Hold error, such as ErrUnlockTimeout, if the lock was force released before release was called,
+// in which case the transaction has been rolled back). Calling release more than once has no further effect, and returns err.
+// The session variables returned by the Store SessionVariables function are set for the transaction, and if the Store TransactionPooling setting is true,
+// the statement and lock timeouts are set for the transaction (see Hold.BeginTxx).
+func (s *Store) RWGetTx(id interface{}, ctx context.Context, tag string, opts *sql.TxOptions) (release func(err error) error, tx *sql.Tx, err error) {
+	h, err := s.RWHold(id, ctx, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := h.Conn(nil)
+	if err != nil {
+		h.Release()
+		return nil, nil, err
+	}
+	tx, err = conn.BeginTx(h.ctx, opts)
+	if err != nil {
+		h.Release()
+		return nil, nil, err
+	}
+	err = h.prepareTx(tx)
+	if err != nil {
+		tx.Rollback()
+		h.Release()
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	release = func(err error) error {
+		result := err
+		once.Do(func() {
+			if err != nil {
+				tx.Rollback()
+				h.releaseChecked()
+				return
+			}
+
+			// Commit before releasing the Hold, as releasing the Hold rolls back the transaction
+			commitErr := tx.Commit()
+			releaseErr := h.releaseChecked()
+			if commitErr != nil {
+				result = commitErr
+				if releaseErr != nil {
+					result = releaseErr
+				}
+			}
+		})
+		return result
+	}
+	return release, tx, nil
+}
@@ -0,0 +1,142 @@
+package dblocker
+
+import (
+	"context"
+	"time"
+)
+
+// Defaults are the default tag prefix, Metadata, and timeouts for the requests made using a View (see WithDefaults)
+type Defaults struct {
+
+	// TagPrefix is added to the tag of each request (e.g. "billing.").
+	// Requests made with an empty tag use the Metadata component and operation as the tag (or the TagPrefix if these are not set).
+	TagPrefix string
+
+	// Metadata fills the fields (and attributes) that are not set by the Metadata of the request context (see WithMetadata)
+	Metadata Metadata
+
+	// Timeout is the timeout for requests made with a context without a deadline (zero means no timeout).
+	// The Timeout includes the time spent waiting for access, and releases the Hold in the same way as a request context deadline (see DeadlinePolicy).
+	Timeout time.Duration
+
+	// StatementTimeout is the statement timeout for RWHoldWithTimeout requests made with a nil statementTimeout
+	StatementTimeout *time.Duration
+}
+
+// View is a handle for making requests using a Store with Defaults, which can be injected into each component of a service.
+// Views share the groups (i.e. the locks and shared database sessions) of the Store.
+type View struct {
+	s        *Store
+	defaults Defaults
+}
+
+// WithDefaults returns a View of the Store which makes requests using the defaults
+func (s *Store) WithDefaults(defaults Defaults) *View {
+	return &View{s: s, defaults: defaults}
+}
+
+// WithDefaults returns a View of the same Store with the TagPrefix added to the View TagPrefix,
+// the Metadata filling the fields that are not set by the View Metadata, and the timeouts replacing the View timeouts if set
+func (v *View) WithDefaults(defaults Defaults) *View {
+	merged := v.defaults
+	merged.TagPrefix += defaults.TagPrefix
+	merged.Metadata = mergeMetadata(merged.Metadata, defaults.Metadata)
+	if defaults.Timeout != 0 {
+		merged.Timeout = defaults.Timeout
+	}
+	if defaults.StatementTimeout != nil {
+		merged.StatementTimeout = defaults.StatementTimeout
+	}
+	return &View{s: v.s, defaults: merged}
+}
+
+// Store returns the Store of the View
+func (v *View) Store() *Store {
+	return v.s
+}
+
+// RWHold returns a RW Hold (see Store.RWHold) using the View defaults
+func (v *View) RWHold(id interface{}, ctx context.Context, tag string) (h *Hold, err error) {
+	return v.hold(id, ctx, tag, v.s.RWHold)
+}
+
+// RWHoldWithTimeout returns a RW Hold with a new database session (see Store.RWHoldWithTimeout) using the View defaults
+func (v *View) RWHoldWithTimeout(id interface{}, ctx context.Context, tag string, statementTimeout *time.Duration) (h *Hold, err error) {
+	if statementTimeout == nil {
+		statementTimeout = v.defaults.StatementTimeout
+	}
+	return v.hold(id, ctx, tag, func(id interface{}, ctx context.Context, tag string) (*Hold, error) {
+		return v.s.RWHoldWithTimeout(id, ctx, tag, statementTimeout)
+	})
+}
+
+// ReadHold returns a read Hold (see Store.ReadHold) using the View defaults
+func (v *View) ReadHold(id interface{}, ctx context.Context, tag string) (h *Hold, err error) {
+	return v.hold(id, ctx, tag, v.s.ReadHold)
+}
+
+// StreamHold returns a stream Hold (see Store.StreamHold) using the View defaults
+func (v *View) StreamHold(id interface{}, ctx context.Context, tag string) (h *Hold, err error) {
+	return v.hold(id, ctx, tag, v.s.StreamHold)
+}
+
+// hold makes a request using the View defaults
+func (v *View) hold(id interface{}, ctx context.Context, tag string, request func(id interface{}, ctx context.Context, tag string) (*Hold, error)) (h *Hold, err error) {
+	if ctx == nil {
+		return request(id, ctx, tag)
+	}
+
+	// Add the default Metadata and tag prefix
+	md, _ := MetadataFromContext(ctx)
+	md = mergeMetadata(md, v.defaults.Metadata)
+	ctx = WithMetadata(ctx, md)
+	switch {
+	case tag != "":
+		tag = v.defaults.TagPrefix + tag
+	case md.String() != "":
+		tag = md.String()
+	default:
+		tag = v.defaults.TagPrefix
+	}
+
+	// Add the default timeout, which is cancelled when the Hold is released
+	if _, ok := ctx.Deadline(); !ok && v.defaults.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.defaults.Timeout)
+		h, err = request(id, ctx, tag)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		context.AfterFunc(h.Context(), cancel)
+		return h, nil
+	}
+	return request(id, ctx, tag)
+}
+
+// mergeMetadata returns md with the fields (and attributes) that are not set filled from defaults
+func mergeMetadata(md Metadata, defaults Metadata) Metadata {
+	if md.Component == "" {
+		md.Component = defaults.Component
+	}
+	if md.Operation == "" {
+		md.Operation = defaults.Operation
+	}
+	if md.RequestID == "" {
+		md.RequestID = defaults.RequestID
+	}
+	if md.Principal == "" {
+		md.Principal = defaults.Principal
+	}
+	if len(defaults.Attributes) > 0 {
+		attributes := make(map[string]string, len(md.Attributes)+len(defaults.Attributes))
+		for k, val := range defaults.Attributes {
+			attributes[k] = val
+		}
+		for k, val := range md.Attributes {
+			attributes[k] = val
+		}
+		md.Attributes = attributes
+	}
+	return md
+}
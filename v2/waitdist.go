@@ -0,0 +1,161 @@
+package dblocker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// waitBucketBounds are the upper bounds of the WaitDistribution buckets
+var waitBucketBounds = []time.Duration{
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// WaitDistribution is the distribution of the time spent waiting for access by the requests with a tag and access mode (including requests that timed out or were cancelled while waiting)
+type WaitDistribution struct {
+	Tag  string
+	Mode AccessMode
+
+	// Count is the number of requests, TotalWait is the total time spent waiting, and MaxWait is the longest wait
+	Count     int64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+
+	// Buckets are the number of requests that waited for at most each upper bound (i.e. cumulative counts, as for Prometheus histograms).
+	// Requests that waited for longer than the largest upper bound are only included in the Count.
+	Buckets []WaitBucket
+}
+
+// WaitBucket is the number of requests that waited for at most the UpperBound
+type WaitBucket struct {
+	UpperBound time.Duration
+	Count      int64
+}
+
+// Mean returns the mean wait time
+func (d WaitDistribution) Mean() time.Duration {
+	if d.Count == 0 {
+		return 0
+	}
+	return d.TotalWait / time.Duration(d.Count)
+}
+
+// Quantile returns an estimate of the q quantile (from 0 to 1) of the wait times, which is the upper bound of the bucket containing the quantile
+// (or the MaxWait if the quantile is above the largest upper bound)
+func (d WaitDistribution) Quantile(q float64) time.Duration {
+	if d.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(d.Count))
+	if rank < 1 {
+		rank = 1
+	}
+	for _, b := range d.Buckets {
+		if b.Count >= rank {
+			if b.UpperBound > d.MaxWait {
+				return d.MaxWait
+			}
+			return b.UpperBound
+		}
+	}
+	return d.MaxWait
+}
+
+type waitDistributionKey struct {
+	tag  string
+	mode AccessMode
+}
+
+// waitHistogram is the recorded distribution for a tag and access mode, with non-cumulative bucket counts
+type waitHistogram struct {
+	count     int64
+	totalWait time.Duration
+	maxWait   time.Duration
+	buckets   []int64
+}
+
+type waitDistributions struct {
+	sync.Mutex
+
+	m map[waitDistributionKey]*waitHistogram
+}
+
+// WaitDistributions returns the wait time distributions for each tag and access mode since the Store was created, ordered by total wait time (most first).
+// WaitDistributions can be used, for example, to find the tags whose waits dominate (e.g. "report-export") and to set per-tag quotas.
+// WaitDistributions returns no distributions unless the Store RecordWaitDistributions setting is true.
+func (s *Store) WaitDistributions() []WaitDistribution {
+	s.waitDistributions.Lock()
+	defer s.waitDistributions.Unlock()
+
+	distributions := make([]WaitDistribution, 0, len(s.waitDistributions.m))
+	for key, h := range s.waitDistributions.m {
+		d := WaitDistribution{
+			Tag:       key.tag,
+			Mode:      key.mode,
+			Count:     h.count,
+			TotalWait: h.totalWait,
+			MaxWait:   h.maxWait,
+			Buckets:   make([]WaitBucket, len(waitBucketBounds)),
+		}
+		var cumulative int64
+		for i, bound := range waitBucketBounds {
+			cumulative += h.buckets[i]
+			d.Buckets[i] = WaitBucket{UpperBound: bound, Count: cumulative}
+		}
+		distributions = append(distributions, d)
+	}
+	sort.Slice(distributions, func(i, j int) bool {
+		if distributions[i].TotalWait != distributions[j].TotalWait {
+			return distributions[i].TotalWait > distributions[j].TotalWait
+		}
+		if distributions[i].Tag != distributions[j].Tag {
+			return distributions[i].Tag < distributions[j].Tag
+		}
+		return distributions[i].Mode < distributions[j].Mode
+	})
+	return distributions
+}
+
+// recordWaitDistribution records the wait time of a request for WaitDistributions if the Store RecordWaitDistributions setting is true.
+// Requests that were shed, not authorized, or rejected did not wait in the queue, so are not recorded.
+func (s *Store) recordWaitDistribution(tag string, mode AccessMode, wait time.Duration, outcome Outcome) {
+	if !s.RecordWaitDistributions || outcome == OutcomeShed || outcome == OutcomeUnauthorized || outcome == OutcomeRejected || outcome == OutcomeReadOnly {
+		return
+	}
+
+	s.waitDistributions.Lock()
+	defer s.waitDistributions.Unlock()
+
+	if s.waitDistributions.m == nil {
+		s.waitDistributions.m = make(map[waitDistributionKey]*waitHistogram)
+	}
+	key := waitDistributionKey{tag: tag, mode: mode}
+	h, ok := s.waitDistributions.m[key]
+	if !ok {
+		h = &waitHistogram{buckets: make([]int64, len(waitBucketBounds))}
+		s.waitDistributions.m[key] = h
+	}
+	h.count++
+	h.totalWait += wait
+	if wait > h.maxWait {
+		h.maxWait = wait
+	}
+	i := sort.Search(len(waitBucketBounds), func(i int) bool { return wait <= waitBucketBounds[i] })
+	if i < len(waitBucketBounds) {
+		h.buckets[i]++
+	}
+}
@@ -0,0 +1,61 @@
+package dblocker
+
+import (
+	"context"
+	"time"
+)
+
+// WaitIdle waits until no database access requests are waiting or granted for any id (including ReadPassthrough reads),
+// and the release hooks of every released Hold have finished, or until ctx is done.
+// WaitIdle can be used, for example, at the end of a test to check that every Hold has been released (see the testsupport package).
+func (s *Store) WaitIdle(ctx context.Context) error {
+	return waitUntil(ctx, s.idle)
+}
+
+// WaitUnlocked waits until no database access requests are waiting or granted for the specified id,
+// and the release hooks of every released Hold for the id have finished, or until ctx is done.
+func (s *Store) WaitUnlocked(ctx context.Context, id interface{}) error {
+	id = s.lockKey(id)
+	return waitUntil(ctx, func() bool { return s.unlocked(id) })
+}
+
+// idle returns true if no requests are waiting or granted for any id, and no Hold is still being released
+func (s *Store) idle() bool {
+	if s.stats.requests.Load() > 0 {
+		return false
+	}
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	return len(s.queues.m) == 0
+}
+
+// unlocked returns true if no requests are waiting or granted for the id, and no Hold for the id is still being released
+func (s *Store) unlocked(id interface{}) bool {
+	s.Lock()
+	g, ok := s.m[id]
+	busy := ok && g.requestCount > 0
+	s.Unlock()
+	if busy {
+		return false
+	}
+	s.queues.Lock()
+	defer s.queues.Unlock()
+
+	_, ok = s.queues.m[id]
+	return !ok
+}
+
+// waitUntil checks done every millisecond until done returns true or until ctx is done
+func waitUntil(ctx context.Context, done func() bool) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}